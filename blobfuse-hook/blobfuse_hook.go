@@ -6,8 +6,12 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/bpradipt/kata-hooks/blobfuse-hook/internal"
+	"github.com/kata-hooks/hookutil"
 	spec "github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -23,11 +27,21 @@ const (
 // kataContainersPath = "/run/kata-containers"
 )
 
-func startBlobFuseOciHook(hookConfig internal.Config, debug bool) error {
+// OCI hook stages handled by the hook
+const (
+	stagePrestart  = "prestart"
+	stagePoststart = "poststart"
+	stagePoststop  = "poststop"
+)
+
+func startBlobFuseOciHook(hookConfig internal.Config, stage string, debug bool) error {
 	//Hook receives container State in Stdin
 	//https://github.com/opencontainers/runtime-spec/blob/master/config.md#posix-platform-hooks
 	//https://github.com/opencontainers/runtime-spec/blob/master/runtime.md#state
 
+	// The OCI hook timeout runs from the hook start
+	start := time.Now()
+
 	var s spec.State
 
 	reader := bufio.NewReader(os.Stdin)
@@ -37,7 +51,26 @@ func startBlobFuseOciHook(hookConfig internal.Config, debug bool) error {
 		return err
 	}
 
-	return doWork(s, hookConfig, debug)
+	// End the waits and kill the external programs before the runtime kills the hook
+	hookConfig.Deadline = hookDeadline(s.Bundle, stage, start)
+
+	switch stage {
+	case stagePrestart, stagePoststart:
+		// Mount at the stage of the mount mode only, the hook may be set up for both
+		mountStage, err := internal.MountStage(hookConfig)
+		if err != nil {
+			return err
+		}
+		if stage != mountStage {
+			log.Infof("Mount mode %q mounts at %s, nothing to do at %s\n", hookConfig.MountMode, mountStage, stage)
+			return nil
+		}
+		return doWork(s, hookConfig, debug)
+	case stagePoststop:
+		return doTeardown(s, hookConfig)
+	default:
+		return fmt.Errorf("invalid stage %q", stage)
+	}
 
 }
 
@@ -67,8 +100,15 @@ func doWork(s spec.State, hookConfig internal.Config, debug bool) error {
 	log.Printf("rootfsPath is %s\n", rootfsPath)
 
 	if debug {
-		log.Debugf("containerConfig contents: %v", containerConfig)
-		log.Debugf("hookConfig contents: %v", hookConfig)
+		// Env values may be secrets
+		redactedConfig := containerConfig
+		if containerConfig.Process != nil {
+			process := *containerConfig.Process
+			process.Env = internal.RedactEnv(process.Env)
+			redactedConfig.Process = &process
+		}
+		log.Debugf("containerConfig contents: %+v process: %+v", redactedConfig, redactedConfig.Process)
+		log.Debugf("hookConfig contents: %+v", internal.RedactConfig(hookConfig))
 
 	}
 
@@ -78,32 +118,80 @@ func doWork(s spec.State, hookConfig internal.Config, debug bool) error {
 		return nil
 	}
 
-	// Execute blobfuse
-	err = internal.ExecuteBlobFuseProcess(containerConfig.Process.Env, hookConfig)
+	// Apply the container env and annotation overrides allowed by the hook config
+	hookConfig, err = internal.ApplyOverrides(hookConfig, containerConfig.Process.Env, containerConfig.Annotations)
 	if err != nil {
-		log.Printf("unable to execute blobfuse process %s\n", err)
+		log.Printf("unable to apply container overrides %s\n", err)
 		return err
 	}
 
-	// Check if env variable CONTAINER_MOUNT_POINT is present in containerConfig.Process.Env by
-	// calling internal.GetContainerMountPoint
-	// If it returns "", set dstMountPoint to the value from hookConfig.ContainerMountPoint
-	// If it returns a non empty string, set dstMountPoint to the return value
+	// Get the mounts of this container
+	owner, err := internal.MountOwner(hookConfig, s.ID, containerConfig.Annotations)
+	if err != nil {
+		log.Printf("unable to get blobfuse mounts owner %s\n", err)
+		return err
+	}
 
-	containerMountPoint := internal.GetContainerMountPoint(containerConfig.Process.Env)
-	if containerMountPoint == "" {
-		containerMountPoint = hookConfig.ContainerMountPoint
+	mounts, err := internal.GetMounts(hookConfig, owner)
+	if err != nil {
+		log.Printf("unable to get blobfuse mounts %s\n", err)
+		return err
 	}
 
-	// Prepend rootfsPath to containerMountPoint
-	dstMountPoint := filepath.Join(rootfsPath, containerMountPoint)
+	// Mount each blob container independently, a failed mount doesn't stop the others
+	// The successful mounts are recorded for the poststop teardown
+	facts := internal.Facts{ContainerID: s.ID}
+	var mountStates []internal.MountState
+	var failedMounts []string
+	for _, mount := range mounts {
+		mountState, err := mountBlob(s.ID, s.Pid, rootfsPath, containerConfig, hookConfig, mount)
+		if err != nil {
+			log.Printf("mount %s failed %s\n", mount.Name, err)
+			failedMounts = append(failedMounts, mount.Name)
+			continue
+		}
+		log.Printf("mount %s succeeded\n", mount.Name)
+		facts.AddMount(mount)
+		mountState.Bundle = s.Bundle
+
+		mountStates = append(mountStates, mountState)
+		err = internal.UpdateContainerState(hookConfig, s.ID, func(state *internal.ContainerState) error {
+			state.Pid = s.Pid
+			state.SetMount(mountState)
+			return nil
+		})
+		if err != nil {
+			log.Printf("unable to record mount %s %s\n", mount.Name, err)
+		}
+	}
 
-	log.Printf("dstMountPoint is %s\n", dstMountPoint)
+	// Tell the workload where the blob storage is mounted
+	if len(facts.Mounts) != 0 {
+		// The container process already started when mounting at poststart
+		if hookConfig.MountMode == internal.MountModeInject {
+			if len(hookConfig.Env) != 0 {
+				log.Warnf("env defined in hook config is not added in %s mount mode, the workload has to read the facts file\n", internal.MountModeInject)
+			}
+		} else {
+			env, err := hookutil.RenderEnv(hookConfig.Env, facts)
+			if err != nil {
+				log.Printf("unable to render env defined in hook config %s\n", err)
+				return err
+			}
+			err = hookutil.AddEnvToOciSpec(&containerConfig, env)
+			if err != nil {
+				log.Printf("unable to add env defined in hook config %s\n", err)
+				return err
+			}
+		}
 
-	// Bind mount host mount point to container mount point
-	err = internal.BindMount(hookConfig.HostMountPoint, dstMountPoint)
-	if err != nil {
-		return err
+		if hookConfig.FactsFile != "" {
+			err = hookutil.WriteFactsFile(rootfsPath, hookConfig.FactsFile, internal.FactsHookName, facts)
+			if err != nil {
+				log.Printf("unable to write facts file %s\n", err)
+				return err
+			}
+		}
 	}
 
 	// Write the config.json file
@@ -112,14 +200,227 @@ func doWork(s spec.State, hookConfig internal.Config, debug bool) error {
 		return err
 	}
 
+	// Warm the caches once the workload can use the mounts
+	prefetchMounts(hookConfig, s.ID, mountStates)
+
+	if len(failedMounts) != 0 {
+		return fmt.Errorf("%d of %d mounts failed: %s", len(failedMounts), len(mounts), strings.Join(failedMounts, ", "))
+	}
+
 	return nil
 
 }
 
+// Mount a blob container into the container rootfs and return what was mounted
+// On failure the rendered blobfuse config and cache of the mount are removed
+func mountBlob(containerID string, containerPid int, rootfsPath string, containerConfig spec.Spec, hookConfig internal.Config, mount internal.Mount) (internal.MountState, error) {
+
+	log.Printf("Mounting %s at %s\n", mount.Name, mount.ContainerMountPoint)
+
+	mountState := internal.MountState{Mount: mount, Owner: mount.Owner, ProgramPath: hookConfig.ProgramPath, Config: &hookConfig}
+
+	var user spec.User
+	if containerConfig.Process != nil {
+		user = containerConfig.Process.User
+	}
+	values, err := internal.GetTemplateValues(containerID, hookConfig, mount, user)
+	if err != nil {
+		log.Printf("unable to get blobfuse config values %s\n", err)
+		return mountState, err
+	}
+
+	// Get the env and arguments of blobfuse, recorded to restart it
+	env, err := internal.BlobFuseProcessEnv(containerConfig.Process.Env, hookConfig.BlobFuseEnv, hookConfig.Credentials)
+	if err != nil {
+		log.Printf("unable to get blobfuse env %s\n", err)
+		return mountState, err
+	}
+	launch, err := internal.NewDaemonLaunch(env, hookConfig, values, internal.BlobFuseConfigFile(hookConfig, mount))
+	if err != nil {
+		log.Printf("unable to get blobfuse arguments %s\n", err)
+		return mountState, err
+	}
+	launch.NetNS, err = internal.DaemonNetNS(hookConfig, containerConfig, containerPid, mount.Owner)
+	if err != nil {
+		log.Printf("unable to get blobfuse network namespace %s\n", err)
+		return mountState, err
+	}
+	mountState.Launch = &launch
+
+	shared := internal.IsSharedOwner(mount.Owner, containerID)
+
+	// Start blobfuse on the host mount point
+	startBlobFuse := func() error {
+		// Clean a stale mount left by a crashed blobfuse, or reuse an identical live mount
+		reused, err := internal.PrepareHostMountPoint(mount, launch, shared)
+		if err != nil {
+			return err
+		}
+		if reused {
+			// Render the config to restart it, see DaemonLaunch
+			_, err := internal.RenderBlobFuseConfig(hookConfig, mount, values)
+			return err
+		}
+
+		// Create the cache directory of this mount
+		if hookConfig.ConfigTemplate != "" {
+			if err := internal.PrepareCacheDir(hookConfig, mount, values); err != nil {
+				log.Printf("unable to prepare cache directory %s\n", err)
+				cleanupMountRuntimeDir(hookConfig, mount)
+				return err
+			}
+		}
+
+		// Render the blobfuse config for this mount
+		_, err = internal.RenderBlobFuseConfig(hookConfig, mount, values)
+		if err != nil {
+			log.Printf("unable to render blobfuse config %s\n", err)
+			cleanupMountRuntimeDir(hookConfig, mount)
+			return err
+		}
+
+		// Execute blobfuse with the env allowed by the hook config
+		err = internal.StartDaemon(hookConfig, mount, launch)
+		if err != nil {
+			log.Printf("unable to execute blobfuse process %s\n", err)
+			cleanupMountRuntimeDir(hookConfig, mount)
+			return err
+		}
+		return nil
+	}
+
+	if shared {
+		specHash := internal.MountSpecHash(hookConfig, values)
+		err = internal.AcquireSharedMount(hookConfig, mount, specHash, containerID, startBlobFuse)
+	} else {
+		err = startBlobFuse()
+	}
+	if err != nil {
+		return mountState, err
+	}
+	mountState.Shared = shared
+	if hookConfig.Daemon.Cgroup != "" {
+		mountState.Cgroup = internal.DaemonCgroupDir(hookConfig.Daemon, mount)
+	}
+	// Only the file cache holds whole files at their path, see PrefetchStats
+	if hookConfig.ConfigTemplate != "" && values.CacheMode == internal.CacheModeFile {
+		mountState.CacheDir = values.CacheDir
+	}
+
+	// Mount in the container mount namespace
+	if hookConfig.MountMode == internal.MountModeInject {
+		err = internal.InjectMount(containerPid, mount.HostMountPoint, mount.ContainerMountPoint, mount.ReadOnly)
+		if err != nil {
+			abortMount(hookConfig, containerID, mountState)
+		}
+		return mountState, err
+	}
+
+	// Bind mount host mount point to container mount point in the rootfs
+	dstMountPoint, err := internal.BindMount(mount.HostMountPoint, rootfsPath, mount.ContainerMountPoint, mount.ReadOnly)
+	if err != nil {
+		abortMount(hookConfig, containerID, mountState)
+		return mountState, err
+	}
+
+	log.Printf("dstMountPoint is %s\n", dstMountPoint)
+	mountState.BindTarget = dstMountPoint
+	mountState.Rootfs = rootfsPath
+
+	return mountState, nil
+}
+
+// Tear down the mounts recorded for the container at mount time
+func doTeardown(s spec.State, hookConfig internal.Config) error {
+
+	log.Infof("Tearing down container %s: state (%s)\n", s.ID, s.Status)
+
+	if err := internal.TeardownContainer(hookConfig, s.ID); err != nil {
+		log.Printf("unable to tear down container %s %s\n", s.ID, err)
+		return err
+	}
+
+	return nil
+}
+
+// Get the deadline of the hook from its timeout in the bundle config.json, if any
+func hookDeadline(bundlePath string, stage string, start time.Time) time.Time {
+	containerConfig, err := internal.ReadOciConfigJson(filepath.Join(bundlePath, "config.json"))
+	if err != nil {
+		return time.Time{}
+	}
+	hookPath, err := os.Executable()
+	if err != nil {
+		log.Printf("unable to get the hook path %s\n", err)
+		return time.Time{}
+	}
+	deadline := internal.HookDeadline(containerConfig, stage, hookPath, start)
+	if !deadline.IsZero() {
+		log.Infof("Hook deadline in %s\n", time.Until(deadline).Round(time.Millisecond))
+	}
+	return deadline
+}
+
+// Prefetch the mounts with a prefetch list, before returning or in the background
+// A failed prefetch doesn't fail the hook, its stats are recorded in the state
+func prefetchMounts(hookConfig internal.Config, containerID string, mountStates []internal.MountState) {
+	for _, mountState := range mountStates {
+		if mountState.Mount.Prefetch == nil {
+			continue
+		}
+		if mountState.Mount.Prefetch.Background {
+			if err := internal.StartBackgroundPrefetch(hookConfig, containerID, mountState.Mount.Name); err != nil {
+				log.Printf("unable to prefetch mount %s %s\n", mountState.Mount.Name, err)
+			}
+			continue
+		}
+		internal.PrefetchMount(hookConfig, containerID, mountState)
+	}
+}
+
+// Undo a mount started for a container that couldn't be placed in the container
+// The fuse mount is unmounted and its daemon killed, unless other containers share it,
+// then the rendered blobfuse config and cache of the mount are removed
+func abortMount(hookConfig internal.Config, containerID string, mountState internal.MountState) {
+	mount := mountState.Mount
+	unmountFuse := func() error {
+		pids := internal.FindFuseDaemons(mountState.ProgramPath, mount.HostMountPoint)
+		err := internal.UnmountFuse(hookConfig, mountState.ProgramPath, mount.HostMountPoint)
+		if err != nil {
+			log.Printf("unable to unmount %s %s\n", mount.HostMountPoint, err)
+		}
+		// Don't leave the daemon running, the mount is not recorded for the teardown
+		for _, pid := range pids {
+			if err := syscall.Kill(pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+				log.Printf("unable to kill pid %d %s\n", pid, err)
+			}
+		}
+		internal.RemoveDaemonCgroup(mountState.Cgroup)
+		cleanupMountRuntimeDir(hookConfig, mount)
+		return nil
+	}
+
+	if !mountState.Shared {
+		unmountFuse()
+		return
+	}
+	if err := internal.ReleaseSharedMount(hookConfig, mount, containerID, unmountFuse); err != nil {
+		log.Printf("unable to release shared mount %s\n", err)
+	}
+}
+
+// Remove the rendered blobfuse config and cache of a mount that failed
+func cleanupMountRuntimeDir(hookConfig internal.Config, mount internal.Mount) {
+	if err := internal.RemoveMountRuntimeDir(hookConfig, mount); err != nil {
+		log.Printf("unable to remove runtime directory %s\n", err)
+	}
+}
+
 func main() {
 	var hookConfigFile string
 	var debug, version bool
 	var logFile string
+	var stage string
 
 	// Create a cmd line parser based on "github.com/spf13/cobra" package
 	rootCmd := &cobra.Command{
@@ -180,7 +481,7 @@ func main() {
 
 			log.Info("Starting Process OCI hook\n")
 
-			if err := startBlobFuseOciHook(hookConfig, debug); err != nil {
+			if err := startBlobFuseOciHook(hookConfig, stage, debug); err != nil {
 				//Hook should not fail
 				log.Info(err)
 				return
@@ -190,10 +491,14 @@ func main() {
 
 	rootCmd.Flags().BoolVarP(&debug, "debug", "d", false, "Enable debug mode (default is false)")
 	rootCmd.Flags().BoolVarP(&version, "version", "v", false, "Print the version")
-	rootCmd.Flags().StringVarP(&hookConfigFile, "config", "c", "/usr/share/oci/hooks/blobfuse_hookconfig.json", "Path to the hook config file (default /usr/share/oci/hooks/hookconfig.json))")
+	rootCmd.PersistentFlags().StringVarP(&hookConfigFile, "config", "c", "/usr/share/oci/hooks/blobfuse_hookconfig.json", "Path to the hook config file (default /usr/share/oci/hooks/hookconfig.json))")
+	rootCmd.Flags().StringVarP(&stage, "stage", "s", stagePrestart, "OCI hook stage: prestart or poststart mounts, depending on the mount mode, poststop tears down the mounts")
 	// Log file or create a temp file
 	rootCmd.Flags().StringVarP(&logFile, "log", "l", "", "Path to the log file. Default is to use temp file")
 
+	rootCmd.AddCommand(newSuperviseCommand(&hookConfigFile))
+	rootCmd.AddCommand(newPrefetchCommand())
+
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
@@ -24,9 +24,9 @@ func init() {
 // Create a test helper function which
 // Read state.json file and populate s (spec.State)
 // Read hookConfig.json file and populate hookConfig (internal.Config)
-// Copy config.json file to bundle directory
+// Copy config.json file to bundle directory, moved under dir
 
-func testHelper(stateJsonFile string, configJsonFile string, hookConfigJsonFile string) (spec.State, internal.Config, error) {
+func testHelper(dir string, stateJsonFile string, configJsonFile string, hookConfigJsonFile string) (spec.State, internal.Config, error) {
 
 	var s spec.State
 	var hookConfig internal.Config
@@ -46,6 +46,7 @@ func testHelper(stateJsonFile string, configJsonFile string, hookConfigJsonFile
 	}
 
 	// Create bundle directory
+	s.Bundle = filepath.Join(dir, filepath.Base(s.Bundle))
 	err = os.MkdirAll(s.Bundle, 0755)
 	if err != nil {
 		log.Errorf("unable to create bundle directory %s", err)
@@ -116,13 +117,20 @@ func Test_doWork(t *testing.T) {
 	}
 	for _, tt := range tests {
 		// Execute testHelper
-		s, hookConfig, err := testHelper(tt.args.stateJsonFile, tt.args.configJsonFile, tt.args.hookConfigJsonFile)
+		dir := t.TempDir()
+		s, hookConfig, err := testHelper(dir, tt.args.stateJsonFile, tt.args.configJsonFile, tt.args.hookConfigJsonFile)
 		if err != nil {
 			// If testHelper returns an error, fail the test
 			t.Errorf("testHelper() error = %v", err)
 			return
 		}
 
+		// Run the stub blobfuse2 in temp dirs, see newStub
+		stub, _ := newStub(t, stubBehavior{})
+		hookConfig.ProgramPath = stub.path()
+		hookConfig.HostMountPoint = filepath.Join(dir, "host")
+		hookConfig.RuntimeDir = filepath.Join(dir, "run")
+
 		t.Run(tt.name, func(t *testing.T) {
 			if err := doWork(s, hookConfig, tt.args.debug); (err != nil) != tt.wantErr {
 				t.Errorf("doWork() error = %v, wantErr %v", err, tt.wantErr)
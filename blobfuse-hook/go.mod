@@ -1,10 +1,19 @@
 module github.com/bpradipt/kata-hooks/blobfuse-hook
 
-go 1.13
+go 1.20
 
 require (
+	github.com/kata-hooks/hookutil v0.0.0
 	github.com/moby/sys/mount v0.3.3
 	github.com/opencontainers/runtime-spec v1.0.2
 	github.com/sirupsen/logrus v1.6.0
 	github.com/spf13/cobra v1.7.0
+	golang.org/x/sys v0.0.0-20220520151302-bc2c85ada10a
 )
+
+require (
+	github.com/moby/sys/mountinfo v0.6.2 // indirect
+	github.com/spf13/pflag v1.0.5 // indirect
+)
+
+replace github.com/kata-hooks/hookutil => ../hookutil
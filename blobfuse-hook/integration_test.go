@@ -0,0 +1,331 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/bpradipt/kata-hooks/blobfuse-hook/internal"
+	spec "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+// Create a struct to hold a container run by the hook with the stub blobfuse2, in temp dirs
+type hookTest struct {
+	dir        string
+	state      spec.State
+	hookConfig internal.Config
+	stub       *stub
+	mounter    *stubMounter
+	logs       *test.Hook
+}
+
+// Method to set up a container of the test data for the hook with the stub blobfuse2
+func newHookTest(t *testing.T, behavior stubBehavior) *hookTest {
+	dir := t.TempDir()
+	s, hookConfig, err := testHelper(dir, "test-data/state.json", "test-data/config.json", "test-data/hookconfig.json")
+	if err != nil {
+		t.Fatalf("testHelper() error = %v", err)
+	}
+
+	stub, mounter := newStub(t, behavior)
+	hookConfig.ProgramPath = stub.path()
+	hookConfig.HostMountPoint = filepath.Join(dir, "host")
+	hookConfig.RuntimeDir = filepath.Join(dir, "run")
+	hookConfig.MountPollMs = 10
+
+	logs := test.NewLocal(log)
+	t.Cleanup(func() { log.ReplaceHooks(make(logrus.LevelHooks)) })
+
+	return &hookTest{dir: dir, state: s, hookConfig: hookConfig, stub: stub, mounter: mounter, logs: logs}
+}
+
+// Return the default mount of the container
+func (h *hookTest) mount(t *testing.T) internal.Mount {
+	owner, err := internal.MountOwner(h.hookConfig, h.state.ID, nil)
+	if err != nil {
+		t.Fatalf("MountOwner() error = %v", err)
+	}
+	mounts, err := internal.GetMounts(h.hookConfig, owner)
+	if err != nil || len(mounts) != 1 {
+		t.Fatalf("GetMounts() = %v, %v, want 1 mount", mounts, err)
+	}
+	return mounts[0]
+}
+
+// Return the bind target of the mount in the container rootfs
+func (h *hookTest) bindTarget() string {
+	return filepath.Join(h.state.Bundle, "rootfs", h.hookConfig.ContainerMountPoint)
+}
+
+// Check if a message containing s was logged
+func (h *hookTest) logged(s string) bool {
+	for _, entry := range h.logs.AllEntries() {
+		if strings.Contains(entry.Message, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Set the env of the container process in the bundle config.json
+func (h *hookTest) setContainerEnv(t *testing.T, env []string) {
+	configJsonPath := filepath.Join(h.state.Bundle, "config.json")
+	containerConfig, err := internal.ReadOciConfigJson(configJsonPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	containerConfig.Process.Env = env
+	if err := internal.WriteOciConfigJson(configJsonPath, containerConfig); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHookActivation(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       []string
+		wantCalls int
+	}{
+		{name: "activation flag set", env: []string{"HOOK=true", "AZURE_STORAGE_ACCOUNT=coco"}, wantCalls: 1},
+		{name: "activation flag not set", env: []string{"AZURE_STORAGE_ACCOUNT=coco"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHookTest(t, stubBehavior{})
+			h.setContainerEnv(t, tt.env)
+
+			if err := doWork(h.state, h.hookConfig, false); err != nil {
+				t.Fatalf("doWork() error = %v", err)
+			}
+			if calls := h.stub.calls(); len(calls) != tt.wantCalls {
+				t.Errorf("blobfuse2 called %d times, want %d", len(calls), tt.wantCalls)
+			}
+			if _, bound := h.mounter.source(h.bindTarget()); bound != (tt.wantCalls != 0) {
+				t.Errorf("bind mounted = %v, want %v", bound, tt.wantCalls != 0)
+			}
+		})
+	}
+}
+
+func TestHookMount(t *testing.T) {
+	h := newHookTest(t, stubBehavior{})
+	template := filepath.Join(h.dir, "blobfuse2.yaml.tmpl")
+	err := os.WriteFile(template, []byte("account: {{ .AccountName }}\ncontainer: {{ .ContainerName }}\nmountpoint: {{ .MountPoint }}\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.hookConfig.ConfigTemplate = template
+	h.hookConfig.AccountName = "coco"
+	h.hookConfig.ContainerName = "blobfuse-container"
+	h.hookConfig.Cache.Mode = internal.CacheModeStream
+
+	if err := doWork(h.state, h.hookConfig, false); err != nil {
+		t.Fatalf("doWork() error = %v", err)
+	}
+	mount := h.mount(t)
+	configFile := internal.BlobFuseConfigFile(h.hookConfig, mount)
+
+	calls := h.stub.calls()
+	if len(calls) != 1 {
+		t.Fatalf("blobfuse2 called %d times, want 1", len(calls))
+	}
+	wantArgs := []string{"mount", mount.HostMountPoint, "--config-file=" + configFile}
+	if !reflect.DeepEqual(calls[0].Args, wantArgs) {
+		t.Errorf("blobfuse2 args = %v, want %v", calls[0].Args, wantArgs)
+	}
+
+	// Only the storage variables of the container and the host PATH are passed
+	env := make(map[string]bool)
+	for _, variable := range calls[0].Env {
+		env[strings.SplitN(variable, "=", 2)[0]] = true
+	}
+	for _, name := range []string{"AZURE_STORAGE_ACCOUNT", "AZURE_STORAGE_ACCESS_KEY", "PATH"} {
+		if !env[name] {
+			t.Errorf("blobfuse2 env is missing %s", name)
+		}
+	}
+	for _, name := range []string{"HOOK", "HOSTNAME", "KUBERNETES_SERVICE_HOST"} {
+		if env[name] {
+			t.Errorf("blobfuse2 env has %s", name)
+		}
+	}
+
+	// The config is rendered for the mount, readable by root only
+	info, err := os.Stat(configFile)
+	if err != nil {
+		t.Fatalf("config not rendered %s", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("config mode = %s, want 0600", info.Mode().Perm())
+	}
+	rendered, _ := os.ReadFile(configFile)
+	wantConfig := "account: coco\ncontainer: blobfuse-container\nmountpoint: " + mount.HostMountPoint + "\n"
+	if string(rendered) != wantConfig {
+		t.Errorf("rendered config = %q, want %q", rendered, wantConfig)
+	}
+
+	// The fuse mount is bound in the rootfs and recorded for the teardown
+	if source, _ := h.mounter.source(h.bindTarget()); source != mount.HostMountPoint {
+		t.Errorf("bind mount source = %q, want %q", source, mount.HostMountPoint)
+	}
+	state, err := internal.ReadContainerState(h.hookConfig, h.state.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Mounts) != 1 || state.Mounts[0].BindTarget != h.bindTarget() || state.Mounts[0].Launch == nil {
+		t.Errorf("recorded mounts = %+v", state.Mounts)
+	}
+	// The daemon env is recorded without its values, they are built again on restart
+	if len(state.Mounts) == 1 && state.Mounts[0].Launch != nil {
+		if !reflect.DeepEqual(state.Mounts[0].Launch.Env, internal.RedactEnv(calls[0].Env)) {
+			t.Errorf("recorded env = %v, want it redacted", state.Mounts[0].Launch.Env)
+		}
+		restartEnv, err := internal.RestartEnv(state.Mounts[0], *state.Mounts[0].Config)
+		if err != nil || !reflect.DeepEqual(restartEnv, calls[0].Env) {
+			t.Errorf("RestartEnv() = %v, %v, want %v", restartEnv, err, calls[0].Env)
+		}
+	}
+	// The hook config of the container is recorded to restart the daemon
+	if len(state.Mounts) == 1 && (state.Mounts[0].Config == nil || state.Mounts[0].Config.Cache.Mode != internal.CacheModeStream) {
+		t.Errorf("recorded hook config = %+v", state.Mounts[0].Config)
+	}
+}
+
+func TestHookMountFailure(t *testing.T) {
+	tests := []struct {
+		name      string
+		behavior  stubBehavior
+		configure func(*internal.Config)
+		wantLog   string
+	}{
+		{
+			name:     "blobfuse2 fails",
+			behavior: stubBehavior{ExitCode: 1, Stderr: "authentication failed"},
+			wantLog:  "exit status 1",
+		},
+		{
+			name:      "fuse mount not ready",
+			behavior:  stubBehavior{NoMount: true},
+			configure: func(c *internal.Config) { c.MountTimeoutSec = 1 },
+			wantLog:   "not ready after 1s",
+		},
+		{
+			name:      "blobfuse2 hangs",
+			behavior:  stubBehavior{Hang: true},
+			configure: func(c *internal.Config) { c.Timeouts.MountSec = 1 },
+			wantLog:   "blobfuse2 mount timed out after 1s",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHookTest(t, tt.behavior)
+			h.hookConfig.ConfigTemplate = filepath.Join(h.dir, "blobfuse2.yaml.tmpl")
+			if err := os.WriteFile(h.hookConfig.ConfigTemplate, []byte("account: {{ .AccountName }}\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if tt.configure != nil {
+				tt.configure(&h.hookConfig)
+			}
+
+			start := time.Now()
+			err := doWork(h.state, h.hookConfig, false)
+			if err == nil || !strings.Contains(err.Error(), "1 of 1 mounts failed") {
+				t.Fatalf("doWork() error = %v, want a failed mount", err)
+			}
+			if elapsed := time.Since(start); elapsed > 10*time.Second {
+				t.Errorf("doWork() failed after %s", elapsed)
+			}
+			if !h.logged(tt.wantLog) {
+				t.Errorf("no %q in the hook log", tt.wantLog)
+			}
+
+			// Rolled back: nothing bound or recorded, the rendered config is removed
+			if _, bound := h.mounter.source(h.bindTarget()); bound {
+				t.Errorf("failed mount is bound in the rootfs")
+			}
+			if _, err := os.Stat(internal.MountRuntimeDir(h.hookConfig, h.mount(t))); !os.IsNotExist(err) {
+				t.Errorf("runtime directory of the failed mount is left, %v", err)
+			}
+			if state, err := internal.ReadContainerState(h.hookConfig, h.state.ID); err == nil && len(state.Mounts) != 0 {
+				t.Errorf("failed mount is recorded %+v", state.Mounts)
+			}
+		})
+	}
+}
+
+func TestHookBindFailure(t *testing.T) {
+	for _, scope := range []string{internal.ScopeContainer, internal.ScopeNode} {
+		t.Run(scope, func(t *testing.T) {
+			h := newHookTest(t, stubBehavior{})
+			h.hookConfig.HostMountScope = scope
+			h.mounter.bindErr = syscall.EPERM
+
+			err := doWork(h.state, h.hookConfig, false)
+			if err == nil || !strings.Contains(err.Error(), "1 of 1 mounts failed") {
+				t.Fatalf("doWork() error = %v, want a failed mount", err)
+			}
+
+			// Rolled back: the fuse mount and the runtime directory are gone, nothing is recorded
+			mount := h.mount(t)
+			if mounted, _ := h.mounter.IsFuseMounted(mount.HostMountPoint); mounted {
+				t.Errorf("fuse mount at %s is left", mount.HostMountPoint)
+			}
+			if _, err := os.Stat(internal.MountRuntimeDir(h.hookConfig, mount)); !os.IsNotExist(err) {
+				t.Errorf("runtime directory of the failed mount is left, %v", err)
+			}
+			if state, err := internal.ReadContainerState(h.hookConfig, h.state.ID); err == nil && len(state.Mounts) != 0 {
+				t.Errorf("failed mount is recorded %+v", state.Mounts)
+			}
+
+			// The shared mount has no users left, the next container mounts it again
+			h.mounter.bindErr = nil
+			if err := doWork(h.state, h.hookConfig, false); err != nil {
+				t.Fatalf("doWork() after the failure error = %v", err)
+			}
+			if calls := h.stub.calls(); len(calls) != 2 {
+				t.Errorf("blobfuse2 called %d times, want 2", len(calls))
+			}
+		})
+	}
+}
+
+func TestHookTeardown(t *testing.T) {
+	h := newHookTest(t, stubBehavior{})
+	if err := doWork(h.state, h.hookConfig, false); err != nil {
+		t.Fatalf("doWork() error = %v", err)
+	}
+	mount := h.mount(t)
+	if mounted, _ := h.mounter.IsFuseMounted(mount.HostMountPoint); !mounted {
+		t.Fatalf("%s is not mounted", mount.HostMountPoint)
+	}
+
+	if err := doTeardown(h.state, h.hookConfig); err != nil {
+		t.Fatalf("doTeardown() error = %v", err)
+	}
+
+	if _, bound := h.mounter.source(h.bindTarget()); bound {
+		t.Errorf("bind mount in the rootfs is left")
+	}
+	if mounted, _ := h.mounter.IsFuseMounted(mount.HostMountPoint); mounted {
+		t.Errorf("fuse mount at %s is left", mount.HostMountPoint)
+	}
+	if !h.logged("unmounting " + mount.HostMountPoint + " lazily") {
+		t.Errorf("fuse mount not unmounted lazily without fusermount")
+	}
+	if _, err := os.Stat(internal.ContainerRuntimeDir(h.hookConfig, h.state.ID)); !os.IsNotExist(err) {
+		t.Errorf("runtime directory of the container is left, %v", err)
+	}
+
+	// A second teardown has nothing to do
+	if err := doTeardown(h.state, h.hookConfig); err != nil {
+		t.Errorf("doTeardown() of a torn down container error = %v", err)
+	}
+}
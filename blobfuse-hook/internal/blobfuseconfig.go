@@ -0,0 +1,273 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// Default location of the blobfuse2 config file used when no template is configured
+const DefaultBlobFuseConfigFile = "/etc/blobfuseconfig.yaml"
+
+// Default directory holding the per container runtime directories
+const DefaultRuntimeDir = "/run/blobfuse-hook"
+
+// Name of the rendered blobfuse2 config file in the per container runtime directory
+const renderedConfigFileName = "blobfuse2.yaml"
+
+// Prefix of the container annotations read by the hook
+// Eg. blobfuse.kata-hooks.io/account_name
+const AnnotationPrefix = "blobfuse.kata-hooks.io/"
+
+// Create a struct to hold the values available to the blobfuse2 config template
+// Eg. "account-name: {{ .AccountName }}"
+type TemplateValues struct {
+	ContainerID     string
+	MountName       string
+	MountPoint      string
+	ReadOnly        bool
+	Subdirectory    string
+	AllowOther      bool
+	UID             uint32
+	GID             uint32
+	AccountName     string
+	ContainerName   string
+	Endpoint        string
+	AuthMode        string
+	AuthConfig      string
+	CacheMode       string
+	CacheDir        string
+	CacheSizeMB     int
+	CacheTimeoutSec int
+}
+
+// Functions available to the blobfuse2 config template
+// Eg. "{{ indent 2 .AuthConfig }}", "account-name: {{ yaml .AccountName }}"
+var templateFuncs = template.FuncMap{
+	"indent": func(spaces int, s string) string {
+		pad := strings.Repeat(" ", spaces)
+		return pad + strings.Replace(s, "\n", "\n"+pad, -1)
+	},
+	"yaml": yamlValue,
+}
+
+// Method to quote a value as a YAML flow scalar, so that it can't add keys to the config
+// Strings are double quoted with their special characters escaped. JSON scalars are
+// valid YAML, and JSON escapes all the control characters
+func yamlValue(value interface{}) (string, error) {
+	var quoted bytes.Buffer
+	encoder := json.NewEncoder(&quoted)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(quoted.String(), "\n"), nil
+}
+
+// Method to collect the template values for a mount of a container
+// The hookConfig, with the container overrides applied, provides the defaults, overridden by the mount.
+// With hookConfig.Daemon.MapContainerUser, the files are owned by the container process user
+func GetTemplateValues(containerID string, hookConfig Config, mount Mount, user specs.User) (TemplateValues, error) {
+	values := TemplateValues{
+		ContainerID:     containerID,
+		MountName:       mount.Name,
+		MountPoint:      mount.HostMountPoint,
+		ReadOnly:        mount.ReadOnly,
+		Subdirectory:    mount.Subdirectory,
+		AccountName:     hookConfig.AccountName,
+		ContainerName:   hookConfig.ContainerName,
+		Endpoint:        hookConfig.Endpoint,
+		AuthMode:        hookConfig.AuthMode,
+		CacheDir:        CacheDir(hookConfig, mount),
+		CacheSizeMB:     hookConfig.CacheSizeMB,
+		CacheTimeoutSec: hookConfig.CacheTimeoutSec,
+	}
+
+	if mount.ContainerName != "" {
+		values.ContainerName = mount.ContainerName
+	}
+	cacheMode, err := cacheMode(hookConfig)
+	if err != nil {
+		return values, err
+	}
+	values.CacheMode = cacheMode
+
+	if hookConfig.Daemon.MapContainerUser {
+		values.AllowOther = true
+		values.UID = user.UID
+		values.GID = user.GID
+	}
+
+	if hookConfig.Credentials != nil && hookConfig.ConfigTemplate == "" {
+		return values, fmt.Errorf("credentials require a config template")
+	}
+	authConfig, err := AuthConfig(hookConfig)
+	if err != nil {
+		log.Printf("unable to get credentials %s\n", err)
+		return values, err
+	}
+	values.AuthConfig = authConfig
+
+	return values, nil
+}
+
+// Return the blobfuse2 config file of a mount: the rendered config in the runtime directory
+// of the mount, or the static hookConfig.ConfigFile if no template is configured
+func BlobFuseConfigFile(hookConfig Config, mount Mount) string {
+	if hookConfig.ConfigTemplate == "" {
+		if hookConfig.ConfigFile == "" {
+			return DefaultBlobFuseConfigFile
+		}
+		return hookConfig.ConfigFile
+	}
+	return filepath.Join(MountRuntimeDir(hookConfig, mount), renderedConfigFileName)
+}
+
+// Method to render the blobfuse2 config file for a mount of a container
+// The file is written 0600 to the per mount runtime directory and its path returned.
+// If no template is configured, the static hookConfig.ConfigFile is returned
+func RenderBlobFuseConfig(hookConfig Config, mount Mount, values TemplateValues) (string, error) {
+	if hookConfig.ConfigTemplate == "" {
+		return BlobFuseConfigFile(hookConfig, mount), nil
+	}
+
+	tmpl, err := template.New(filepath.Base(hookConfig.ConfigTemplate)).Funcs(templateFuncs).ParseFiles(hookConfig.ConfigTemplate)
+	if err != nil {
+		log.Printf("unable to parse blobfuse config template %s\n", err)
+		return "", err
+	}
+
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, values); err != nil {
+		log.Printf("unable to render blobfuse config template %s\n", err)
+		return "", err
+	}
+
+	if err := checkContainerID(mount.Owner); err != nil {
+		return "", err
+	}
+
+	runtimeDir := MountRuntimeDir(hookConfig, mount)
+	if err := mkdirRuntimeDir(hookConfig, runtimeDir); err != nil {
+		log.Printf("unable to create runtime directory %s\n", err)
+		return "", err
+	}
+
+	configFile := BlobFuseConfigFile(hookConfig, mount)
+	file, err := os.OpenFile(configFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Printf("unable to create blobfuse config file %s\n", err)
+		return "", err
+	}
+	defer file.Close()
+
+	// The file may already exist with other permissions
+	if err := file.Chmod(0600); err != nil {
+		return "", err
+	}
+	if _, err := file.Write(rendered.Bytes()); err != nil {
+		log.Printf("unable to write blobfuse config file %s\n", err)
+		return "", err
+	}
+
+	log.Printf("blobfuse config written to %s\n", configFile)
+	return configFile, nil
+}
+
+// Return the directory holding the per container runtime directories
+func runtimeBaseDir(hookConfig Config) string {
+	if hookConfig.RuntimeDir == "" {
+		return DefaultRuntimeDir
+	}
+	return hookConfig.RuntimeDir
+}
+
+// Method to create dir, for root only, in the runtime directory of the hook
+// A missing runtime directory is created searchable so that the daemons can reach their
+// runtime directories, see prepareDaemonDirs. The mode of an existing one is left as is
+func mkdirRuntimeDir(hookConfig Config, dir string) error {
+	baseDir := runtimeBaseDir(hookConfig)
+	if err := os.MkdirAll(filepath.Dir(baseDir), 0755); err != nil {
+		return err
+	}
+	if err := os.Mkdir(baseDir, 0711); err != nil && !os.IsExist(err) {
+		return err
+	}
+	return os.MkdirAll(dir, 0700)
+}
+
+// Return the runtime directory of a container, or of a mount owner, see MountOwner
+func ContainerRuntimeDir(hookConfig Config, containerID string) string {
+	return filepath.Join(runtimeBaseDir(hookConfig), containerID)
+}
+
+// Return the runtime directory of a mount
+func MountRuntimeDir(hookConfig Config, mount Mount) string {
+	return filepath.Join(ContainerRuntimeDir(hookConfig, mount.Owner), mount.Name)
+}
+
+// Method to remove the runtime directory of a mount
+// This removes the rendered blobfuse2 config and the cache directory of the mount
+func RemoveMountRuntimeDir(hookConfig Config, mount Mount) error {
+	if err := checkContainerID(mount.Owner); err != nil {
+		return err
+	}
+	if !mountNameRegexp.MatchString(mount.Name) {
+		return fmt.Errorf("invalid mount name %q", mount.Name)
+	}
+	if err := RemoveCacheDir(hookConfig, mount); err != nil {
+		return err
+	}
+
+	runtimeDir := MountRuntimeDir(hookConfig, mount)
+	log.Printf("Removing runtime directory %s\n", runtimeDir)
+	return os.RemoveAll(runtimeDir)
+}
+
+// Method to remove the runtime directory of a container
+// This removes the rendered blobfuse2 configs and the cache directories of all the mounts
+func RemoveContainerRuntimeDir(hookConfig Config, containerID string) error {
+	if err := checkContainerID(containerID); err != nil {
+		return err
+	}
+
+	runtimeDir := ContainerRuntimeDir(hookConfig, containerID)
+	log.Printf("Removing runtime directory %s\n", runtimeDir)
+	return os.RemoveAll(runtimeDir)
+}
+
+// The container id is used as a directory name and must not escape the runtime directory
+func checkContainerID(containerID string) error {
+	if containerID == "" || containerID == "." || containerID == ".." || strings.Contains(containerID, "/") {
+		return fmt.Errorf("invalid container id %q", containerID)
+	}
+	return nil
+}
+
+// Get the value of key from env entries of the form key=value
+// As with execve, the last entry for a key wins
+func GetEnvValue(env []string, key string) (string, bool) {
+	value, found := "", false
+	for _, envVar := range env {
+		if strings.HasPrefix(envVar, key+"=") {
+			value, found = strings.TrimPrefix(envVar, key+"="), true
+		}
+	}
+	return value, found
+}
+
+func setInt(dst *int, value string) error {
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	*dst = i
+	return nil
+}
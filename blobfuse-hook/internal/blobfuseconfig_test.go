@@ -0,0 +1,155 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
+)
+
+// Set the logger
+func init() {
+	SetLogger(logrus.New())
+}
+
+func TestGetTemplateValues(t *testing.T) {
+	hookConfig := Config{
+		HostMountPoint: "/blobdata",
+		AccountName:    "defaultaccount",
+		ContainerName:  "defaultcontainer",
+		RuntimeDir:     "/run/test",
+		CacheSizeMB:    1024,
+	}
+
+	tests := []struct {
+		name   string
+		config Config
+		mount  Mount
+		want   TemplateValues
+	}{
+		{
+			name:  "hook config defaults",
+			mount: Mount{Name: DefaultMountName, HostMountPoint: "/blobdata", Owner: "c1"},
+			want: TemplateValues{ContainerID: "c1", MountName: "default", MountPoint: "/blobdata", AccountName: "defaultaccount",
+				ContainerName: "defaultcontainer", CacheMode: CacheModeFile, CacheDir: "/run/test/c1/default/cache", CacheSizeMB: 1024},
+		},
+		{
+			name:  "mount overrides the storage container",
+			mount: Mount{Name: "input", ContainerName: "inputs", HostMountPoint: "/blobdata/c1/input", ReadOnly: true, Owner: "c1"},
+			want: TemplateValues{ContainerID: "c1", MountName: "input", MountPoint: "/blobdata/c1/input", ReadOnly: true, AccountName: "defaultaccount",
+				ContainerName: "inputs", CacheMode: CacheModeFile, CacheDir: "/run/test/c1/input/cache", CacheSizeMB: 1024},
+		},
+		{
+			name:   "container user",
+			config: Config{Daemon: DaemonConfig{MapContainerUser: true}},
+			mount:  Mount{Name: DefaultMountName, HostMountPoint: "/blobdata", Owner: "c1"},
+			want: TemplateValues{ContainerID: "c1", MountName: "default", MountPoint: "/blobdata", AllowOther: true, UID: 1000, GID: 1000,
+				AccountName: "defaultaccount", ContainerName: "defaultcontainer", CacheMode: CacheModeFile, CacheDir: "/run/test/c1/default/cache", CacheSizeMB: 1024},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := hookConfig
+			config.Daemon = tt.config.Daemon
+			got, err := GetTemplateValues("c1", config, tt.mount, specs.User{UID: 1000, GID: 1000})
+			if err != nil {
+				t.Fatalf("GetTemplateValues() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("GetTemplateValues() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestYamlValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  string
+	}{
+		{name: "string", value: "account", want: `"account"`},
+		{name: "empty string", value: "", want: `""`},
+		{name: "path", value: "/run/blobfuse-hook/c1/input/cache", want: `"/run/blobfuse-hook/c1/input/cache"`},
+		{name: "newline adding a key", value: "data\nendpoint: https://attacker", want: `"data\nendpoint: https://attacker"`},
+		{name: "quotes and yaml indicators", value: `a" # b: &c`, want: `"a\" # b: &c"`},
+		{name: "html characters are kept", value: "a<b>&c", want: `"a<b>&c"`},
+		{name: "control character", value: "a\x1bb", want: `"a\u001bb"`},
+		{name: "number", value: 1024, want: "1024"},
+		{name: "bool", value: true, want: "true"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := yamlValue(tt.value)
+			if err != nil || got != tt.want {
+				t.Errorf("yamlValue() = %s, %v, want %s", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderBlobFuseConfig(t *testing.T) {
+	dir := t.TempDir()
+	templateFile := filepath.Join(dir, "blobfuse2.yaml.tmpl")
+	if err := os.WriteFile(templateFile, []byte("account-name: {{ .AccountName }}\npath: {{ .CacheDir }}\nazstorage:\n{{ indent 2 .AuthConfig }}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	hookConfig := Config{ConfigTemplate: templateFile, RuntimeDir: filepath.Join(dir, "run")}
+	values := TemplateValues{ContainerID: "c1", MountName: "input", AccountName: "account", AuthConfig: "mode: key\naccount-key: k", CacheDir: filepath.Join(dir, "run", "c1", "input", "cache")}
+
+	mount := Mount{Name: "input", Owner: "c1"}
+	configFile, err := RenderBlobFuseConfig(hookConfig, mount, values)
+	if err != nil {
+		t.Fatalf("RenderBlobFuseConfig() error = %v", err)
+	}
+
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "account-name: account\npath: " + values.CacheDir + "\nazstorage:\n  mode: key\n  account-key: k\n"
+	if string(data) != want {
+		t.Errorf("rendered config = %q, want %q", data, want)
+	}
+
+	info, err := os.Stat(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("rendered config mode = %o, want 0600", info.Mode().Perm())
+	}
+
+	if err := RemoveMountRuntimeDir(hookConfig, mount); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(configFile); !os.IsNotExist(err) {
+		t.Errorf("rendered config not removed")
+	}
+
+	if _, err := RenderBlobFuseConfig(hookConfig, Mount{Name: "input", Owner: "../c1"}, values); err == nil {
+		t.Errorf("RenderBlobFuseConfig() accepted an invalid container id")
+	}
+
+	// Quoted values can't add keys
+	if err := os.WriteFile(templateFile, []byte("container: {{ yaml .ContainerName }}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	values.ContainerName = "data\nendpoint: https://attacker"
+	configFile, err = RenderBlobFuseConfig(hookConfig, mount, values)
+	if err != nil {
+		t.Fatalf("RenderBlobFuseConfig() error = %v", err)
+	}
+	data, err = os.ReadFile(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "container: \"data\\nendpoint: https://attacker\"\n"; string(data) != want {
+		t.Errorf("rendered config = %q, want %q", data, want)
+	}
+}
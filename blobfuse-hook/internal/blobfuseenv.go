@@ -0,0 +1,135 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Container env variables forwarded to blobfuse2 when the env policy has none
+var DefaultForwardEnv = []string{"AZURE_STORAGE_*"}
+
+// Host env variables passed to blobfuse2 when the env policy has none
+var DefaultHostEnv = []string{"PATH", "HOME"}
+
+// Value replacing env values in the logs
+const redactedValue = "<redacted>"
+
+// Create a struct to hold the env policy of the blobfuse2 process
+// Later sources take precedence: host, container, static, secret files
+type EnvPolicy struct {
+	// Host env variables to pass, path.Match patterns. Default is DefaultHostEnv
+	Host []string `json:"host,omitempty"`
+
+	// Container env variables to forward, path.Match patterns. Default is DefaultForwardEnv
+	// An empty list forwards nothing
+	Forward []string `json:"forward,omitempty"`
+
+	// Static env variables
+	Static map[string]string `json:"static,omitempty"`
+
+	// Env variables read from files, eg. {"AZURE_STORAGE_ACCESS_KEY": "/etc/blobfuse/access-key"}
+	// The trailing newline of the file is dropped
+	SecretFiles map[string]string `json:"secret_files,omitempty"`
+}
+
+// Method to build the env of the blobfuse2 process from the container env and the env policy
+// With hook credentials, the container env variables deciding where the credentials
+// are sent are not forwarded, see ApplyOverrides. The secret files are checked like
+// the credential files, see checkCredentialFile.
+// Returns a sorted slice of key=value strings
+func BlobFuseProcessEnv(containerEnv []string, policy EnvPolicy, creds *Credentials) ([]string, error) {
+	hostPatterns := policy.Host
+	if hostPatterns == nil {
+		hostPatterns = DefaultHostEnv
+	}
+	forwardPatterns := policy.Forward
+	if forwardPatterns == nil {
+		forwardPatterns = DefaultForwardEnv
+	}
+
+	values := make(map[string]string)
+	for _, entry := range filterEnv(os.Environ(), hostPatterns) {
+		kv := strings.SplitN(entry, "=", 2)
+		values[kv[0]] = kv[1]
+	}
+	for _, entry := range filterEnv(containerEnv, forwardPatterns) {
+		kv := strings.SplitN(entry, "=", 2)
+		if creds != nil && containsString(credentialEnvAliases(), kv[0]) {
+			log.Printf("not forwarding %s, it may not override the hook credentials\n", kv[0])
+			continue
+		}
+		values[kv[0]] = kv[1]
+	}
+	for key, value := range policy.Static {
+		values[key] = value
+	}
+	fileCreds := creds
+	if fileCreds == nil {
+		fileCreds = &Credentials{}
+	}
+	for key, secretFile := range policy.SecretFiles {
+		if err := checkCredentialFile(fileCreds, key, secretFile, true); err != nil {
+			log.Printf("unable to use secret file for %s %s\n", key, err)
+			return nil, fmt.Errorf("unable to use secret file for %s: %s", key, err)
+		}
+		data, err := os.ReadFile(secretFile)
+		if err != nil {
+			log.Printf("unable to read secret file for %s %s\n", key, err)
+			return nil, fmt.Errorf("unable to read secret file for %s: %s", key, err)
+		}
+		values[key] = strings.TrimRight(string(data), "\r\n")
+	}
+
+	env := make([]string, 0, len(values))
+	for key, value := range values {
+		env = append(env, key+"="+value)
+	}
+	sort.Strings(env)
+
+	return env, nil
+}
+
+// Return the entries of env with a key matching one of the patterns
+func filterEnv(env []string, patterns []string) []string {
+	var filtered []string
+	for _, entry := range env {
+		kv := strings.SplitN(entry, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			continue
+		}
+		for _, pattern := range patterns {
+			if matched, err := path.Match(pattern, kv[0]); err == nil && matched {
+				filtered = append(filtered, entry)
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+// Return a copy of env with the values redacted, for logging
+func RedactEnv(env []string) []string {
+	if env == nil {
+		return nil
+	}
+	redacted := make([]string, 0, len(env))
+	for _, entry := range env {
+		redacted = append(redacted, strings.SplitN(entry, "=", 2)[0]+"="+redactedValue)
+	}
+	return redacted
+}
+
+// Return a copy of hookConfig with the static blobfuse2 env values redacted, for logging
+func RedactConfig(hookConfig Config) Config {
+	if hookConfig.BlobFuseEnv.Static != nil {
+		static := make(map[string]string, len(hookConfig.BlobFuseEnv.Static))
+		for key := range hookConfig.BlobFuseEnv.Static {
+			static[key] = redactedValue
+		}
+		hookConfig.BlobFuseEnv.Static = static
+	}
+	return hookConfig
+}
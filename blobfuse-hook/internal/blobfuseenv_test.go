@@ -0,0 +1,113 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBlobFuseProcessEnv(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "access-key")
+	if err := os.WriteFile(keyFile, []byte("filekey\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	readableFile := filepath.Join(dir, "readable-key")
+	if err := os.WriteFile(readableFile, []byte("filekey\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", "/usr/bin")
+	t.Setenv("HOME", "/root")
+
+	containerEnv := []string{"HOOK=true", "DB_PASSWORD=app-secret", "AZURE_STORAGE_ACCOUNT=account", "AZURE_STORAGE_ACCESS_KEY=envkey",
+		"AZURE_STORAGE_BLOB_ENDPOINT=https://attacker.example.com"}
+
+	tests := []struct {
+		name    string
+		policy  EnvPolicy
+		creds   *Credentials
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "default policy",
+			want: []string{"AZURE_STORAGE_ACCESS_KEY=envkey", "AZURE_STORAGE_ACCOUNT=account",
+				"AZURE_STORAGE_BLOB_ENDPOINT=https://attacker.example.com", "HOME=/root", "PATH=/usr/bin"},
+		},
+		{
+			name:  "account and endpoint not forwarded with hook credentials",
+			creds: &Credentials{Source: CredentialSourceMSI},
+			want:  []string{"AZURE_STORAGE_ACCESS_KEY=envkey", "HOME=/root", "PATH=/usr/bin"},
+		},
+		{
+			name: "static env with hook credentials",
+			policy: EnvPolicy{
+				Host:   []string{},
+				Static: map[string]string{"AZURE_STORAGE_ACCOUNT": "staticaccount"},
+			},
+			creds: &Credentials{Source: CredentialSourceMSI},
+			want:  []string{"AZURE_STORAGE_ACCESS_KEY=envkey", "AZURE_STORAGE_ACCOUNT=staticaccount"},
+		},
+		{
+			name:   "forward nothing",
+			policy: EnvPolicy{Host: []string{"PATH"}, Forward: []string{}},
+			want:   []string{"PATH=/usr/bin"},
+		},
+		{
+			name: "static and secret files take precedence",
+			policy: EnvPolicy{
+				Host:        []string{},
+				Forward:     []string{"AZURE_STORAGE_ACCOUNT", "AZURE_STORAGE_ACCESS_KEY"},
+				Static:      map[string]string{"AZURE_STORAGE_ACCOUNT": "staticaccount", "AZURE_STORAGE_AUTH_TYPE": "key"},
+				SecretFiles: map[string]string{"AZURE_STORAGE_ACCESS_KEY": keyFile},
+			},
+			want: []string{"AZURE_STORAGE_ACCESS_KEY=filekey", "AZURE_STORAGE_ACCOUNT=staticaccount", "AZURE_STORAGE_AUTH_TYPE=key"},
+		},
+		{
+			name:    "secret file readable by others",
+			policy:  EnvPolicy{SecretFiles: map[string]string{"AZURE_STORAGE_ACCESS_KEY": readableFile}},
+			wantErr: true,
+		},
+		{
+			name:    "secret file of another owner",
+			policy:  EnvPolicy{SecretFiles: map[string]string{"AZURE_STORAGE_ACCESS_KEY": keyFile}},
+			creds:   &Credentials{Source: CredentialSourceMSI, FileOwners: []int{12345}},
+			wantErr: true,
+		},
+		{
+			name:    "missing secret file",
+			policy:  EnvPolicy{SecretFiles: map[string]string{"AZURE_STORAGE_ACCESS_KEY": filepath.Join(dir, "missing")}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := BlobFuseProcessEnv(containerEnv, tt.policy, tt.creds)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("BlobFuseProcessEnv() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("BlobFuseProcessEnv() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactEnv(t *testing.T) {
+	got := RedactEnv([]string{"AZURE_STORAGE_ACCESS_KEY=secret", "EMPTY="})
+	want := []string{"AZURE_STORAGE_ACCESS_KEY=<redacted>", "EMPTY=<redacted>"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("RedactEnv() = %v, want %v", got, want)
+	}
+
+	hookConfig := Config{BlobFuseEnv: EnvPolicy{Static: map[string]string{"AZURE_STORAGE_SAS_TOKEN": "secret"}}}
+	redacted := RedactConfig(hookConfig)
+	if redacted.BlobFuseEnv.Static["AZURE_STORAGE_SAS_TOKEN"] != "<redacted>" {
+		t.Errorf("RedactConfig() did not redact the static env")
+	}
+	if hookConfig.BlobFuseEnv.Static["AZURE_STORAGE_SAS_TOKEN"] != "secret" {
+		t.Errorf("RedactConfig() modified the hook config")
+	}
+}
@@ -0,0 +1,127 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Cache modes of blobfuse2
+// file_cache: whole files are cached on disk (default)
+// block_cache: blocks are cached in memory and on disk
+// stream: nothing is cached on disk
+const (
+	CacheModeFile   = "file_cache"
+	CacheModeBlock  = "block_cache"
+	CacheModeStream = "stream"
+)
+
+// Create a struct to hold where and how the mounts cache files
+type CacheConfig struct {
+	// Cache mode: file_cache (default), block_cache or stream, see CacheMode*
+	Mode string `json:"mode,omitempty"`
+
+	// Directory holding the cache directories of the mounts, eg. a scratch volume.
+	// Default is the runtime directory of each mount
+	Dir string `json:"dir,omitempty"`
+
+	// Mount a tmpfs of cache_size_mb on the cache directory of each mount
+	Tmpfs bool `json:"tmpfs,omitempty"`
+
+	// Minimum free space in MB left on the cache filesystem once the cache is full
+	MinFreeMB int `json:"min_free_mb,omitempty"`
+}
+
+// Return the cache mode of the hook config
+func cacheMode(hookConfig Config) (string, error) {
+	switch hookConfig.Cache.Mode {
+	case "":
+		return CacheModeFile, nil
+	case CacheModeFile, CacheModeBlock, CacheModeStream:
+		return hookConfig.Cache.Mode, nil
+	default:
+		return "", fmt.Errorf("invalid cache mode %q", hookConfig.Cache.Mode)
+	}
+}
+
+// Return the cache directory of a mount
+func CacheDir(hookConfig Config, mount Mount) string {
+	if hookConfig.Cache.Dir == "" {
+		return filepath.Join(MountRuntimeDir(hookConfig, mount), "cache")
+	}
+	return filepath.Join(hookConfig.Cache.Dir, mount.Owner, mount.Name)
+}
+
+// Method to create the cache directory of a mount
+// The directory is on a tmpfs capped at values.CacheSizeMB if configured. Otherwise the
+// filesystem must have room for the cache plus the minimum free space.
+// Nothing is created in stream mode
+func PrepareCacheDir(hookConfig Config, mount Mount, values TemplateValues) error {
+	if values.CacheMode == CacheModeStream {
+		return nil
+	}
+	if err := checkContainerID(mount.Owner); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(values.CacheDir, 0700); err != nil {
+		log.Printf("unable to create cache directory %s\n", err)
+		return err
+	}
+
+	if hookConfig.Cache.Tmpfs {
+		if values.CacheSizeMB <= 0 {
+			return fmt.Errorf("tmpfs cache requires cache_size_mb")
+		}
+		options := fmt.Sprintf("size=%dm,mode=0700,nosuid,nodev,noexec", values.CacheSizeMB)
+		if err := mounter.Mount("tmpfs", values.CacheDir, "tmpfs", options); err != nil {
+			log.Printf("unable to mount cache tmpfs %s\n", err)
+			return err
+		}
+		log.Printf("cache tmpfs of %dMB mounted at %s\n", values.CacheSizeMB, values.CacheDir)
+		return nil
+	}
+
+	return checkCacheSpace(values.CacheDir, values.CacheSizeMB, hookConfig.Cache.MinFreeMB)
+}
+
+// Check that the filesystem of cacheDir has room for a cache of cacheSizeMB plus minFreeMB
+func checkCacheSpace(cacheDir string, cacheSizeMB int, minFreeMB int) error {
+	if minFreeMB <= 0 {
+		return nil
+	}
+
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(cacheDir, &stat); err != nil {
+		log.Printf("unable to stat cache filesystem %s\n", err)
+		return err
+	}
+	freeMB := int64(stat.Bavail) * int64(stat.Bsize) >> 20
+
+	if freeMB-int64(cacheSizeMB) < int64(minFreeMB) {
+		return fmt.Errorf("not enough space for the cache in %s: %dMB free, need %dMB cache plus %dMB free",
+			cacheDir, freeMB, cacheSizeMB, minFreeMB)
+	}
+	log.Printf("cache filesystem has %dMB free\n", freeMB)
+	return nil
+}
+
+// Method to remove the cache directory of a mount, unmounting its tmpfs
+func RemoveCacheDir(hookConfig Config, mount Mount) error {
+	cacheDir := CacheDir(hookConfig, mount)
+	if err := mounter.Unmount(cacheDir, syscall.MNT_DETACH); err != nil && err != syscall.EINVAL && err != syscall.ENOENT {
+		log.Printf("unable to unmount cache tmpfs %s\n", err)
+		return err
+	}
+
+	log.Printf("Removing cache directory %s\n", cacheDir)
+	if err := os.RemoveAll(cacheDir); err != nil {
+		return err
+	}
+	if hookConfig.Cache.Dir != "" && mount.Owner != "" {
+		// Remove the owner directory once its last cache is gone
+		os.Remove(filepath.Dir(cacheDir))
+	}
+	return nil
+}
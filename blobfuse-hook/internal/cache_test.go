@@ -0,0 +1,95 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPrepareCacheDir(t *testing.T) {
+	dir := t.TempDir()
+	mount := Mount{Name: "input", Owner: "c1"}
+
+	tests := []struct {
+		name      string
+		cache     CacheConfig
+		cacheSize int
+		wantDir   string
+		wantErr   bool
+		needsRoot bool
+		noCache   bool
+	}{
+		{
+			name:    "runtime directory by default",
+			wantDir: filepath.Join(dir, "run", "c1", "input", "cache"),
+		},
+		{
+			name:    "scratch volume",
+			cache:   CacheConfig{Dir: filepath.Join(dir, "scratch"), MinFreeMB: 1},
+			wantDir: filepath.Join(dir, "scratch", "c1", "input"),
+		},
+		{
+			name:    "not enough free space",
+			cache:   CacheConfig{MinFreeMB: 1 << 40},
+			wantErr: true,
+		},
+		{
+			name:    "stream mode has no cache",
+			cache:   CacheConfig{Mode: CacheModeStream},
+			wantDir: filepath.Join(dir, "run", "c1", "input", "cache"),
+			noCache: true,
+		},
+		{
+			name:      "tmpfs",
+			cache:     CacheConfig{Tmpfs: true, MinFreeMB: 1 << 40},
+			cacheSize: 16,
+			wantDir:   filepath.Join(dir, "run", "c1", "input", "cache"),
+			needsRoot: true,
+		},
+		{
+			name:    "tmpfs without size",
+			cache:   CacheConfig{Tmpfs: true},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.needsRoot && os.Geteuid() != 0 {
+				t.Skip("mounting a tmpfs requires root")
+			}
+			hookConfig := Config{RuntimeDir: filepath.Join(dir, "run"), Cache: tt.cache, CacheSizeMB: tt.cacheSize}
+			mode, err := cacheMode(hookConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			values := TemplateValues{CacheMode: mode, CacheDir: CacheDir(hookConfig, mount), CacheSizeMB: tt.cacheSize}
+
+			err = PrepareCacheDir(hookConfig, mount, values)
+			defer RemoveMountRuntimeDir(hookConfig, mount)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PrepareCacheDir() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if values.CacheDir != tt.wantDir {
+				t.Errorf("cache directory = %s, want %s", values.CacheDir, tt.wantDir)
+			}
+			if _, err := os.Stat(tt.wantDir); os.IsNotExist(err) != tt.noCache {
+				t.Errorf("cache directory exists = %v, want %v", !os.IsNotExist(err), !tt.noCache)
+			}
+
+			if err := RemoveMountRuntimeDir(hookConfig, mount); err != nil {
+				t.Fatalf("RemoveMountRuntimeDir() error = %v", err)
+			}
+			if _, err := os.Stat(tt.wantDir); !os.IsNotExist(err) {
+				t.Errorf("cache directory not removed")
+			}
+		})
+	}
+
+	if _, err := cacheMode(Config{Cache: CacheConfig{Mode: "memory"}}); err == nil {
+		t.Errorf("cacheMode() accepted an invalid mode")
+	}
+}
@@ -2,9 +2,12 @@ package internal
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"strings"
+	"time"
 
+	"github.com/kata-hooks/hookutil"
 	"github.com/sirupsen/logrus"
 )
 
@@ -26,7 +29,15 @@ type Config struct {
 	// If the ActivationFlag is present in containerConfig.Process.Env, then the hook will be activated
 	ActivationFlag string `json:"activation_flag,omitempty"`
 
-	// Blobfuse program path
+	// Fuse driver: blobfuse2 (default), s3fs, gcsfuse, rclone or argv, see Driver
+	Driver string `json:"driver,omitempty"`
+
+	// Argument templates of the argv driver, rendered with the template values and .ConfigFile
+	// Options go first, then "--" before the positional arguments when the program supports it
+	// Eg. ["-oro", "--", "{{ .AccountName }}@host:/{{ .ContainerName }}", "{{ .MountPoint }}"]
+	DriverArgs []string `json:"driver_args,omitempty"`
+
+	// Fuse program path. Defaults to the program of the driver
 	ProgramPath string `json:"program_path"`
 
 	// Host mountpoint
@@ -34,6 +45,102 @@ type Config struct {
 
 	// Container mountpoint
 	ContainerMountPoint string `json:"container_mountpoint"`
+
+	// Privileges and resource limits of the fuse daemon
+	Daemon DaemonConfig `json:"daemon,omitempty"`
+
+	// Time to wait for the fuse mount to appear after starting blobfuse2. Default is 30
+	MountTimeoutSec int `json:"mount_timeout_sec,omitempty"`
+
+	// Initial interval between the fuse mount checks, doubled up to 1s. Default is 50
+	MountPollMs int `json:"mount_poll_ms,omitempty"`
+
+	// Time to wait for the fuse daemon to exit after unmounting at poststop. Default is 10
+	UnmountTimeoutSec int `json:"unmount_timeout_sec,omitempty"`
+
+	// Time limits of the external programs run by the hook, see CommandTimeouts
+	Timeouts CommandTimeouts `json:"timeouts,omitempty"`
+
+	// Deadline of the hook derived from its OCI hook timeout, see HookDeadline
+	// Set by the hook, not configurable. The waits of the hook end before it
+	Deadline time.Time `json:"-"`
+
+	// Health checks and restarts of the fuse daemons by "blobfuse-hook supervise"
+	Supervisor SupervisorConfig `json:"supervisor,omitempty"`
+
+	// Scope of the host mountpoints: "container" (default), "pod" or "node"
+	// Mounts are isolated per container. In pod and node scope, identical
+	// mounts are shared with reference counting
+	HostMountScope string `json:"host_mount_scope,omitempty"`
+
+	// How the mounts are placed in the container: "rootfs" (default) binds them in the
+	// bundle rootfs at prestart, "inject" mounts them in the container mount namespace
+	// at poststart, which also works with a private rootfs propagation. See InjectMount
+	MountMode string `json:"mount_mode,omitempty"`
+
+	// Mount the single mount described by container_mountpoint read-only
+	ReadOnly bool `json:"read_only,omitempty"`
+
+	// Virtual directory of the storage container mounted by the single mount
+	Subdirectory string `json:"subdirectory,omitempty"`
+
+	// Files read through the single mount once mounted to warm the cache, see PrefetchConfig
+	Prefetch *PrefetchConfig `json:"prefetch,omitempty"`
+
+	// Blob mounts of a container. When empty, host_mountpoint and container_mountpoint
+	// describe a single mount. Containers can replace the list with BLOBFUSE_MOUNTS
+	Mounts []Mount `json:"mounts,omitempty"`
+
+	// Extra command line flags of the fuse program. Eg. ["--log-level=LOG_DEBUG"]
+	BlobFuseFlags []string `json:"blobfuse_flags,omitempty"`
+
+	// Env of the blobfuse2 process. The container env is not forwarded as is,
+	// only the variables allowed by the policy
+	BlobFuseEnv EnvPolicy `json:"blobfuse_env,omitempty"`
+
+	// Fields containers may override with BLOBFUSE_<FIELD> env variables or
+	// blobfuse.kata-hooks.io/<field> annotations, mapped to the allowed values.
+	// Values are path.Match patterns, an empty list allows any value.
+	// Eg. {"container_mountpoint": ["/data/*"], "read_only": ["true"]}
+	// When not set, DefaultOverrides applies and containers may only override the container mountpoint
+	Overrides map[string][]string `json:"overrides,omitempty"`
+
+	// Path to a blobfuse2 config template (Go text/template) rendered per container
+	// See TemplateValues for the available values
+	ConfigTemplate string `json:"config_template,omitempty"`
+
+	// Static blobfuse2 config file used when no template is configured
+	// Default is /etc/blobfuseconfig.yaml
+	ConfigFile string `json:"config_file,omitempty"`
+
+	// Directory holding the per container rendered config and cache
+	// Default is /run/blobfuse-hook
+	RuntimeDir string `json:"runtime_dir,omitempty"`
+
+	// Credentials of the storage account, rendered into the config template
+	// When set, auth_mode is ignored and containers may not override account_name,
+	// endpoint or auth_mode. See Credentials
+	Credentials *Credentials `json:"credentials,omitempty"`
+
+	// Cache of the mounts, see CacheConfig
+	Cache CacheConfig `json:"cache,omitempty"`
+
+	// Template value defaults, overridden by the container env and annotations
+	AccountName     string `json:"account_name,omitempty"`
+	ContainerName   string `json:"container_name,omitempty"`
+	Endpoint        string `json:"endpoint,omitempty"`
+	AuthMode        string `json:"auth_mode,omitempty"`
+	CacheSizeMB     int    `json:"cache_size_mb,omitempty"`
+	CacheTimeoutSec int    `json:"cache_timeout_sec,omitempty"`
+
+	// Env to add to the container process once the blob storage is mounted, at
+	// prestart. Not added in inject mount mode, see FactsFile
+	// Values are templates rendered with the hook facts
+	// Eg. {"BLOBFUSE_MOUNT_POINT": "{{ .ContainerMountPoint }}"}
+	Env map[string]string `json:"env,omitempty"`
+
+	// Path of the facts file in the container rootfs. Eg. /run/kata-hooks/facts.json
+	FactsFile string `json:"facts_file,omitempty"`
 }
 
 // Create a method to read the configuration file
@@ -54,6 +161,19 @@ func ReadConfig(configFile string) (Config, error) {
 		return config, err
 	}
 
+	// Default to the program of the driver
+	driver, err := GetDriver(config)
+	if err != nil {
+		log.Printf("unable to get driver %s\n", err)
+		return config, err
+	}
+	if config.ProgramPath == "" {
+		config.ProgramPath = driver.DefaultProgramPath()
+	}
+	if config.ProgramPath == "" {
+		return config, fmt.Errorf("driver %s requires a program path", config.Driver)
+	}
+
 	// Return the configuration
 	return config, nil
 }
@@ -61,15 +181,20 @@ func ReadConfig(configFile string) (Config, error) {
 // Set the logger
 func SetLogger(logger *logrus.Logger) {
 	log = logger
+	hookutil.SetLogger(logger)
 }
 
 // Method to check if ActivationFlag is present in a slice of strings
+// An empty activation flag is never present
 func IsActivationFlagPresent(env []string, activationFlag string) bool {
 	log.Printf("Searching for activation flag %s\n", activationFlag)
+	if activationFlag == "" {
+		return false
+	}
 	for _, val := range env {
 		// env strings are of the form key=value
 		// Match key with activationFlag
-		if strings.Contains(val, activationFlag) {
+		if strings.SplitN(val, "=", 2)[0] == activationFlag {
 			log.Printf("Activation flag %s is present\n", activationFlag)
 			return true
 		}
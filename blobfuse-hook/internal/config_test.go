@@ -0,0 +1,29 @@
+package internal
+
+import (
+	"testing"
+)
+
+func TestIsActivationFlagPresent(t *testing.T) {
+	tests := []struct {
+		name string
+		env  []string
+		flag string
+		want bool
+	}{
+		{name: "no env", flag: "HOOK"},
+		{name: "flag present", env: []string{"PATH=/usr/bin", "HOOK=true"}, flag: "HOOK", want: true},
+		{name: "flag without value", env: []string{"HOOK"}, flag: "HOOK", want: true},
+		{name: "flag in another key", env: []string{"BLOBFUSE_HOOK=true", "HOOKS=1"}, flag: "HOOK"},
+		{name: "flag in a value", env: []string{"NAME=HOOK"}, flag: "HOOK"},
+		{name: "empty flag", env: []string{"PATH=/usr/bin", "=", ""}, flag: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsActivationFlagPresent(tt.env, tt.flag); got != tt.want {
+				t.Errorf("IsActivationFlagPresent() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// Credential sources
+// key: storage account key read from a file
+// sas: SAS token read from a file
+// workload_identity: Kubernetes projected service account token exchanged for an Azure AD token
+// msi: managed identity
+// spn: service principal with a client secret read from a file
+const (
+	CredentialSourceKey              = "key"
+	CredentialSourceSAS              = "sas"
+	CredentialSourceWorkloadIdentity = "workload_identity"
+	CredentialSourceMSI              = "msi"
+	CredentialSourceSPN              = "spn"
+)
+
+// Create a struct to hold the credentials of the storage account
+// The matching blobfuse2 azstorage auth settings are rendered into the config template, see AuthConfig
+type Credentials struct {
+	// Credential source, see CredentialSource*
+	Source string `json:"source"`
+
+	// File holding the storage account key, for the key source
+	AccountKeyFile string `json:"account_key_file,omitempty"`
+
+	// File holding the SAS token, for the sas source
+	SASFile string `json:"sas_file,omitempty"`
+
+	// Projected service account token file, for the workload_identity source
+	// Eg. /var/run/secrets/azure/tokens/azure-identity-token
+	TokenFile string `json:"token_file,omitempty"`
+
+	// File holding the client secret, for the spn source
+	ClientSecretFile string `json:"client_secret_file,omitempty"`
+
+	// Application (client) ID, for the msi, spn and workload_identity sources
+	ClientID string `json:"client_id,omitempty"`
+
+	// Tenant ID, for the spn and workload_identity sources
+	TenantID string `json:"tenant_id,omitempty"`
+
+	// Object ID or resource ID of a user assigned managed identity, for the msi source
+	ObjectID   string `json:"object_id,omitempty"`
+	ResourceID string `json:"resource_id,omitempty"`
+
+	// Users allowed to own the credential files. Default is root and the hook user
+	FileOwners []int `json:"file_owners,omitempty"`
+}
+
+// Method to render the blobfuse2 azstorage auth settings, one "key: value" per line
+// Without credentials, only the mode is rendered from hookConfig.AuthMode.
+// Credential files are checked before use, see checkCredentialFile
+func AuthConfig(hookConfig Config) (string, error) {
+	creds := hookConfig.Credentials
+	if creds == nil {
+		if hookConfig.AuthMode == "" {
+			return "", nil
+		}
+		mode, err := yamlValue(hookConfig.AuthMode)
+		if err != nil {
+			return "", err
+		}
+		return "mode: " + mode, nil
+	}
+
+	settings := make(map[string]string)
+	var err error
+	switch creds.Source {
+	case CredentialSourceKey:
+		settings["mode"] = "key"
+		settings["account-key"], err = readCredentialFile(creds, "account_key_file", creds.AccountKeyFile, true)
+	case CredentialSourceSAS:
+		settings["mode"] = "sas"
+		settings["sas"], err = readCredentialFile(creds, "sas_file", creds.SASFile, true)
+	case CredentialSourceWorkloadIdentity:
+		if creds.ClientID == "" || creds.TenantID == "" {
+			return "", fmt.Errorf("workload_identity credentials require client_id and tenant_id")
+		}
+		// The token is rotated by the kubelet, blobfuse2 reads it from the file
+		if err := checkCredentialFile(creds, "token_file", creds.TokenFile, false); err != nil {
+			return "", err
+		}
+		settings["mode"] = "spn"
+		settings["clientid"] = creds.ClientID
+		settings["tenantid"] = creds.TenantID
+		settings["oauth-token-path"] = creds.TokenFile
+	case CredentialSourceMSI:
+		settings["mode"] = "msi"
+		settings["appid"] = creds.ClientID
+		settings["objid"] = creds.ObjectID
+		settings["resid"] = creds.ResourceID
+	case CredentialSourceSPN:
+		if creds.ClientID == "" || creds.TenantID == "" {
+			return "", fmt.Errorf("spn credentials require client_id and tenant_id")
+		}
+		settings["mode"] = "spn"
+		settings["clientid"] = creds.ClientID
+		settings["tenantid"] = creds.TenantID
+		settings["clientsecret"], err = readCredentialFile(creds, "client_secret_file", creds.ClientSecretFile, true)
+	default:
+		return "", fmt.Errorf("invalid credential source %q", creds.Source)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	keys := make([]string, 0, len(settings))
+	for key, value := range settings {
+		if value != "" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	lines := make([]string, 0, len(keys))
+	for _, key := range keys {
+		value, err := yamlValue(settings[key])
+		if err != nil {
+			return "", err
+		}
+		lines = append(lines, key+": "+value)
+	}
+
+	log.Printf("Using %s credentials\n", creds.Source)
+	return strings.Join(lines, "\n"), nil
+}
+
+// Read a credential file after checking it, dropping the trailing newline
+func readCredentialFile(creds *Credentials, field string, credentialFile string, secret bool) (string, error) {
+	if err := checkCredentialFile(creds, field, credentialFile, secret); err != nil {
+		return "", err
+	}
+
+	data, err := os.ReadFile(credentialFile)
+	if err != nil {
+		log.Printf("unable to read credential file %s\n", err)
+		return "", err
+	}
+
+	value := strings.TrimRight(string(data), "\r\n")
+	if value == "" {
+		return "", fmt.Errorf("credential file %s is empty", credentialFile)
+	}
+	return value, nil
+}
+
+// Check that a credential file is a regular file owned by an allowed user and not writable
+// by group or others. Secret files must not be accessible by group or others at all
+func checkCredentialFile(creds *Credentials, field string, credentialFile string, secret bool) error {
+	if credentialFile == "" {
+		return fmt.Errorf("%s credentials require %s", creds.Source, field)
+	}
+
+	// Follow symlinks, projected volumes are symlinked
+	info, err := os.Stat(credentialFile)
+	if err != nil {
+		log.Printf("unable to stat credential file %s\n", err)
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("credential file %s is not a regular file", credentialFile)
+	}
+
+	perm := info.Mode().Perm()
+	if perm&0022 != 0 {
+		return fmt.Errorf("credential file %s is writable by group or others (mode %o)", credentialFile, perm)
+	}
+	if secret && perm&0077 != 0 {
+		return fmt.Errorf("credential file %s is accessible by group or others (mode %o)", credentialFile, perm)
+	}
+
+	owners := creds.FileOwners
+	if len(owners) == 0 {
+		owners = []int{0, os.Geteuid()}
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("unable to get the owner of credential file %s", credentialFile)
+	}
+	for _, owner := range owners {
+		if int(stat.Uid) == owner {
+			return nil
+		}
+	}
+	return fmt.Errorf("credential file %s is owned by uid %d, allowed owners are %v", credentialFile, stat.Uid, owners)
+}
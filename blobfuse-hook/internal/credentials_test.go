@@ -0,0 +1,110 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAuthConfig(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name string, content string, perm os.FileMode) string {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte(content), perm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(file, perm); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+	keyFile := writeFile("key", "accountkey\n", 0600)
+	openKeyFile := writeFile("open-key", "accountkey\n", 0644)
+	sasFile := writeFile("sas", "sv=2022&sig=abc", 0400)
+	tokenFile := writeFile("token", "jwt", 0644)
+	writableTokenFile := writeFile("writable-token", "jwt", 0666)
+	emptyFile := writeFile("empty", "\n", 0600)
+
+	tests := []struct {
+		name       string
+		hookConfig Config
+		want       string
+		wantErr    bool
+	}{
+		{
+			name: "no credentials",
+		},
+		{
+			name:       "auth mode without credentials",
+			hookConfig: Config{AuthMode: "msi"},
+			want:       "mode: \"msi\"",
+		},
+		{
+			name:       "account key file",
+			hookConfig: Config{AuthMode: "sas", Credentials: &Credentials{Source: CredentialSourceKey, AccountKeyFile: keyFile}},
+			want:       "account-key: \"accountkey\"\nmode: \"key\"",
+		},
+		{
+			name:       "account key file readable by others",
+			hookConfig: Config{Credentials: &Credentials{Source: CredentialSourceKey, AccountKeyFile: openKeyFile}},
+			wantErr:    true,
+		},
+		{
+			name:       "account key file owned by another user",
+			hookConfig: Config{Credentials: &Credentials{Source: CredentialSourceKey, AccountKeyFile: keyFile, FileOwners: []int{12345}}},
+			wantErr:    true,
+		},
+		{
+			name:       "empty sas file",
+			hookConfig: Config{Credentials: &Credentials{Source: CredentialSourceSAS, SASFile: emptyFile}},
+			wantErr:    true,
+		},
+		{
+			name:       "sas file",
+			hookConfig: Config{Credentials: &Credentials{Source: CredentialSourceSAS, SASFile: sasFile}},
+			want:       "mode: \"sas\"\nsas: \"sv=2022&sig=abc\"",
+		},
+		{
+			name:       "workload identity token readable by others",
+			hookConfig: Config{Credentials: &Credentials{Source: CredentialSourceWorkloadIdentity, TokenFile: tokenFile, ClientID: "client", TenantID: "tenant"}},
+			want:       "clientid: \"client\"\nmode: \"spn\"\noauth-token-path: \"" + tokenFile + "\"\ntenantid: \"tenant\"",
+		},
+		{
+			name:       "workload identity token writable by others",
+			hookConfig: Config{Credentials: &Credentials{Source: CredentialSourceWorkloadIdentity, TokenFile: writableTokenFile, ClientID: "client", TenantID: "tenant"}},
+			wantErr:    true,
+		},
+		{
+			name:       "user assigned managed identity",
+			hookConfig: Config{Credentials: &Credentials{Source: CredentialSourceMSI, ClientID: "client"}},
+			want:       "appid: \"client\"\nmode: \"msi\"",
+		},
+		{
+			name:       "service principal without tenant",
+			hookConfig: Config{Credentials: &Credentials{Source: CredentialSourceSPN, ClientID: "client", ClientSecretFile: keyFile}},
+			wantErr:    true,
+		},
+		{
+			name:       "missing file",
+			hookConfig: Config{Credentials: &Credentials{Source: CredentialSourceKey}},
+			wantErr:    true,
+		},
+		{
+			name:       "invalid source",
+			hookConfig: Config{Credentials: &Credentials{Source: "password"}},
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := AuthConfig(tt.hookConfig)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("AuthConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("AuthConfig() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
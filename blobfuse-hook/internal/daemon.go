@@ -0,0 +1,298 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/kata-hooks/hookutil"
+	"golang.org/x/sys/unix"
+)
+
+// I/O scheduling classes, see ioprio_set(2)
+var ioPriorityClasses = map[string]int{
+	"realtime":    1,
+	"best-effort": 2,
+	"idle":        3,
+}
+
+// Capabilities the fuse daemon may keep
+var daemonCapabilities = map[string]uintptr{
+	"CAP_CHOWN":           unix.CAP_CHOWN,
+	"CAP_DAC_OVERRIDE":    unix.CAP_DAC_OVERRIDE,
+	"CAP_DAC_READ_SEARCH": unix.CAP_DAC_READ_SEARCH,
+	"CAP_FOWNER":          unix.CAP_FOWNER,
+	"CAP_SETGID":          unix.CAP_SETGID,
+	"CAP_SETUID":          unix.CAP_SETUID,
+	"CAP_SYS_ADMIN":       unix.CAP_SYS_ADMIN,
+	"CAP_SYS_RESOURCE":    unix.CAP_SYS_RESOURCE,
+}
+
+// Create a struct to hold the privileges and resource limits of the fuse daemon
+type DaemonConfig struct {
+	// User and groups the daemon runs as. Default is the user of the hook
+	UID    *uint32  `json:"uid,omitempty"`
+	GID    *uint32  `json:"gid,omitempty"`
+	Groups []uint32 `json:"groups,omitempty"`
+
+	// Capabilities kept by a non-root daemon, eg. ["CAP_SYS_ADMIN"] to mount without fusermount3
+	Capabilities []string `json:"capabilities,omitempty"`
+
+	// cgroup v2 directory holding a cgroup per daemon. Eg. /sys/fs/cgroup/blobfuse-hook
+	Cgroup string `json:"cgroup,omitempty"`
+
+	// Limits of the cgroup of each daemon, written to memory.max, cpu.max and pids.max
+	// Eg. 536870912, "50000 100000" and 256
+	MemoryMax int64  `json:"memory_max,omitempty"`
+	CPUMax    string `json:"cpu_max,omitempty"`
+	PidsMax   int64  `json:"pids_max,omitempty"`
+
+	// Nice level of the daemon, -20 to 19
+	Nice int `json:"nice,omitempty"`
+
+	// I/O scheduling class (realtime, best-effort or idle) and level (0 to 7) of the daemon
+	IOClass string `json:"io_class,omitempty"`
+	IOLevel int    `json:"io_level,omitempty"`
+
+	// Mount with allow_other and the uid and gid of the container process user,
+	// so that the workload can read the files of a daemon running as another user
+	MapContainerUser bool `json:"map_container_user,omitempty"`
+
+	// Network namespace of the daemon: "host" (default) or "container", to reach the
+	// storage through the pod network, its policies, proxies and private endpoints
+	NetworkNamespace string `json:"network_namespace,omitempty"`
+}
+
+// Method to set the user, groups and capabilities of the daemon on cmd
+func setDaemonCredentials(cmd *exec.Cmd, daemon DaemonConfig) error {
+	if daemon.UID == nil && daemon.GID == nil && len(daemon.Groups) == 0 {
+		if len(daemon.Capabilities) != 0 {
+			return fmt.Errorf("daemon capabilities require a uid")
+		}
+		return nil
+	}
+
+	credential := &syscall.Credential{
+		Uid:    uint32(os.Getuid()),
+		Gid:    uint32(os.Getgid()),
+		Groups: daemon.Groups,
+	}
+	if daemon.UID != nil {
+		credential.Uid = *daemon.UID
+	}
+	if daemon.GID != nil {
+		credential.Gid = *daemon.GID
+	}
+	if daemon.Groups == nil {
+		// Drop the supplementary groups of the hook
+		credential.Groups = []uint32{}
+	}
+
+	var ambientCaps []uintptr
+	for _, name := range daemon.Capabilities {
+		capability, ok := daemonCapabilities[strings.ToUpper(name)]
+		if !ok {
+			return fmt.Errorf("capability %s may not be kept by the daemon", name)
+		}
+		ambientCaps = append(ambientCaps, capability)
+	}
+	if len(ambientCaps) != 0 && credential.Uid == 0 {
+		// root keeps all its capabilities
+		return fmt.Errorf("daemon capabilities require a non-root uid")
+	}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = credential
+	cmd.SysProcAttr.AmbientCaps = ambientCaps
+
+	log.Printf("daemon runs as uid %d gid %d groups %v capabilities %v\n",
+		credential.Uid, credential.Gid, credential.Groups, daemon.Capabilities)
+	return nil
+}
+
+// Method to create the cgroup of the daemon of a mount and put cmd into it
+// Returns the cgroup directory and a file to close once the daemon started, or "" and nil
+// without a configured cgroup. Requires cgroup v2 and Linux 5.7
+func setDaemonCgroup(cmd *exec.Cmd, daemon DaemonConfig, mount Mount) (string, *os.File, error) {
+	if daemon.Cgroup == "" {
+		return "", nil, nil
+	}
+
+	cgroupDir := DaemonCgroupDir(daemon, mount)
+	if err := os.MkdirAll(cgroupDir, 0755); err != nil {
+		log.Printf("unable to create cgroup %s\n", err)
+		return "", nil, err
+	}
+
+	// Enable the controllers of the limits in the parent, best effort
+	enableCgroupControllers(daemon.Cgroup, "+memory +cpu +pids")
+
+	limits := map[string]string{}
+	if daemon.MemoryMax > 0 {
+		limits["memory.max"] = strconv.FormatInt(daemon.MemoryMax, 10)
+	}
+	if daemon.CPUMax != "" {
+		limits["cpu.max"] = daemon.CPUMax
+	}
+	if daemon.PidsMax > 0 {
+		limits["pids.max"] = strconv.FormatInt(daemon.PidsMax, 10)
+	}
+	for file, value := range limits {
+		if err := os.WriteFile(filepath.Join(cgroupDir, file), []byte(value), 0644); err != nil {
+			log.Printf("unable to set cgroup limit %s %s\n", file, err)
+			os.Remove(cgroupDir)
+			return "", nil, err
+		}
+	}
+
+	dir, err := os.Open(cgroupDir)
+	if err != nil {
+		os.Remove(cgroupDir)
+		return "", nil, err
+	}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(dir.Fd())
+
+	log.Printf("daemon cgroup is %s with limits %v\n", cgroupDir, limits)
+	return cgroupDir, dir, nil
+}
+
+// Return the cgroup directory of the daemon of a mount
+func DaemonCgroupDir(daemon DaemonConfig, mount Mount) string {
+	return filepath.Join(daemon.Cgroup, mount.Owner+"_"+mount.Name)
+}
+
+// Enable cgroup controllers for the children of a cgroup
+// Controllers not available are ignored, the limits using them will fail
+func enableCgroupControllers(cgroupDir string, controllers string) {
+	subtreeControl := filepath.Join(cgroupDir, "cgroup.subtree_control")
+	for _, controller := range strings.Fields(controllers) {
+		if err := os.WriteFile(subtreeControl, []byte(controller), 0644); err != nil {
+			log.Printf("unable to enable cgroup controller %s %s\n", controller, err)
+		}
+	}
+}
+
+// Method to remove the cgroup of a daemon once it exited
+func RemoveDaemonCgroup(cgroupDir string) {
+	if cgroupDir == "" {
+		return
+	}
+	if err := os.Remove(cgroupDir); err != nil && !os.IsNotExist(err) {
+		log.Printf("unable to remove cgroup %s\n", err)
+	}
+}
+
+// Method to start cmd with the nice level and I/O priority of the daemon, in the network
+// namespace netNS if set. All are per thread and inherited, so cmd is started from a
+// dedicated thread with them set. The thread is discarded afterwards
+func startDaemonProcess(cmd *exec.Cmd, daemon DaemonConfig, netNS string) error {
+	ioPriority := 0
+	if daemon.IOClass != "" {
+		class, ok := ioPriorityClasses[daemon.IOClass]
+		if !ok {
+			return fmt.Errorf("invalid io class %q", daemon.IOClass)
+		}
+		if daemon.IOLevel < 0 || daemon.IOLevel > 7 {
+			return fmt.Errorf("invalid io level %d", daemon.IOLevel)
+		}
+		ioPriority = class<<13 | daemon.IOLevel
+	}
+	if daemon.Nice == 0 && ioPriority == 0 && netNS == "" {
+		return cmd.Start()
+	}
+
+	return hookutil.RunOnDedicatedThread(func() error {
+		if netNS != "" {
+			if err := enterNetNS(netNS); err != nil {
+				return err
+			}
+		}
+		if daemon.Nice != 0 {
+			// 0 is the calling thread
+			if err := syscall.Setpriority(syscall.PRIO_PROCESS, 0, daemon.Nice); err != nil {
+				log.Printf("unable to set nice level %s\n", err)
+				return err
+			}
+		}
+		if ioPriority != 0 {
+			// IOPRIO_WHO_PROCESS of the calling thread
+			if _, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, 1, 0, uintptr(ioPriority)); errno != 0 {
+				log.Printf("unable to set io priority %s\n", errno)
+				return errno
+			}
+		}
+		log.Printf("daemon nice level %d io class %s level %d\n", daemon.Nice, daemon.IOClass, daemon.IOLevel)
+		return cmd.Start()
+	})
+}
+
+// Return the mount options exposing the files of the fuse mount to the container user
+func containerUserOptions(values TemplateValues) []string {
+	if !values.AllowOther {
+		return nil
+	}
+	return []string{"allow_other", fmt.Sprintf("uid=%d", values.UID), fmt.Sprintf("gid=%d", values.GID)}
+}
+
+// Method to give a daemon running as another user access to the host mountpoint and
+// to the rendered config and cache in the runtime directory of the mount
+func prepareDaemonDirs(hookConfig Config, mount Mount) error {
+	daemon := hookConfig.Daemon
+	if daemon.UID == nil && daemon.GID == nil {
+		return nil
+	}
+	uid, gid := -1, -1
+	if daemon.UID != nil {
+		uid = int(*daemon.UID)
+	}
+	if daemon.GID != nil {
+		gid = int(*daemon.GID)
+	}
+
+	if err := os.Chown(mount.HostMountPoint, uid, gid); err != nil {
+		log.Printf("unable to chown host mountpoint %s\n", err)
+		return err
+	}
+
+	if hookConfig.Cache.Dir != "" {
+		cacheDir := CacheDir(hookConfig, mount)
+		if err := os.Chown(cacheDir, uid, gid); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	runtimeDir := MountRuntimeDir(hookConfig, mount)
+	if _, err := os.Stat(runtimeDir); os.IsNotExist(err) {
+		return nil
+	}
+	// The runtime directory of the hook may be shared, its mode is left as is, see mkdirRuntimeDir
+	baseDir := runtimeBaseDir(hookConfig)
+	info, err := os.Stat(baseDir)
+	if err != nil {
+		return err
+	}
+	if info.Mode().Perm()&0001 == 0 {
+		return fmt.Errorf("runtime directory %s is not searchable by the daemon user", baseDir)
+	}
+	// Let the daemon traverse the directory of the mount owner, created by the hook
+	if err := os.Chmod(ContainerRuntimeDir(hookConfig, mount.Owner), 0711); err != nil {
+		return err
+	}
+	return filepath.Walk(runtimeDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(path, uid, gid)
+	})
+}
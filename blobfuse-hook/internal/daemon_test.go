@@ -0,0 +1,178 @@
+package internal
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestSetDaemonCredentials(t *testing.T) {
+	uid, gid, rootUID := uint32(1000), uint32(1000), uint32(0)
+
+	tests := []struct {
+		name        string
+		daemon      DaemonConfig
+		wantCred    *syscall.Credential
+		wantAmbient []uintptr
+		wantErr     bool
+	}{
+		{
+			name: "hook user by default",
+		},
+		{
+			name:     "user and groups",
+			daemon:   DaemonConfig{UID: &uid, GID: &gid, Groups: []uint32{44}},
+			wantCred: &syscall.Credential{Uid: 1000, Gid: 1000, Groups: []uint32{44}},
+		},
+		{
+			name:        "user with capabilities",
+			daemon:      DaemonConfig{UID: &uid, Capabilities: []string{"cap_sys_admin"}},
+			wantCred:    &syscall.Credential{Uid: 1000, Gid: uint32(os.Getgid()), Groups: []uint32{}},
+			wantAmbient: []uintptr{21},
+		},
+		{
+			name:    "capabilities without user",
+			daemon:  DaemonConfig{Capabilities: []string{"CAP_SYS_ADMIN"}},
+			wantErr: true,
+		},
+		{
+			name:    "capabilities of root",
+			daemon:  DaemonConfig{UID: &rootUID, Capabilities: []string{"CAP_SYS_ADMIN"}},
+			wantErr: true,
+		},
+		{
+			name:    "capability not allowed",
+			daemon:  DaemonConfig{UID: &uid, Capabilities: []string{"CAP_SYS_MODULE"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := exec.Command("/bin/true")
+			err := setDaemonCredentials(cmd, tt.daemon)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("setDaemonCredentials() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			var gotCred *syscall.Credential
+			var gotAmbient []uintptr
+			if cmd.SysProcAttr != nil {
+				gotCred, gotAmbient = cmd.SysProcAttr.Credential, cmd.SysProcAttr.AmbientCaps
+			}
+			if !reflect.DeepEqual(gotCred, tt.wantCred) || !reflect.DeepEqual(gotAmbient, tt.wantAmbient) {
+				t.Errorf("setDaemonCredentials() = %+v %v, want %+v %v", gotCred, gotAmbient, tt.wantCred, tt.wantAmbient)
+			}
+		})
+	}
+}
+
+func TestSetDaemonCgroup(t *testing.T) {
+	// A plain directory stands in for the cgroup v2 parent
+	daemon := DaemonConfig{Cgroup: t.TempDir(), MemoryMax: 512 << 20, CPUMax: "50000 100000", PidsMax: 64}
+	mount := Mount{Name: "input", Owner: "c1"}
+
+	cmd := exec.Command("/bin/true")
+	cgroupDir, cgroupFile, err := setDaemonCgroup(cmd, daemon, mount)
+	if err != nil {
+		t.Fatalf("setDaemonCgroup() error = %v", err)
+	}
+	defer cgroupFile.Close()
+
+	if cgroupDir != filepath.Join(daemon.Cgroup, "c1_input") {
+		t.Errorf("cgroup directory = %s", cgroupDir)
+	}
+	for file, want := range map[string]string{"memory.max": "536870912", "cpu.max": "50000 100000", "pids.max": "64"} {
+		got, err := os.ReadFile(filepath.Join(cgroupDir, file))
+		if err != nil || string(got) != want {
+			t.Errorf("%s = %q, %v, want %q", file, got, err, want)
+		}
+	}
+	if !cmd.SysProcAttr.UseCgroupFD || cmd.SysProcAttr.CgroupFD != int(cgroupFile.Fd()) {
+		t.Errorf("command not started in the cgroup")
+	}
+}
+
+func TestStartDaemonProcess(t *testing.T) {
+	// Field 19 of /proc/<pid>/stat is the nice level
+	cmd := exec.Command("/bin/sh", "-c", "cut -d' ' -f19 /proc/self/stat")
+	var out strings.Builder
+	cmd.Stdout = &out
+
+	if err := startDaemonProcess(cmd, DaemonConfig{Nice: 5, IOClass: "best-effort", IOLevel: 7}, ""); err != nil {
+		t.Fatalf("startDaemonProcess() error = %v", err)
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(out.String()); got != "5" {
+		t.Errorf("daemon nice level = %s, want 5", got)
+	}
+
+	if err := startDaemonProcess(exec.Command("/bin/true"), DaemonConfig{IOClass: "fast"}, ""); err == nil {
+		t.Errorf("startDaemonProcess() accepted an invalid io class")
+	}
+}
+
+func TestPrepareDaemonDirs(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing owners requires root")
+	}
+	uid := uint32(1000)
+
+	tests := []struct {
+		name     string
+		baseMode os.FileMode
+		wantErr  bool
+	}{
+		{name: "runtime directory created by the hook"},
+		{name: "shared runtime directory", baseMode: 0755},
+		{name: "runtime directory not searchable", baseMode: 0700, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			hookConfig := Config{RuntimeDir: filepath.Join(dir, "run"), Daemon: DaemonConfig{UID: &uid}}
+			mount := Mount{Name: "input", Owner: "c1", HostMountPoint: filepath.Join(dir, "host")}
+			if tt.baseMode != 0 {
+				if err := os.Mkdir(hookConfig.RuntimeDir, tt.baseMode); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := os.MkdirAll(mount.HostMountPoint, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := mkdirRuntimeDir(hookConfig, MountRuntimeDir(hookConfig, mount)); err != nil {
+				t.Fatal(err)
+			}
+
+			err := prepareDaemonDirs(hookConfig, mount)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("prepareDaemonDirs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			// The mode of the runtime directory of the hook is left as is
+			wantMode := tt.baseMode
+			if wantMode == 0 {
+				wantMode = 0711
+			}
+			if info, err := os.Stat(hookConfig.RuntimeDir); err != nil || info.Mode().Perm() != wantMode {
+				t.Errorf("runtime directory mode = %v, %v, want %v", info.Mode().Perm(), err, wantMode)
+			}
+			if tt.wantErr {
+				return
+			}
+			info, err := os.Stat(MountRuntimeDir(hookConfig, mount))
+			if err != nil || info.Sys().(*syscall.Stat_t).Uid != uid {
+				t.Errorf("runtime directory of the mount is not owned by the daemon user, %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,256 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// Built-in drivers
+const (
+	DriverBlobFuse2 = "blobfuse2"
+	DriverS3fs      = "s3fs"
+	DriverGcsfuse   = "gcsfuse"
+	DriverRclone    = "rclone"
+	DriverArgv      = "argv"
+)
+
+// Values containers can set, see ApplyOverrides, are checked before they reach the
+// fuse program arguments, so that they can't be taken for options. See checkDriverValues
+var (
+	storageNameRegexp  = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+	subdirectoryRegexp = regexp.MustCompile(`^[a-zA-Z0-9._][a-zA-Z0-9._/-]*$`)
+	endpointRegexp     = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*://[a-zA-Z0-9._~:/?#@!$&'()*+;=%\[\]-]+$`)
+)
+
+// Create an interface for the fuse programs mounting remote storage
+// The hook runs the program, waits for the fuse mount, binds it into the container
+// and tears it down the same way for every driver. The program must daemonize
+type Driver interface {
+	// Default program path, used when the hook config has none
+	DefaultProgramPath() string
+
+	// Arguments of the program mounting the storage at values.MountPoint
+	// configFile is the rendered config, see RenderBlobFuseConfig
+	Args(hookConfig Config, values TemplateValues, configFile string) ([]string, error)
+}
+
+// Built-in drivers by name
+var drivers = map[string]Driver{
+	DriverBlobFuse2: blobFuse2Driver{},
+	DriverS3fs:      s3fsDriver{},
+	DriverGcsfuse:   gcsfuseDriver{},
+	DriverRclone:    rcloneDriver{},
+	DriverArgv:      argvDriver{},
+}
+
+// Return the names of the built-in drivers
+func DriverNames() []string {
+	names := make([]string, 0, len(drivers))
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Method to get the driver of the hook config. Default is blobfuse2
+func GetDriver(hookConfig Config) (Driver, error) {
+	name := hookConfig.Driver
+	if name == "" {
+		name = DriverBlobFuse2
+	}
+	driver, ok := drivers[name]
+	if !ok {
+		return nil, fmt.Errorf("invalid driver %q, expected one of %v", name, DriverNames())
+	}
+	return driver, nil
+}
+
+// Method to build the arguments of the fuse program of the hook config
+// The values are checked first, see checkDriverValues
+func driverArgs(hookConfig Config, values TemplateValues, configFile string) ([]string, error) {
+	driver, err := GetDriver(hookConfig)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDriverValues(values); err != nil {
+		return nil, err
+	}
+	return driver.Args(hookConfig, values, configFile)
+}
+
+// Method to check the values containers can set against a strict charset
+// Names and the subdirectory never start with "-", and endpoints are URLs without ","
+// so they don't add options to the -o lists either
+func checkDriverValues(values TemplateValues) error {
+	checks := []struct {
+		name   string
+		value  string
+		regexp *regexp.Regexp
+	}{
+		{"account name", values.AccountName, storageNameRegexp},
+		{"container name", values.ContainerName, storageNameRegexp},
+		{"auth mode", values.AuthMode, storageNameRegexp},
+		{"subdirectory", values.Subdirectory, subdirectoryRegexp},
+		{"endpoint", values.Endpoint, endpointRegexp},
+	}
+	for _, check := range checks {
+		if check.value != "" && !check.regexp.MatchString(check.value) {
+			return fmt.Errorf("invalid %s %q", check.name, check.value)
+		}
+	}
+	return nil
+}
+
+// blobfuse2 mount <mountpoint> --config-file=<config> [--subdirectory=<dir>] [--read-only]
+type blobFuse2Driver struct{}
+
+func (blobFuse2Driver) DefaultProgramPath() string {
+	return "/usr/bin/blobfuse2"
+}
+
+func (blobFuse2Driver) Args(hookConfig Config, values TemplateValues, configFile string) ([]string, error) {
+	args := []string{"mount", values.MountPoint, "--config-file=" + configFile}
+	if values.Subdirectory != "" {
+		args = append(args, "--subdirectory="+values.Subdirectory)
+	}
+	if values.ReadOnly {
+		args = append(args, "--read-only")
+	}
+	if options := containerUserOptions(values); options != nil {
+		args = append(args, "-o", strings.Join(options, ","))
+	}
+	return args, nil
+}
+
+// s3fs <bucket>[:/<subdirectory>] <mountpoint> [-o passwd_file=<config>] [-o url=<endpoint>] [-o ro]
+// The container name is the bucket, the rendered config is the passwd file
+type s3fsDriver struct{}
+
+func (s3fsDriver) DefaultProgramPath() string {
+	return "/usr/bin/s3fs"
+}
+
+func (s3fsDriver) Args(hookConfig Config, values TemplateValues, configFile string) ([]string, error) {
+	if values.ContainerName == "" {
+		return nil, fmt.Errorf("s3fs requires a container name (bucket)")
+	}
+	bucket := values.ContainerName
+	if values.Subdirectory != "" {
+		bucket += ":/" + values.Subdirectory
+	}
+	args := []string{bucket, values.MountPoint}
+	if hookConfig.ConfigTemplate != "" {
+		args = append(args, "-o", "passwd_file="+configFile)
+	}
+	if values.Endpoint != "" {
+		args = append(args, "-o", "url="+values.Endpoint)
+	}
+	if values.ReadOnly {
+		args = append(args, "-o", "ro")
+	}
+	if options := containerUserOptions(values); options != nil {
+		args = append(args, "-o", strings.Join(options, ","))
+	}
+	return args, nil
+}
+
+// gcsfuse [--config-file <config>] [--only-dir <subdirectory>] [-o ro] -- <bucket> <mountpoint>
+// The container name is the bucket
+type gcsfuseDriver struct{}
+
+func (gcsfuseDriver) DefaultProgramPath() string {
+	return "/usr/bin/gcsfuse"
+}
+
+func (gcsfuseDriver) Args(hookConfig Config, values TemplateValues, configFile string) ([]string, error) {
+	if values.ContainerName == "" {
+		return nil, fmt.Errorf("gcsfuse requires a container name (bucket)")
+	}
+	var args []string
+	if hookConfig.ConfigTemplate != "" {
+		args = append(args, "--config-file", configFile)
+	}
+	if values.Subdirectory != "" {
+		args = append(args, "--only-dir", values.Subdirectory)
+	}
+	if values.ReadOnly {
+		args = append(args, "-o", "ro")
+	}
+	if values.AllowOther {
+		args = append(args, "-o", "allow_other", "--uid", fmt.Sprint(values.UID), "--gid", fmt.Sprint(values.GID))
+	}
+	return append(args, "--", values.ContainerName, values.MountPoint), nil
+}
+
+// rclone mount <remote>:<container>[/<subdirectory>] <mountpoint> --daemon [--config <config>] [--read-only]
+// The account name is the rclone remote
+type rcloneDriver struct{}
+
+func (rcloneDriver) DefaultProgramPath() string {
+	return "/usr/bin/rclone"
+}
+
+func (rcloneDriver) Args(hookConfig Config, values TemplateValues, configFile string) ([]string, error) {
+	if values.AccountName == "" {
+		return nil, fmt.Errorf("rclone requires an account name (remote)")
+	}
+	remotePath := values.AccountName + ":" + values.ContainerName
+	if values.Subdirectory != "" {
+		remotePath += "/" + values.Subdirectory
+	}
+	args := []string{"mount", remotePath, values.MountPoint, "--daemon"}
+	if hookConfig.ConfigTemplate != "" {
+		args = append(args, "--config", configFile)
+	}
+	if values.ReadOnly {
+		args = append(args, "--read-only")
+	}
+	if values.AllowOther {
+		args = append(args, "--allow-other", "--uid", fmt.Sprint(values.UID), "--gid", fmt.Sprint(values.GID))
+	}
+	return args, nil
+}
+
+// Arguments rendered from the hookConfig.DriverArgs templates
+// Eg. ["{{ .ContainerName }}", "{{ .MountPoint }}", "{{ if .ReadOnly }}-oro{{ end }}"]
+// Arguments rendering to "" are dropped
+type argvDriver struct{}
+
+// Create a struct to hold the values available to the argv driver templates
+type argvValues struct {
+	TemplateValues
+	ConfigFile string
+}
+
+func (argvDriver) DefaultProgramPath() string {
+	return ""
+}
+
+func (argvDriver) Args(hookConfig Config, values TemplateValues, configFile string) ([]string, error) {
+	if len(hookConfig.DriverArgs) == 0 {
+		return nil, fmt.Errorf("argv driver requires driver_args")
+	}
+
+	var args []string
+	for i, argTemplate := range hookConfig.DriverArgs {
+		tmpl, err := template.New(fmt.Sprintf("arg%d", i)).Option("missingkey=error").Parse(argTemplate)
+		if err != nil {
+			log.Printf("unable to parse driver arg %s\n", err)
+			return nil, err
+		}
+		var arg bytes.Buffer
+		if err := tmpl.Execute(&arg, argvValues{values, configFile}); err != nil {
+			log.Printf("unable to render driver arg %s\n", err)
+			return nil, err
+		}
+		if arg.Len() != 0 {
+			args = append(args, arg.String())
+		}
+	}
+	return args, nil
+}
@@ -0,0 +1,129 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDriverArgs(t *testing.T) {
+	values := TemplateValues{MountPoint: "/blobdata/c1/input", AccountName: "remote", ContainerName: "bucket", Endpoint: "https://s3.example.com", ReadOnly: true}
+
+	tests := []struct {
+		name       string
+		hookConfig Config
+		values     TemplateValues
+		want       []string
+		wantErr    bool
+	}{
+		{
+			name:       "blobfuse2 by default",
+			hookConfig: Config{},
+			values:     values,
+			want:       []string{"mount", "/blobdata/c1/input", "--config-file=/run/blobfuse2.yaml", "--read-only"},
+		},
+		{
+			name:       "blobfuse2 subdirectory",
+			hookConfig: Config{Driver: DriverBlobFuse2},
+			values:     TemplateValues{MountPoint: "/mnt", Subdirectory: "tenant-a"},
+			want:       []string{"mount", "/mnt", "--config-file=/run/blobfuse2.yaml", "--subdirectory=tenant-a"},
+		},
+		{
+			name:       "s3fs subdirectory",
+			hookConfig: Config{Driver: DriverS3fs},
+			values:     TemplateValues{MountPoint: "/mnt", ContainerName: "bucket", Subdirectory: "tenant-a"},
+			want:       []string{"bucket:/tenant-a", "/mnt"},
+		},
+		{
+			name:       "s3fs",
+			hookConfig: Config{Driver: DriverS3fs, ConfigTemplate: "/etc/passwd-s3fs.tmpl"},
+			values:     values,
+			want:       []string{"bucket", "/blobdata/c1/input", "-o", "passwd_file=/run/blobfuse2.yaml", "-o", "url=https://s3.example.com", "-o", "ro"},
+		},
+		{
+			name:       "gcsfuse",
+			hookConfig: Config{Driver: DriverGcsfuse},
+			values:     values,
+			want:       []string{"-o", "ro", "--", "bucket", "/blobdata/c1/input"},
+		},
+		{
+			name:       "rclone",
+			hookConfig: Config{Driver: DriverRclone, ConfigTemplate: "/etc/rclone.conf.tmpl"},
+			values:     values,
+			want:       []string{"mount", "remote:bucket", "/blobdata/c1/input", "--daemon", "--config", "/run/blobfuse2.yaml", "--read-only"},
+		},
+		{
+			name:       "blobfuse2 for the container user",
+			hookConfig: Config{},
+			values:     TemplateValues{MountPoint: "/mnt", AllowOther: true, UID: 1000, GID: 100},
+			want:       []string{"mount", "/mnt", "--config-file=/run/blobfuse2.yaml", "-o", "allow_other,uid=1000,gid=100"},
+		},
+		{
+			name:       "rclone for the container user",
+			hookConfig: Config{Driver: DriverRclone},
+			values:     TemplateValues{MountPoint: "/mnt", AccountName: "remote", ContainerName: "bucket", AllowOther: true, UID: 1000, GID: 100},
+			want:       []string{"mount", "remote:bucket", "/mnt", "--daemon", "--allow-other", "--uid", "1000", "--gid", "100"},
+		},
+		{
+			name:       "rclone without remote",
+			hookConfig: Config{Driver: DriverRclone},
+			values:     TemplateValues{MountPoint: "/mnt"},
+			wantErr:    true,
+		},
+		{
+			name: "argv",
+			hookConfig: Config{Driver: DriverArgv, DriverArgs: []string{
+				"{{ .AccountName }}@host:/{{ .ContainerName }}", "{{ .MountPoint }}", "{{ if .ReadOnly }}-oro{{ end }}", "-F", "{{ .ConfigFile }}",
+			}},
+			values: TemplateValues{MountPoint: "/mnt", AccountName: "user", ContainerName: "data"},
+			want:   []string{"user@host:/data", "/mnt", "-F", "/run/blobfuse2.yaml"},
+		},
+		{
+			name:       "argv with an unknown value",
+			hookConfig: Config{Driver: DriverArgv, DriverArgs: []string{"{{ .Bucket }}"}},
+			wantErr:    true,
+		},
+		{
+			name: "argv with an option as account name",
+			hookConfig: Config{Driver: DriverArgv, DriverArgs: []string{
+				"{{ .AccountName }}@host:/{{ .ContainerName }}", "{{ .MountPoint }}",
+			}},
+			values:  TemplateValues{MountPoint: "/mnt", AccountName: "-oProxyCommand=touch /pwned", ContainerName: "data"},
+			wantErr: true,
+		},
+		{
+			name:       "container name with an option",
+			hookConfig: Config{Driver: DriverGcsfuse},
+			values:     TemplateValues{MountPoint: "/mnt", ContainerName: "--foreground"},
+			wantErr:    true,
+		},
+		{
+			name:       "endpoint adding an option",
+			hookConfig: Config{Driver: DriverS3fs},
+			values:     TemplateValues{MountPoint: "/mnt", ContainerName: "bucket", Endpoint: "https://s3.example.com,passwd_file=/etc/shadow"},
+			wantErr:    true,
+		},
+		{
+			name:       "subdirectory with an option",
+			hookConfig: Config{Driver: DriverGcsfuse},
+			values:     TemplateValues{MountPoint: "/mnt", ContainerName: "bucket", Subdirectory: "-o"},
+			wantErr:    true,
+		},
+		{
+			name:       "invalid driver",
+			hookConfig: Config{Driver: "nfs"},
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := driverArgs(tt.hookConfig, tt.values, "/run/blobfuse2.yaml")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Args() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Args() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
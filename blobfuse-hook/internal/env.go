@@ -0,0 +1,39 @@
+package internal
+
+// Name of the hook in the facts file
+const FactsHookName = "blobfuse-hook"
+
+// Create a struct to hold what the hook discovered for a container
+// The facts are available to the env templates, eg. "{{ .ContainerMountPoint }}",
+// and are written to the facts file. HostMountPoint and ContainerMountPoint are
+// those of the first mount, all the mounts are in Mounts
+type Facts struct {
+	ContainerID         string       `json:"container_id"`
+	HostMountPoint      string       `json:"host_mountpoint"`
+	ContainerMountPoint string       `json:"container_mountpoint"`
+	Mounts              []MountFacts `json:"mounts"`
+}
+
+// Create a struct to hold what the hook discovered for a mount
+type MountFacts struct {
+	Name                string `json:"name"`
+	HostMountPoint      string `json:"host_mountpoint"`
+	ContainerMountPoint string `json:"container_mountpoint"`
+	ReadOnly            bool   `json:"read_only"`
+	Subdirectory        string `json:"subdirectory,omitempty"`
+}
+
+// Method to add a mount to the facts
+func (facts *Facts) AddMount(mount Mount) {
+	if len(facts.Mounts) == 0 {
+		facts.HostMountPoint = mount.HostMountPoint
+		facts.ContainerMountPoint = mount.ContainerMountPoint
+	}
+	facts.Mounts = append(facts.Mounts, MountFacts{
+		Name:                mount.Name,
+		HostMountPoint:      mount.HostMountPoint,
+		ContainerMountPoint: mount.ContainerMountPoint,
+		ReadOnly:            mount.ReadOnly,
+		Subdirectory:        mount.Subdirectory,
+	})
+}
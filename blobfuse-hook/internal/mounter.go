@@ -0,0 +1,51 @@
+package internal
+
+import (
+	"os"
+	"syscall"
+
+	sysmount "github.com/moby/sys/mount"
+)
+
+// Create an interface to make, remove and inspect the mounts of the hook
+// The tests replace it to run without privileges, see SetMounter
+type Mounter interface {
+	// Mount source at target with the mount(8) options. Eg. Mount(src, dst, "none", "bind,ro")
+	Mount(source string, target string, fsType string, options string) error
+
+	// Unmount target with the umount2(2) flags. Eg. syscall.MNT_DETACH
+	Unmount(target string, flags int) error
+
+	// Check if a fuse filesystem is mounted at mountPoint
+	IsFuseMounted(mountPoint string) (bool, error)
+}
+
+// Mounter of the hook
+var mounter Mounter = SystemMounter{}
+
+// Set the mounter of the hook
+func SetMounter(m Mounter) {
+	mounter = m
+}
+
+// Mounter making the mounts in the mount namespace of the hook
+type SystemMounter struct{}
+
+func (SystemMounter) Mount(source string, target string, fsType string, options string) error {
+	return sysmount.Mount(source, target, fsType, options)
+}
+
+func (SystemMounter) Unmount(target string, flags int) error {
+	return syscall.Unmount(target, flags)
+}
+
+// Check if /proc/self/mountinfo shows a fuse mount at mountPoint
+func (SystemMounter) IsFuseMounted(mountPoint string) (bool, error) {
+	file, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	return isFuseMountedIn(file, mountPoint)
+}
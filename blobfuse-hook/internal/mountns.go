@@ -0,0 +1,94 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/kata-hooks/hookutil"
+	"golang.org/x/sys/unix"
+)
+
+// Modes of placing the mounts in the container, see Config
+const (
+	MountModeRootfs = "rootfs"
+	MountModeInject = "inject"
+)
+
+// OCI hook stages, the blob storage is mounted at prestart or poststart
+const (
+	StagePrestart  = "prestart"
+	StagePoststart = "poststart"
+	StagePoststop  = "poststop"
+)
+
+// Method to get the OCI hook stage mounting the blob storage in the mount mode of hookConfig
+// Rootfs binds must be made before pivot_root, injection needs the container mount namespace
+func MountStage(hookConfig Config) (string, error) {
+	switch hookConfig.MountMode {
+	case "", MountModeRootfs:
+		return StagePrestart, nil
+	case MountModeInject:
+		return StagePoststart, nil
+	default:
+		return "", fmt.Errorf("invalid mount mode %q", hookConfig.MountMode)
+	}
+}
+
+// Method to mount hostMountPoint at containerMountPoint in the mount namespace of the container process pid
+// Unlike BindMount to the container rootfs, this works after pivot_root and with a private rootfs propagation.
+// containerMountPoint is resolved in the container root, so symlinks can't escape it, and created if missing.
+// Requires Linux 5.6
+func InjectMount(pid int, hostMountPoint string, containerMountPoint string, readOnly bool) error {
+	return injectMount(pid, hostMountPoint, containerMountPoint, readOnly, false)
+}
+
+// Method to replace the mount at containerMountPoint in the mount namespace of the container process pid
+// A stale mount at containerMountPoint, eg. of a dead fuse daemon, is lazily unmounted first, see InjectMount
+func RebindInContainer(pid int, hostMountPoint string, containerMountPoint string, readOnly bool) error {
+	return injectMount(pid, hostMountPoint, containerMountPoint, readOnly, true)
+}
+
+func injectMount(pid int, hostMountPoint string, containerMountPoint string, readOnly bool, replace bool) error {
+	// Clone the host mountpoint into a detached tree, it can be attached in another namespace
+	treeFd, err := unix.OpenTree(unix.AT_FDCWD, hostMountPoint, unix.OPEN_TREE_CLONE|unix.OPEN_TREE_CLOEXEC)
+	if err != nil {
+		log.Printf("unable to clone %s %s\n", hostMountPoint, err)
+		return err
+	}
+	defer unix.Close(treeFd)
+
+	// Without mount_setattr (Linux 5.12), the mount is remounted read-only once attached
+	remountReadOnly := false
+	if readOnly {
+		attr := &unix.MountAttr{Attr_set: unix.MOUNT_ATTR_RDONLY}
+		err := unix.MountSetattr(treeFd, "", unix.AT_EMPTY_PATH|unix.AT_RECURSIVE, attr)
+		if err == unix.ENOSYS {
+			remountReadOnly = true
+		} else if err != nil {
+			log.Printf("unable to make %s read-only %s\n", hostMountPoint, err)
+			return err
+		}
+	}
+
+	options := hookutil.MoveMountOptions{Replace: replace}
+	if remountReadOnly {
+		options.AfterMove = func() error {
+			return unix.Mount("", filepath.Base(containerMountPoint), "", unix.MS_BIND|unix.MS_REMOUNT|unix.MS_RDONLY, "")
+		}
+	}
+	return hookutil.MoveMountIntoContainer(pid, treeFd, containerMountPoint, options)
+}
+
+// Check if the process pid is in another mount namespace than the hook
+func inOtherMountNamespace(pid int) (bool, error) {
+	self, err := os.Readlink("/proc/self/ns/mnt")
+	if err != nil {
+		return false, err
+	}
+	other, err := os.Readlink(fmt.Sprintf("/proc/%d/ns/mnt", pid))
+	if err != nil {
+		return false, err
+	}
+	return self != other, nil
+}
@@ -0,0 +1,83 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMountStage(t *testing.T) {
+	tests := []struct {
+		mountMode string
+		want      string
+		wantError bool
+	}{
+		{mountMode: "", want: StagePrestart},
+		{mountMode: MountModeRootfs, want: StagePrestart},
+		{mountMode: MountModeInject, want: StagePoststart},
+		{mountMode: "bind", wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mountMode, func(t *testing.T) {
+			got, err := MountStage(Config{MountMode: tt.mountMode})
+			if (err != nil) != tt.wantError {
+				t.Fatalf("MountStage() error = %v, wantError %v", err, tt.wantError)
+			}
+			if got != tt.want {
+				t.Errorf("MountStage() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInjectMount(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("entering a mount namespace requires root")
+	}
+	if _, err := exec.LookPath("unshare"); err != nil {
+		t.Skip("unshare not found")
+	}
+
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source")
+	if err := os.MkdirAll(source, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(source, "blob"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A container in its own mount namespace
+	cmd := exec.Command("unshare", "--mount", "--propagation", "private", "sleep", "30")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+	for i := 0; i < 100; i++ {
+		if other, _ := inOtherMountNamespace(cmd.Process.Pid); other {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The missing directories are created
+	target := filepath.Join(dir, "rootfs", "data", "blob")
+	if err := InjectMount(cmd.Process.Pid, source, target, false); err != nil {
+		t.Fatalf("InjectMount() error = %v", err)
+	}
+
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/root%s/blob", cmd.Process.Pid, target))
+	if err != nil || string(data) != "data" {
+		t.Errorf("blob in the container = %q, %v, want data", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(target, "blob")); !os.IsNotExist(err) {
+		t.Errorf("%s mounted in the hook mount namespace", target)
+	}
+}
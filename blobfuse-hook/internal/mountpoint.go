@@ -0,0 +1,118 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// States of a host mountpoint before mounting, see InspectMountPoint
+const (
+	// No fuse mount
+	MountPointFree = "free"
+
+	// Fuse mount of a dead daemon
+	MountPointStale = "stale"
+
+	// Fuse mount served by a live daemon
+	MountPointHealthy = "healthy"
+)
+
+// Maximum number of stale mounts stacked on a mountpoint unmounted before giving up
+const maxStaleMounts = 16
+
+// Method to inspect the host mountpoint before mounting
+// A fuse mount is stale if it is disconnected (ENOTCONN), or if no process serves it.
+// Returns the state and, for a healthy mount, the pids of the processes serving it
+func InspectMountPoint(mountPoint string) (string, []int, error) {
+	_, statErr := os.Stat(mountPoint)
+	if errors.Is(statErr, syscall.ENOTCONN) {
+		return MountPointStale, nil, nil
+	}
+
+	mounted, err := IsFuseMounted(mountPoint)
+	if err != nil {
+		return "", nil, err
+	}
+	if !mounted {
+		return MountPointFree, nil, nil
+	}
+	if statErr != nil {
+		return "", nil, statErr
+	}
+
+	// Any program may serve the mount, not only the one of the hook
+	pids := FindFuseDaemons("", mountPoint)
+	if len(pids) == 0 {
+		return MountPointStale, nil, nil
+	}
+	return MountPointHealthy, pids, nil
+}
+
+// Method to check the host mountpoint of a mount before starting its daemon
+// Stale fuse mounts left by a crashed daemon are killed and lazily unmounted.
+// A healthy mount is never mounted over: when the mount may be shared and is served by
+// a daemon started with the same launch, it is reused and true is returned; otherwise
+// an error is returned
+func PrepareHostMountPoint(mount Mount, launch DaemonLaunch, shared bool) (bool, error) {
+	hostMountPoint := mount.HostMountPoint
+
+	for i := 0; ; i++ {
+		state, pids, err := InspectMountPoint(hostMountPoint)
+		if err != nil {
+			log.Printf("unable to inspect host mountpoint %s %s\n", hostMountPoint, err)
+			return false, err
+		}
+
+		switch state {
+		case MountPointFree:
+			return false, nil
+
+		case MountPointStale:
+			if i >= maxStaleMounts {
+				return false, fmt.Errorf("host mountpoint %s is still stale after %d unmounts", hostMountPoint, i)
+			}
+			log.Printf("host mountpoint %s is a stale fuse mount, unmounting it\n", hostMountPoint)
+			// Kills a hung daemon of the hook still holding the mount
+			KillFuseDaemon(launch.ProgramPath, hostMountPoint)
+
+		case MountPointHealthy:
+			if shared {
+				for _, pid := range pids {
+					if isDaemonOfLaunch(pid, launch) {
+						log.Printf("host mountpoint %s is already served by pid %d, reusing it\n", hostMountPoint, pid)
+						return true, nil
+					}
+				}
+			}
+			return false, fmt.Errorf("host mountpoint %s is already mounted by pids %v", hostMountPoint, pids)
+		}
+	}
+}
+
+// Check if the process pid runs the program and arguments of launch
+func isDaemonOfLaunch(pid int, launch DaemonLaunch) bool {
+	cmdline, err := ioutil.ReadFile(filepath.Join("/proc", fmt.Sprint(pid), "cmdline"))
+	if err != nil {
+		return false
+	}
+	args := strings.Split(strings.TrimRight(string(cmdline), "\x00"), "\x00")
+	return sameLaunchArgs(args, launch)
+}
+
+// Check if args are the program and arguments of launch
+func sameLaunchArgs(args []string, launch DaemonLaunch) bool {
+	if len(args) != len(launch.Args)+1 || filepath.Base(args[0]) != filepath.Base(launch.ProgramPath) {
+		return false
+	}
+	for i, arg := range launch.Args {
+		if args[i+1] != arg {
+			return false
+		}
+	}
+	return true
+}
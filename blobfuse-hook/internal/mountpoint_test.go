@@ -0,0 +1,81 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestSameLaunchArgs(t *testing.T) {
+	launch := DaemonLaunch{ProgramPath: "/usr/bin/blobfuse2", Args: []string{"mount", "/blobdata/pod-1/input", "--config-file=/run/c.yaml"}}
+
+	tests := []struct {
+		name string
+		args []string
+		want bool
+	}{
+		{name: "same launch", args: []string{"/usr/bin/blobfuse2", "mount", "/blobdata/pod-1/input", "--config-file=/run/c.yaml"}, want: true},
+		{name: "program in PATH", args: []string{"blobfuse2", "mount", "/blobdata/pod-1/input", "--config-file=/run/c.yaml"}, want: true},
+		{name: "other config", args: []string{"/usr/bin/blobfuse2", "mount", "/blobdata/pod-1/input", "--config-file=/run/d.yaml"}, want: false},
+		{name: "extra flag", args: []string{"/usr/bin/blobfuse2", "mount", "/blobdata/pod-1/input", "--config-file=/run/c.yaml", "-o", "ro"}, want: false},
+		{name: "other program", args: []string{"/usr/bin/rclone", "mount", "/blobdata/pod-1/input", "--config-file=/run/c.yaml"}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sameLaunchArgs(tt.args, launch); got != tt.want {
+				t.Errorf("sameLaunchArgs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrepareHostMountPointFree(t *testing.T) {
+	mount := Mount{HostMountPoint: t.TempDir()}
+
+	state, _, err := InspectMountPoint(mount.HostMountPoint)
+	if err != nil || state != MountPointFree {
+		t.Fatalf("InspectMountPoint() = %s, %v, want %s", state, err, MountPointFree)
+	}
+	reused, err := PrepareHostMountPoint(mount, DaemonLaunch{ProgramPath: "/usr/bin/blobfuse2"}, true)
+	if err != nil || reused {
+		t.Errorf("PrepareHostMountPoint() = %v, %v, want false, nil", reused, err)
+	}
+}
+
+func TestPrepareHostMountPointStale(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("mounting requires root")
+	}
+
+	// A fuse mount whose daemon is gone: the fuse device is closed right after mounting
+	fuseDevice, err := os.OpenFile("/dev/fuse", os.O_RDWR, 0)
+	if err != nil {
+		t.Skipf("fuse not available %s", err)
+	}
+	mountPoint := t.TempDir()
+	options := fmt.Sprintf("fd=%d,rootmode=40000,user_id=0,group_id=0", fuseDevice.Fd())
+	err = syscall.Mount("stale", mountPoint, "fuse.stale", 0, options)
+	fuseDevice.Close()
+	if err != nil {
+		t.Skipf("unable to mount fuse %s", err)
+	}
+	defer syscall.Unmount(mountPoint, syscall.MNT_DETACH)
+
+	state, _, err := InspectMountPoint(mountPoint)
+	if err != nil || state != MountPointStale {
+		t.Fatalf("InspectMountPoint() = %s, %v, want %s", state, err, MountPointStale)
+	}
+
+	reused, err := PrepareHostMountPoint(Mount{HostMountPoint: mountPoint}, DaemonLaunch{ProgramPath: "/nonexistent/blobfuse2"}, false)
+	if err != nil || reused {
+		t.Fatalf("PrepareHostMountPoint() = %v, %v, want false, nil", reused, err)
+	}
+	if mounted, _ := IsFuseMounted(mountPoint); mounted {
+		t.Errorf("stale mount at %s not unmounted", mountPoint)
+	}
+	if err := os.MkdirAll(mountPoint, 0755); err != nil {
+		t.Errorf("MkdirAll() on the cleaned mountpoint error = %v", err)
+	}
+}
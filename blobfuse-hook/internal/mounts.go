@@ -0,0 +1,189 @@
+package internal
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Name of the mount described by the single mount hook config fields
+const DefaultMountName = "default"
+
+// Mount names are used as directory names and default to the storage container name
+var mountNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
+// Create a struct to hold a blob mount
+// Each mount gets its own blobfuse2 process, rendered config, cache directory and bind mount
+type Mount struct {
+	// Name of the mount. Defaults to the storage container name
+	Name string `json:"name"`
+
+	// Storage container to mount. Defaults to the name of the mount
+	ContainerName string `json:"container_name,omitempty"`
+
+	// Host mountpoint. Defaults to <host_mountpoint>/<owner>/<name>, see GetMounts
+	HostMountPoint string `json:"host_mountpoint,omitempty"`
+
+	// Container mountpoint
+	ContainerMountPoint string `json:"container_mountpoint"`
+
+	// Mount the storage container read-only
+	ReadOnly bool `json:"read_only,omitempty"`
+
+	// Virtual directory of the storage container to mount instead of the whole container
+	Subdirectory string `json:"subdirectory,omitempty"`
+
+	// Files read through the mount once mounted to warm the cache
+	Prefetch *PrefetchConfig `json:"prefetch,omitempty"`
+
+	// Owner of the mount, see MountOwner
+	Owner string `json:"-"`
+}
+
+// Method to get the mounts of a container from the hookConfig, with the container overrides applied
+// Containers describe their mounts with BLOBFUSE_MOUNTS, see ParseMounts and ApplyOverrides.
+// Without any mounts, the single mount hook config fields describe one mount named "default".
+// Unless set, host mountpoints are <host_mountpoint>/<owner>/<name>, see MountOwner.
+// In node scope they are <host_mountpoint>/<name>, and <host_mountpoint> for the default mount
+func GetMounts(hookConfig Config, owner string) ([]Mount, error) {
+	mounts := hookConfig.Mounts
+
+	if len(mounts) == 0 {
+		if err := checkMount(hookConfig.ContainerMountPoint, hookConfig.Subdirectory); err != nil {
+			return nil, err
+		}
+		hostMountPoint := hookConfig.HostMountPoint
+		if owner != ScopeNode {
+			hostMountPoint = filepath.Join(hookConfig.HostMountPoint, owner, DefaultMountName)
+		}
+		return []Mount{
+			{
+				Name:                DefaultMountName,
+				HostMountPoint:      hostMountPoint,
+				ContainerMountPoint: hookConfig.ContainerMountPoint,
+				ReadOnly:            hookConfig.ReadOnly,
+				Subdirectory:        hookConfig.Subdirectory,
+				Prefetch:            hookConfig.Prefetch,
+				Owner:               owner,
+			},
+		}, nil
+	}
+
+	// Fill in the defaults without modifying hookConfig.Mounts
+	result := make([]Mount, 0, len(mounts))
+	seen := make(map[string]bool)
+	for _, mount := range mounts {
+		if mount.Name == "" {
+			mount.Name = mount.ContainerName
+		}
+		if !mountNameRegexp.MatchString(mount.Name) {
+			return nil, fmt.Errorf("invalid mount name %q", mount.Name)
+		}
+		if seen[mount.Name] {
+			return nil, fmt.Errorf("duplicate mount name %q", mount.Name)
+		}
+		seen[mount.Name] = true
+
+		if mount.ContainerName == "" {
+			mount.ContainerName = mount.Name
+		}
+		if mount.HostMountPoint == "" {
+			if owner == ScopeNode {
+				mount.HostMountPoint = filepath.Join(hookConfig.HostMountPoint, mount.Name)
+			} else {
+				mount.HostMountPoint = filepath.Join(hookConfig.HostMountPoint, owner, mount.Name)
+			}
+		}
+		mount.Owner = owner
+		if mount.ContainerMountPoint == "" {
+			return nil, fmt.Errorf("mount %q has no container mountpoint", mount.Name)
+		}
+		if err := checkMount(mount.ContainerMountPoint, mount.Subdirectory); err != nil {
+			return nil, err
+		}
+		result = append(result, mount)
+	}
+
+	return result, nil
+}
+
+// Method to parse a mounts description of the form
+// <container name>[/<subdirectory>]:<container mountpoint>[:ro|rw],...
+// Mounts are named after the storage container
+func ParseMounts(mountsSpec string) ([]Mount, error) {
+	var mounts []Mount
+
+	for _, entry := range strings.Split(mountsSpec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		fields := strings.Split(entry, ":")
+		if len(fields) < 2 || len(fields) > 3 || fields[0] == "" || fields[1] == "" {
+			return nil, fmt.Errorf("invalid mount %q, expected <container>[/<subdirectory>]:<mountpoint>[:ro|rw]", entry)
+		}
+
+		containerName := fields[0]
+		subdirectory := ""
+		if i := strings.Index(containerName, "/"); i >= 0 {
+			containerName, subdirectory = containerName[:i], containerName[i+1:]
+		}
+		if err := checkContainerMountPoint(fields[1]); err != nil {
+			return nil, err
+		}
+		mount := Mount{
+			Name:                containerName,
+			ContainerName:       containerName,
+			ContainerMountPoint: fields[1],
+			Subdirectory:        subdirectory,
+		}
+		if len(fields) == 3 {
+			switch fields[2] {
+			case "ro":
+				mount.ReadOnly = true
+			case "rw":
+			default:
+				return nil, fmt.Errorf("invalid mount mode %q in %q, expected ro or rw", fields[2], entry)
+			}
+		}
+		mounts = append(mounts, mount)
+	}
+
+	if len(mounts) == 0 {
+		return nil, fmt.Errorf("no mounts in %q", mountsSpec)
+	}
+	return mounts, nil
+}
+
+// Method to check the container mountpoint and the subdirectory of a mount
+func checkMount(containerMountPoint string, subdirectory string) error {
+	if err := checkContainerMountPoint(containerMountPoint); err != nil {
+		return err
+	}
+	return checkSubdirectory(subdirectory)
+}
+
+// A container mountpoint must be an absolute clean path below the container root
+// It is also resolved in the container rootfs when binding, see BindMount
+func checkContainerMountPoint(containerMountPoint string) error {
+	if !path.IsAbs(containerMountPoint) || path.Clean(containerMountPoint) != containerMountPoint ||
+		containerMountPoint == "/" {
+		return fmt.Errorf("invalid container mountpoint %q", containerMountPoint)
+	}
+	return nil
+}
+
+// A subdirectory must stay inside the storage container
+func checkSubdirectory(subdirectory string) error {
+	if subdirectory == "" {
+		return nil
+	}
+	if path.IsAbs(subdirectory) || path.Clean(subdirectory) != strings.TrimSuffix(subdirectory, "/") ||
+		subdirectory == ".." || strings.HasPrefix(subdirectory, "../") {
+		return fmt.Errorf("invalid subdirectory %q", subdirectory)
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMounts(t *testing.T) {
+	tests := []struct {
+		name       string
+		mountsSpec string
+		want       []Mount
+		wantErr    bool
+	}{
+		{
+			name:       "read-only and read-write mounts",
+			mountsSpec: "input:/in:ro,output:/out:rw",
+			want: []Mount{
+				{Name: "input", ContainerName: "input", ContainerMountPoint: "/in", ReadOnly: true},
+				{Name: "output", ContainerName: "output", ContainerMountPoint: "/out"},
+			},
+		},
+		{
+			name:       "default mode is read-write",
+			mountsSpec: "data:/data",
+			want:       []Mount{{Name: "data", ContainerName: "data", ContainerMountPoint: "/data"}},
+		},
+		{
+			name:       "subdirectories",
+			mountsSpec: "models/tenant-a:/models:ro,shared/a/b/:/shared",
+			want: []Mount{
+				{Name: "models", ContainerName: "models", ContainerMountPoint: "/models", ReadOnly: true, Subdirectory: "tenant-a"},
+				{Name: "shared", ContainerName: "shared", ContainerMountPoint: "/shared", Subdirectory: "a/b/"},
+			},
+		},
+		{
+			name:       "invalid mode",
+			mountsSpec: "data:/data:rx",
+			wantErr:    true,
+		},
+		{
+			name:       "missing mountpoint",
+			mountsSpec: "data",
+			wantErr:    true,
+		},
+		{
+			name:       "empty",
+			mountsSpec: " , ",
+			wantErr:    true,
+		},
+		{
+			name:       "mountpoint escaping the rootfs",
+			mountsSpec: "data:/../../../../etc",
+			wantErr:    true,
+		},
+		{
+			name:       "relative mountpoint",
+			mountsSpec: "data:data",
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMounts(tt.mountsSpec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseMounts() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseMounts() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetMounts(t *testing.T) {
+	hookConfig := Config{
+		HostMountPoint:      "/blobdata",
+		ContainerMountPoint: "/data",
+	}
+
+	tests := []struct {
+		name    string
+		config  func(c *Config)
+		mounts  []Mount
+		owner   string
+		want    []Mount
+		wantErr bool
+	}{
+		{
+			name:  "single mount hook config in node scope",
+			owner: ScopeNode,
+			want:  []Mount{{Name: DefaultMountName, HostMountPoint: "/blobdata", ContainerMountPoint: "/data", Owner: ScopeNode}},
+		},
+		{
+			name:  "single mount hook config",
+			owner: "c1",
+			want:  []Mount{{Name: DefaultMountName, HostMountPoint: "/blobdata/c1/default", ContainerMountPoint: "/data", Owner: "c1"}},
+		},
+		{
+			name:   "mounts",
+			mounts: []Mount{{Name: "input", ContainerMountPoint: "/in", ReadOnly: true}, {ContainerName: "output", ContainerMountPoint: "/out"}},
+			owner:  "c1",
+			want: []Mount{
+				{Name: "input", ContainerName: "input", HostMountPoint: "/blobdata/c1/input", ContainerMountPoint: "/in", ReadOnly: true, Owner: "c1"},
+				{Name: "output", ContainerName: "output", HostMountPoint: "/blobdata/c1/output", ContainerMountPoint: "/out", Owner: "c1"},
+			},
+		},
+		{
+			name:   "mounts in node scope",
+			mounts: []Mount{{Name: "models", ContainerMountPoint: "/models"}},
+			owner:  ScopeNode,
+			want: []Mount{
+				{Name: "models", ContainerName: "models", HostMountPoint: "/blobdata/models", ContainerMountPoint: "/models", Owner: ScopeNode},
+			},
+		},
+		{
+			name:    "duplicate mount names",
+			mounts:  []Mount{{Name: "input", ContainerMountPoint: "/in"}, {Name: "input", ContainerMountPoint: "/in2"}},
+			wantErr: true,
+		},
+		{
+			name:    "mount name escaping the host mountpoint",
+			mounts:  []Mount{{Name: "..", ContainerMountPoint: "/in"}},
+			wantErr: true,
+		},
+		{
+			name:   "subdirectory",
+			mounts: []Mount{{Name: "models", ContainerMountPoint: "/models", Subdirectory: "tenant-a/v1", ReadOnly: true}},
+			owner:  "c1",
+			want: []Mount{
+				{Name: "models", ContainerName: "models", HostMountPoint: "/blobdata/c1/models", ContainerMountPoint: "/models", ReadOnly: true, Subdirectory: "tenant-a/v1", Owner: "c1"},
+			},
+		},
+		{
+			name:    "subdirectory escaping the storage container",
+			mounts:  []Mount{{Name: "models", ContainerMountPoint: "/models", Subdirectory: "tenant-a/../../tenant-b"}},
+			wantErr: true,
+		},
+		{
+			name:    "absolute subdirectory",
+			mounts:  []Mount{{Name: "models", ContainerMountPoint: "/models", Subdirectory: "/tenant-a"}},
+			wantErr: true,
+		},
+		{
+			name:    "missing container mountpoint",
+			mounts:  []Mount{{Name: "input"}},
+			wantErr: true,
+		},
+		{
+			name:    "container mountpoint escaping the rootfs",
+			mounts:  []Mount{{Name: "input", ContainerMountPoint: "/in/../../etc"}},
+			wantErr: true,
+		},
+		{
+			name:    "container root as mountpoint",
+			mounts:  []Mount{{Name: "input", ContainerMountPoint: "/"}},
+			wantErr: true,
+		},
+		{
+			name:    "default mount escaping the rootfs",
+			config:  func(c *Config) { c.ContainerMountPoint = "/../../../../etc" },
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := hookConfig
+			config.Mounts = tt.mounts
+			if tt.config != nil {
+				tt.config(&config)
+			}
+			got, err := GetMounts(config, tt.owner)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetMounts() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetMounts() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+// Network namespaces of the fuse daemon, see DaemonConfig
+const (
+	NetNSHost      = "host"
+	NetNSContainer = "container"
+)
+
+// Method to get the network namespace the daemon of a container mount runs in
+// Returns "" for the host network namespace. The container network namespace is the
+// path in linux.namespaces, eg. the pod sandbox netns, or /proc/<pid>/ns/net
+func DaemonNetNS(hookConfig Config, containerConfig specs.Spec, pid int, owner string) (string, error) {
+	switch hookConfig.Daemon.NetworkNamespace {
+	case "", NetNSHost:
+		return "", nil
+	case NetNSContainer:
+	default:
+		return "", fmt.Errorf("invalid network namespace %q", hookConfig.Daemon.NetworkNamespace)
+	}
+
+	// The containers of a node don't share a network namespace
+	if owner == ScopeNode {
+		return "", fmt.Errorf("container network namespace requires container or pod scope")
+	}
+
+	if containerConfig.Linux != nil {
+		for _, namespace := range containerConfig.Linux.Namespaces {
+			if namespace.Type != specs.NetworkNamespace {
+				continue
+			}
+			if namespace.Path != "" {
+				return namespace.Path, nil
+			}
+			break
+		}
+	}
+
+	if pid <= 0 {
+		return "", fmt.Errorf("no container pid to enter its network namespace")
+	}
+	return fmt.Sprintf("/proc/%d/ns/net", pid), nil
+}
+
+// Method to move the calling thread to the network namespace at path
+// Only for a dedicated thread, see hookutil.RunOnDedicatedThread
+func enterNetNS(path string) error {
+	netNS, err := os.Open(path)
+	if err != nil {
+		log.Printf("unable to open network namespace %s\n", err)
+		return err
+	}
+	defer netNS.Close()
+
+	if err := unix.Setns(int(netNS.Fd()), unix.CLONE_NEWNET); err != nil {
+		log.Printf("unable to enter network namespace %s %s\n", path, err)
+		return err
+	}
+	log.Printf("daemon network namespace is %s\n", path)
+	return nil
+}
@@ -0,0 +1,97 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func TestDaemonNetNS(t *testing.T) {
+	podNetNS := specs.Spec{Linux: &specs.Linux{Namespaces: []specs.LinuxNamespace{
+		{Type: specs.MountNamespace},
+		{Type: specs.NetworkNamespace, Path: "/var/run/netns/cni-1"},
+	}}}
+	newNetNS := specs.Spec{Linux: &specs.Linux{Namespaces: []specs.LinuxNamespace{{Type: specs.NetworkNamespace}}}}
+
+	tests := []struct {
+		name      string
+		netNS     string
+		spec      specs.Spec
+		pid       int
+		owner     string
+		want      string
+		wantError bool
+	}{
+		{name: "host by default", spec: podNetNS, pid: 42, owner: "c1", want: ""},
+		{name: "host", netNS: NetNSHost, spec: podNetNS, pid: 42, owner: "c1", want: ""},
+		{name: "pod network namespace", netNS: NetNSContainer, spec: podNetNS, pid: 42, owner: "pod-1", want: "/var/run/netns/cni-1"},
+		{name: "network namespace of the container process", netNS: NetNSContainer, spec: newNetNS, pid: 42, owner: "c1", want: "/proc/42/ns/net"},
+		{name: "no container pid", netNS: NetNSContainer, spec: newNetNS, owner: "c1", wantError: true},
+		{name: "node scope", netNS: NetNSContainer, spec: podNetNS, pid: 42, owner: ScopeNode, wantError: true},
+		{name: "invalid", netNS: "pod", spec: podNetNS, pid: 42, owner: "c1", wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hookConfig := Config{Daemon: DaemonConfig{NetworkNamespace: tt.netNS}}
+			got, err := DaemonNetNS(hookConfig, tt.spec, tt.pid, tt.owner)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("DaemonNetNS() error = %v, wantError %v", err, tt.wantError)
+			}
+			if got != tt.want {
+				t.Errorf("DaemonNetNS() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStartDaemonProcessNetNS(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("entering a network namespace requires root")
+	}
+	if _, err := exec.LookPath("unshare"); err != nil {
+		t.Skip("unshare not found")
+	}
+
+	// A container in its own network namespace
+	container := exec.Command("unshare", "--net", "sleep", "30")
+	if err := container.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		container.Process.Kill()
+		container.Wait()
+	}()
+	netNS := fmt.Sprintf("/proc/%d/ns/net", container.Process.Pid)
+	hostNetNS, _ := os.Readlink("/proc/self/ns/net")
+	var want string
+	for i := 0; i < 100; i++ {
+		if want, _ = os.Readlink(netNS); want != hostNetNS {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cmd := exec.Command("/bin/sh", "-c", "readlink /proc/self/ns/net")
+	var out strings.Builder
+	cmd.Stdout = &out
+	if err := startDaemonProcess(cmd, DaemonConfig{}, netNS); err != nil {
+		t.Fatalf("startDaemonProcess() error = %v", err)
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(out.String()); got != want {
+		t.Errorf("daemon network namespace = %s, want %s", got, want)
+	}
+
+	// The hook stays in its network namespace
+	if got, _ := os.Readlink("/proc/self/ns/net"); got != hostNetNS {
+		t.Errorf("hook network namespace = %s, want %s", got, hostNetNS)
+	}
+}
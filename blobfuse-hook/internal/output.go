@@ -0,0 +1,152 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Number of lines of the blobfuse2 log file attached to errors
+const logTailLines = 20
+
+// Maximum number of bytes read from the end of the blobfuse2 log file
+const logTailBytes = 16 * 1024
+
+// Create a struct to hold a writer logging each line written to it
+// Used to stream the output of blobfuse2 into the hook log
+type lineLogger struct {
+	mu     sync.Mutex
+	entry  *logrus.Entry
+	stream string
+	buf    bytes.Buffer
+}
+
+func newLineLogger(entry *logrus.Entry, stream string) *lineLogger {
+	return &lineLogger{entry: entry, stream: stream}
+}
+
+func (l *lineLogger) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.buf.Write(p)
+	for {
+		line, err := l.buf.ReadString('\n')
+		if err != nil {
+			// Keep the partial line for the next write
+			l.buf.WriteString(line)
+			break
+		}
+		l.log(strings.TrimRight(line, "\r\n"))
+	}
+	return len(p), nil
+}
+
+// Log the remaining partial line
+func (l *lineLogger) Flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.buf.Len() != 0 {
+		l.log(l.buf.String())
+		l.buf.Reset()
+	}
+}
+
+func (l *lineLogger) log(line string) {
+	l.entry.WithField("stream", l.stream).Info(line)
+}
+
+// Method to log how a process exited, with its exit code or signal
+func logExitStatus(entry *logrus.Entry, state *os.ProcessState) {
+	if state == nil {
+		entry.Info("process did not start")
+		return
+	}
+	status, ok := state.Sys().(syscall.WaitStatus)
+	switch {
+	case ok && status.Signaled():
+		entry.WithField("signal", status.Signal().String()).Info("process killed by signal")
+	default:
+		entry.WithField("exit_code", state.ExitCode()).Info("process exited")
+	}
+}
+
+// Method to get the log file of blobfuse2 from its config file
+// Returns "" unless the logging section sets a file-path
+func BlobFuseLogFile(configFile string) string {
+	file, err := os.Open(configFile)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	section := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "\t") {
+			section = strings.TrimSuffix(trimmed, ":")
+			continue
+		}
+		if section == "logging" && strings.HasPrefix(trimmed, "file-path:") {
+			return strings.Trim(strings.TrimSpace(strings.TrimPrefix(trimmed, "file-path:")), `"'`)
+		}
+	}
+	return ""
+}
+
+// Method to get the last lines of a log file
+func tailFile(logFile string, lines int) (string, error) {
+	file, err := os.Open(logFile)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	offset := info.Size() - logTailBytes
+	if offset < 0 {
+		offset = 0
+	}
+	data := make([]byte, info.Size()-offset)
+	if _, err := file.ReadAt(data, offset); err != nil {
+		return "", err
+	}
+
+	tail := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if offset > 0 && len(tail) > 1 {
+		// Drop the partial first line
+		tail = tail[1:]
+	}
+	if len(tail) > lines {
+		tail = tail[len(tail)-lines:]
+	}
+	return strings.Join(tail, "\n"), nil
+}
+
+// Method to attach the tail of the blobfuse2 log file to err
+func withLogTail(err error, configFile string) error {
+	logFile := BlobFuseLogFile(configFile)
+	if logFile == "" {
+		return err
+	}
+	tail, tailErr := tailFile(logFile, logTailLines)
+	if tailErr != nil || tail == "" {
+		return err
+	}
+	return fmt.Errorf("%w\nlast lines of %s:\n%s", err, logFile, tail)
+}
@@ -0,0 +1,87 @@
+package internal
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestLineLogger(t *testing.T) {
+	var out bytes.Buffer
+	logger := logrus.New()
+	logger.Out = &out
+
+	w := newLineLogger(logger.WithField("blobfuse", "input"), "stderr")
+	w.Write([]byte("first line\nsecond "))
+	w.Write([]byte("line\npartial"))
+	w.Flush()
+
+	got := out.String()
+	for _, want := range []string{`msg="first line"`, `msg="second line"`, "msg=partial", "blobfuse=input", "stream=stderr"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("log %q does not contain %q", got, want)
+		}
+	}
+	if n := strings.Count(got, "\n"); n != 3 {
+		t.Errorf("logged %d lines, want 3", n)
+	}
+}
+
+func TestBlobFuseLogFile(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "blobfuse2.yaml")
+	config := "logging:\n  type: base\n  file-path: \"/var/log/blobfuse2.log\"\n\nfile_cache:\n  file-path: /wrong\n"
+	if err := os.WriteFile(configFile, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got := BlobFuseLogFile(configFile); got != "/var/log/blobfuse2.log" {
+		t.Errorf("BlobFuseLogFile() = %q, want /var/log/blobfuse2.log", got)
+	}
+	if got := BlobFuseLogFile(filepath.Join(dir, "missing")); got != "" {
+		t.Errorf("BlobFuseLogFile() = %q for a missing config", got)
+	}
+}
+
+func TestStartDaemonFailure(t *testing.T) {
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "blobfuse2.log")
+	configFile := filepath.Join(dir, "blobfuse2.yaml")
+	if err := os.WriteFile(configFile, []byte("logging:\n  file-path: "+logFile+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var logLines []string
+	for i := 0; i < 30; i++ {
+		logLines = append(logLines, "log line "+string(rune('a'+i%26)))
+	}
+	logLines = append(logLines, "failed to authenticate")
+	if err := os.WriteFile(logFile, []byte(strings.Join(logLines, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	program := filepath.Join(dir, "blobfuse2")
+	if err := os.WriteFile(program, []byte("#!/bin/sh\necho mounting\necho bad credentials >&2\nexit 3\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	hookConfig := Config{ProgramPath: program}
+	mount := Mount{Name: "input", HostMountPoint: filepath.Join(dir, "mnt")}
+	launch, err := NewDaemonLaunch(nil, hookConfig, TemplateValues{MountPoint: mount.HostMountPoint}, configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = StartDaemon(hookConfig, mount, launch)
+	if err == nil {
+		t.Fatal("StartDaemon() succeeded")
+	}
+	if !strings.Contains(err.Error(), "exit status 3") || !strings.Contains(err.Error(), "failed to authenticate") {
+		t.Errorf("StartDaemon() error = %q, want the exit status and the log tail", err)
+	}
+	if strings.Count(err.Error(), "log line") != logTailLines-1 {
+		t.Errorf("StartDaemon() error has %d log lines, want %d", strings.Count(err.Error(), "log line"), logTailLines-1)
+	}
+}
@@ -0,0 +1,222 @@
+package internal
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Prefix of the env variables overriding hook config fields
+// Eg. BLOBFUSE_CONTAINER_MOUNTPOINT=/data
+const OverrideEnvPrefix = "BLOBFUSE_"
+
+// Fields containers may override when the hook config has no overrides policy
+// Only the container mountpoint, it is checked and resolved in the container rootfs.
+// The hook config has to allow any other field and its values, see Config.Overrides
+var DefaultOverrides = map[string][]string{"container_mountpoint": nil}
+
+// Create a struct to hold a hook config field that containers can override
+// The field is set by the annotation blobfuse.kata-hooks.io/<name>, or else by the env
+// variable BLOBFUSE_<NAME>, or else by one of the alias env variables
+type overrideField struct {
+	name    string
+	aliases []string
+	// Split a value into the items checked against the policy, nil for single values
+	split func(value string) []string
+	// The field decides where the credentials of the hook config are sent, see Credentials
+	credentials bool
+	set         func(hookConfig *Config, value string) error
+}
+
+var overrideFields = []overrideField{
+	{
+		name:    "container_mountpoint",
+		aliases: []string{"CONTAINER_MOUNT_POINT"},
+		set:     func(c *Config, v string) error { c.ContainerMountPoint = v; return nil },
+	},
+	{
+		name: "read_only",
+		set: func(c *Config, v string) error {
+			readOnly, err := strconv.ParseBool(v)
+			c.ReadOnly = readOnly
+			return err
+		},
+	},
+	{
+		name: "subdirectory",
+		set:  func(c *Config, v string) error { c.Subdirectory = v; return nil },
+	},
+	{
+		name:  "prefetch",
+		split: func(v string) []string { return strings.Split(v, ",") },
+		set: func(c *Config, v string) error {
+			// Keep the other prefetch settings of the hook config
+			prefetch := PrefetchConfig{}
+			if c.Prefetch != nil {
+				prefetch = *c.Prefetch
+			}
+			prefetch.Globs = strings.Split(v, ",")
+			prefetch.Manifest = ""
+			c.Prefetch = &prefetch
+			return nil
+		},
+	},
+	{
+		name:  "mounts",
+		split: func(v string) []string { return strings.Split(v, ",") },
+		set: func(c *Config, v string) error {
+			mounts, err := ParseMounts(v)
+			c.Mounts = mounts
+			return err
+		},
+	},
+	{
+		name:        "account_name",
+		aliases:     []string{"AZURE_STORAGE_ACCOUNT"},
+		credentials: true,
+		set:         func(c *Config, v string) error { c.AccountName = v; return nil },
+	},
+	{
+		name:    "container_name",
+		aliases: []string{"AZURE_STORAGE_ACCOUNT_CONTAINER"},
+		set:     func(c *Config, v string) error { c.ContainerName = v; return nil },
+	},
+	{
+		name:        "endpoint",
+		aliases:     []string{"AZURE_STORAGE_BLOB_ENDPOINT"},
+		credentials: true,
+		set:         func(c *Config, v string) error { c.Endpoint = v; return nil },
+	},
+	{
+		name:        "auth_mode",
+		aliases:     []string{"AZURE_STORAGE_AUTH_TYPE"},
+		credentials: true,
+		set:         func(c *Config, v string) error { c.AuthMode = v; return nil },
+	},
+	{
+		name: "cache_size_mb",
+		set:  func(c *Config, v string) error { return setInt(&c.CacheSizeMB, v) },
+	},
+	{
+		name: "cache_timeout_sec",
+		set:  func(c *Config, v string) error { return setInt(&c.CacheTimeoutSec, v) },
+	},
+	{
+		name: "cache_mode",
+		set:  func(c *Config, v string) error { c.Cache.Mode = v; _, err := cacheMode(*c); return err },
+	},
+	{
+		name:  "blobfuse_flags",
+		split: strings.Fields,
+		set:   func(c *Config, v string) error { c.BlobFuseFlags = strings.Fields(v); return nil },
+	},
+}
+
+// Method to apply the container overrides to a copy of the hookConfig
+// The hookConfig.Overrides policy maps the fields containers may override to the
+// allowed values (path.Match patterns, any value when empty). Overriding any other
+// field or with any other value is an error, except for the alias env variables which
+// containers also set for other purposes (eg. AZURE_STORAGE_ACCOUNT forwarded to
+// blobfuse2): they are logged and ignored when the policy doesn't allow their field.
+// With hookConfig.Credentials, the fields deciding where the credentials are sent are
+// never overridden, whatever the policy
+func ApplyOverrides(hookConfig Config, env []string, annotations map[string]string) (Config, error) {
+	policy := hookConfig.Overrides
+	if policy == nil {
+		policy = DefaultOverrides
+	}
+
+	// Don't share the mounts with the caller's hookConfig
+	hookConfig.Mounts = append([]Mount(nil), hookConfig.Mounts...)
+
+	for _, field := range overrideFields {
+		value, source, alias, found := lookupOverride(field, env, annotations)
+		if !found {
+			continue
+		}
+
+		allowedValues, allowed := policy[field.name]
+		if !allowed && alias {
+			log.Printf("ignoring %s, the hook config doesn't allow overriding %s\n", source, field.name)
+			continue
+		}
+		if !allowed {
+			return hookConfig, fmt.Errorf("%s may not override %s", source, field.name)
+		}
+
+		// The node credentials only go to the account and endpoint of the hook config
+		if field.credentials && hookConfig.Credentials != nil {
+			return hookConfig, fmt.Errorf("%s may not override %s of the hook credentials", source, field.name)
+		}
+
+		// The values end up in the rendered blobfuse2 config and in argv
+		if strings.IndexFunc(value, unicode.IsControl) >= 0 {
+			return hookConfig, fmt.Errorf("%s value %q for %s has control characters", source, value, field.name)
+		}
+
+		items := []string{value}
+		if field.split != nil {
+			items = field.split(value)
+		}
+		for _, item := range items {
+			if !isValueAllowed(strings.TrimSpace(item), allowedValues) {
+				return hookConfig, fmt.Errorf("%s value %q is not allowed for %s", source, item, field.name)
+			}
+		}
+
+		if err := field.set(&hookConfig, value); err != nil {
+			return hookConfig, fmt.Errorf("invalid %s value %q for %s: %s", source, value, field.name, err)
+		}
+		log.Printf("%s overrides %s\n", source, field.name)
+	}
+
+	return hookConfig, nil
+}
+
+// Return the alias env variables of the fields deciding where the credentials are sent
+func credentialEnvAliases() []string {
+	var aliases []string
+	for _, field := range overrideFields {
+		if field.credentials {
+			aliases = append(aliases, field.aliases...)
+		}
+	}
+	return aliases
+}
+
+// Find the override of a field, returning its value, where it came from and
+// whether it came from an alias env variable
+func lookupOverride(field overrideField, env []string, annotations map[string]string) (string, string, bool, bool) {
+	annotation := AnnotationPrefix + field.name
+	if value, ok := annotations[annotation]; ok {
+		return value, "annotation " + annotation, false, true
+	}
+
+	envName := OverrideEnvPrefix + strings.ToUpper(field.name)
+	if value, ok := GetEnvValue(env, envName); ok {
+		return value, "env " + envName, false, true
+	}
+
+	for _, alias := range field.aliases {
+		if value, ok := GetEnvValue(env, alias); ok {
+			return value, "env " + alias, true, true
+		}
+	}
+
+	return "", "", false, false
+}
+
+// Check if value matches one of the allowed patterns, any value is allowed when there are none
+func isValueAllowed(value string, allowedValues []string) bool {
+	if len(allowedValues) == 0 {
+		return true
+	}
+	for _, pattern := range allowedValues {
+		if matched, err := path.Match(pattern, value); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,216 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplyOverrides(t *testing.T) {
+	hookConfig := Config{
+		HostMountPoint:      "/blobdata",
+		ContainerMountPoint: "/data",
+		AccountName:         "defaultaccount",
+		CacheSizeMB:         1024,
+	}
+
+	tests := []struct {
+		name        string
+		overrides   map[string][]string
+		env         []string
+		annotations map[string]string
+		creds       *Credentials
+		want        func(c *Config)
+		wantErr     bool
+	}{
+		{
+			name: "no overrides",
+			want: func(c *Config) {},
+		},
+		{
+			name:      "CONTAINER_MOUNT_POINT sets the container mountpoint",
+			overrides: map[string][]string{"container_mountpoint": nil},
+			env:       []string{"PATH=/bin", "CONTAINER_MOUNT_POINT=/mnt/blob"},
+			want:      func(c *Config) { c.ContainerMountPoint = "/mnt/blob" },
+		},
+		{
+			name:      "BLOBFUSE_ env takes precedence over aliases",
+			overrides: map[string][]string{"container_mountpoint": nil, "account_name": nil},
+			env:       []string{"BLOBFUSE_CONTAINER_MOUNTPOINT=/mnt/a", "CONTAINER_MOUNT_POINT=/mnt/b", "AZURE_STORAGE_ACCOUNT=envaccount"},
+			want:      func(c *Config) { c.ContainerMountPoint = "/mnt/a"; c.AccountName = "envaccount" },
+		},
+		{
+			name:        "annotation takes precedence over env",
+			overrides:   map[string][]string{"mounts": nil, "cache_size_mb": nil},
+			env:         []string{"BLOBFUSE_MOUNTS=input:/in:ro"},
+			annotations: map[string]string{AnnotationPrefix + "mounts": "models:/models:ro", AnnotationPrefix + "cache_size_mb": "10"},
+			want: func(c *Config) {
+				c.Mounts = []Mount{{Name: "models", ContainerName: "models", ContainerMountPoint: "/models", ReadOnly: true}}
+				c.CacheSizeMB = 10
+			},
+		},
+		{
+			name:      "cache mode",
+			overrides: map[string][]string{"cache_mode": nil},
+			env:       []string{"BLOBFUSE_CACHE_MODE=stream"},
+			want:      func(c *Config) { c.Cache.Mode = CacheModeStream },
+		},
+		{
+			name:      "invalid cache mode",
+			overrides: map[string][]string{"cache_mode": nil},
+			env:       []string{"BLOBFUSE_CACHE_MODE=memory"},
+			wantErr:   true,
+		},
+		{
+			name:      "endpoint with hook credentials",
+			overrides: map[string][]string{"endpoint": nil},
+			env:       []string{"AZURE_STORAGE_BLOB_ENDPOINT=https://attacker.example.com"},
+			creds:     &Credentials{Source: CredentialSourceMSI},
+			wantErr:   true,
+		},
+		{
+			name:        "account with hook credentials",
+			overrides:   map[string][]string{"account_name": nil},
+			annotations: map[string]string{AnnotationPrefix + "account_name": "attacker"},
+			creds:       &Credentials{Source: CredentialSourceKey},
+			wantErr:     true,
+		},
+		{
+			name:      "auth mode with hook credentials",
+			overrides: map[string][]string{"auth_mode": nil},
+			env:       []string{"BLOBFUSE_AUTH_MODE=key"},
+			creds:     &Credentials{Source: CredentialSourceMSI},
+			wantErr:   true,
+		},
+		{
+			name:      "container name with hook credentials",
+			overrides: map[string][]string{"container_name": nil},
+			env:       []string{"AZURE_STORAGE_ACCOUNT_CONTAINER=models"},
+			creds:     &Credentials{Source: CredentialSourceMSI},
+			want:      func(c *Config) { c.ContainerName = "models" },
+		},
+		{
+			name: "CONTAINER_MOUNT_POINT allowed by default",
+			env:  []string{"CONTAINER_MOUNT_POINT=/mnt/blob"},
+			want: func(c *Config) { c.ContainerMountPoint = "/mnt/blob" },
+		},
+		{
+			name: "AZURE_STORAGE_ACCOUNT ignored without overrides",
+			env:  []string{"AZURE_STORAGE_ACCOUNT=coco", "AZURE_STORAGE_ACCOUNT_CONTAINER=data", "AZURE_STORAGE_AUTH_TYPE=key"},
+			want: func(c *Config) {},
+		},
+		{
+			name:  "AZURE_STORAGE_BLOB_ENDPOINT ignored with hook credentials",
+			env:   []string{"AZURE_STORAGE_BLOB_ENDPOINT=https://attacker.example.com"},
+			creds: &Credentials{Source: CredentialSourceMSI},
+			want:  func(c *Config) {},
+		},
+		{
+			name:        "account annotation not allowed by default",
+			annotations: map[string]string{AnnotationPrefix + "account_name": "attacker"},
+			wantErr:     true,
+		},
+		{
+			name:    "mounts not allowed by default",
+			env:     []string{"BLOBFUSE_MOUNTS=input:/in:ro"},
+			wantErr: true,
+		},
+		{
+			name:      "prefetch globs allowed by the policy",
+			overrides: map[string][]string{"prefetch": {"models/*"}},
+			env:       []string{"BLOBFUSE_PREFETCH=models/*"},
+			want:      func(c *Config) { c.Prefetch = &PrefetchConfig{Globs: []string{"models/*"}} },
+		},
+		{
+			name:    "prefetch not allowed by default",
+			env:     []string{"BLOBFUSE_PREFETCH=*"},
+			wantErr: true,
+		},
+		{
+			name:    "field not allowed by default",
+			env:     []string{"BLOBFUSE_BLOBFUSE_FLAGS=--allow-other"},
+			wantErr: true,
+		},
+		{
+			name:    "subdirectory not allowed by default",
+			env:     []string{"BLOBFUSE_SUBDIRECTORY=tenant-b"},
+			wantErr: true,
+		},
+		{
+			name:      "subdirectory allowed by policy",
+			overrides: map[string][]string{"subdirectory": {"tenant-a/*"}},
+			env:       []string{"BLOBFUSE_SUBDIRECTORY=tenant-a/models"},
+			want:      func(c *Config) { c.Subdirectory = "tenant-a/models" },
+		},
+		{
+			name:      "field not allowed by policy",
+			overrides: map[string][]string{"read_only": nil},
+			env:       []string{"BLOBFUSE_CONTAINER_MOUNTPOINT=/mnt/blob"},
+			wantErr:   true,
+		},
+		{
+			name:      "alias not allowed by policy",
+			overrides: map[string][]string{"read_only": nil},
+			env:       []string{"CONTAINER_MOUNT_POINT=/mnt/blob"},
+			want:      func(c *Config) {},
+		},
+		{
+			name:      "value allowed by policy",
+			overrides: map[string][]string{"container_mountpoint": {"/mnt/*"}, "blobfuse_flags": {"--log-level=*", "--no-symlinks"}},
+			env:       []string{"CONTAINER_MOUNT_POINT=/mnt/blob", "BLOBFUSE_BLOBFUSE_FLAGS=--log-level=LOG_DEBUG --no-symlinks"},
+			want: func(c *Config) {
+				c.ContainerMountPoint = "/mnt/blob"
+				c.BlobFuseFlags = []string{"--log-level=LOG_DEBUG", "--no-symlinks"}
+			},
+		},
+		{
+			name:      "value not allowed by policy",
+			overrides: map[string][]string{"blobfuse_flags": {"--log-level=*"}},
+			env:       []string{"BLOBFUSE_BLOBFUSE_FLAGS=--log-level=LOG_DEBUG --allow-other"},
+			wantErr:   true,
+		},
+		{
+			name:      "mount not allowed by policy",
+			overrides: map[string][]string{"mounts": {"input:/in:ro"}},
+			env:       []string{"BLOBFUSE_MOUNTS=input:/in:ro,output:/out"},
+			wantErr:   true,
+		},
+		{
+			name:      "invalid value",
+			overrides: map[string][]string{"read_only": nil},
+			env:       []string{"BLOBFUSE_READ_ONLY=maybe"},
+			wantErr:   true,
+		},
+		{
+			name:        "newline in a value",
+			overrides:   map[string][]string{"container_name": nil},
+			annotations: map[string]string{AnnotationPrefix + "container_name": "data\n  endpoint: https://attacker"},
+			wantErr:     true,
+		},
+		{
+			name:      "control character in a value",
+			overrides: map[string][]string{"account_name": nil},
+			env:       []string{"AZURE_STORAGE_ACCOUNT=acc\x1bount"},
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := hookConfig
+			config.Overrides = tt.overrides
+			config.Credentials = tt.creds
+			got, err := ApplyOverrides(config, tt.env, tt.annotations)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ApplyOverrides() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			want := config
+			tt.want(&want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("ApplyOverrides() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,410 @@
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Defaults of the prefetch
+const (
+	DefaultPrefetchTimeoutSec           = 30
+	DefaultBackgroundPrefetchTimeoutSec = 600
+	DefaultPrefetchParallel             = 4
+	prefetchProgressInterval            = time.Second
+)
+
+// States of a prefetch in the hook state
+const (
+	PrefetchRunning  = "running"
+	PrefetchDone     = "done"
+	PrefetchTimedOut = "timed_out"
+	PrefetchFailed   = "failed"
+)
+
+// Create a struct to hold the files read through a mount once mounted to warm its cache
+// Eg. {"globs": ["models/*.onnx"], "manifest": "models/prefetch.txt"}
+type PrefetchConfig struct {
+	// Glob patterns of the files, relative to the mount. See filepath.Match
+	Globs []string `json:"globs,omitempty"`
+
+	// File of the mount listing the files to read, relative to the mount, one per line
+	// Empty lines and lines starting with # are ignored
+	Manifest string `json:"manifest,omitempty"`
+
+	// Read the files in a background process instead of before the hook returns
+	Background bool `json:"background,omitempty"`
+
+	// Time budget of the prefetch. Default is 30 before the hook returns, 600 in the background
+	TimeoutSec int `json:"timeout_sec,omitempty"`
+
+	// Files read in parallel. Default is 4
+	Parallel int `json:"parallel,omitempty"`
+}
+
+// Create a struct to hold the progress of the prefetch of a mount, recorded in the hook state
+// Cache hits are the files already in the cache directory of the mount before being read
+type PrefetchStats struct {
+	State       string    `json:"state"`
+	Files       int       `json:"files"`
+	FilesRead   int       `json:"files_read"`
+	BytesRead   int64     `json:"bytes_read"`
+	CacheHits   int       `json:"cache_hits"`
+	CacheMisses int       `json:"cache_misses"`
+	Errors      int       `json:"errors"`
+	StartedAt   time.Time `json:"started_at"`
+	FinishedAt  time.Time `json:"finished_at"`
+}
+
+// Method to list the files to prefetch from the mount at mountPoint
+// Returns the sorted paths relative to mountPoint of the regular files matched
+func PrefetchFiles(mountPoint string, prefetch PrefetchConfig) ([]string, error) {
+	patterns := append([]string(nil), prefetch.Globs...)
+
+	if prefetch.Manifest != "" {
+		manifest, err := prefetchPath(mountPoint, prefetch.Manifest)
+		if err != nil {
+			return nil, err
+		}
+		file, err := os.Open(manifest)
+		if err != nil {
+			log.Printf("unable to open prefetch manifest %s\n", err)
+			return nil, err
+		}
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			patterns = append(patterns, line)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	seen := make(map[string]bool)
+	var files []string
+	for _, pattern := range patterns {
+		fullPattern, err := prefetchPath(mountPoint, pattern)
+		if err != nil {
+			return nil, err
+		}
+		matches, err := filepath.Glob(fullPattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid prefetch pattern %q: %s", pattern, err)
+		}
+		for _, match := range matches {
+			info, err := os.Lstat(match)
+			if err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			rel, err := filepath.Rel(mountPoint, match)
+			if err != nil || seen[rel] {
+				continue
+			}
+			seen[rel] = true
+			files = append(files, rel)
+		}
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// Return the path of a prefetch pattern in the mount, which must stay inside the mount
+func prefetchPath(mountPoint string, pattern string) (string, error) {
+	clean := filepath.Clean(pattern)
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("invalid prefetch path %q, must be relative to the mount", pattern)
+	}
+	return filepath.Join(mountPoint, clean), nil
+}
+
+// Method to read the prefetch files through the mount at mountPoint
+// The files already in cacheDir, if set, are counted as cache hits. Reading stops once
+// timeout, if set, is elapsed. progress, if not nil, is called with the stats while reading
+// and once done
+func RunPrefetch(mountPoint string, cacheDir string, prefetch PrefetchConfig, timeout time.Duration, progress func(PrefetchStats)) PrefetchStats {
+	stats := PrefetchStats{State: PrefetchRunning, StartedAt: time.Now()}
+
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = stats.StartedAt.Add(timeout)
+	}
+	expired := func() bool { return !deadline.IsZero() && time.Now().After(deadline) }
+
+	// Set once finished, the reads still running after the deadline are not recorded
+	finished := false
+	finish := func(state string) PrefetchStats {
+		finished = true
+		stats.State = state
+		stats.FinishedAt = time.Now()
+		log.Printf("prefetch of %s %s: %d/%d files, %d bytes, %d cache hits, %d cache misses, %d errors in %s\n",
+			mountPoint, state, stats.FilesRead, stats.Files, stats.BytesRead, stats.CacheHits, stats.CacheMisses,
+			stats.Errors, stats.FinishedAt.Sub(stats.StartedAt).Round(time.Millisecond))
+		if progress != nil {
+			progress(stats)
+		}
+		return stats
+	}
+
+	files, err := PrefetchFiles(mountPoint, prefetch)
+	if err != nil {
+		stats.Errors++
+		return finish(PrefetchFailed)
+	}
+	stats.Files = len(files)
+
+	parallel := prefetch.Parallel
+	if parallel <= 0 {
+		parallel = DefaultPrefetchParallel
+	}
+
+	var mu sync.Mutex
+	lastProgress := time.Now()
+	record := func(n int64, hit bool, cached bool, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if finished {
+			return
+		}
+		stats.BytesRead += n
+		if err != nil {
+			stats.Errors++
+		} else {
+			stats.FilesRead++
+		}
+		if cached {
+			if hit {
+				stats.CacheHits++
+			} else {
+				stats.CacheMisses++
+			}
+		}
+		if progress != nil && time.Since(lastProgress) >= prefetchProgressInterval {
+			lastProgress = time.Now()
+			progress(stats)
+		}
+	}
+
+	paths := make(chan string)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, 1<<20)
+			for rel := range paths {
+				hit := cacheDir != "" && isCached(filepath.Join(cacheDir, rel), filepath.Join(mountPoint, rel))
+				n, err := readFile(filepath.Join(mountPoint, rel), buf, expired)
+				if err != nil {
+					log.Printf("unable to prefetch %s %s\n", rel, err)
+				}
+				record(n, hit, cacheDir != "", err)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	// Hand out the files until the deadline
+	var timer <-chan time.Time
+	if !deadline.IsZero() {
+		timer = time.After(time.Until(deadline))
+	}
+	timedOut := false
+feed:
+	for _, rel := range files {
+		select {
+		case paths <- rel:
+		case <-timer:
+			timedOut = true
+			break feed
+		}
+	}
+	close(paths)
+
+	// Reads hung in the fuse daemon can't be interrupted, don't wait for them past the deadline
+	if !timedOut {
+		select {
+		case <-done:
+		case <-timer:
+			timedOut = true
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if timedOut || stats.FilesRead+stats.Errors < stats.Files {
+		return finish(PrefetchTimedOut)
+	}
+	return finish(PrefetchDone)
+}
+
+// Check if the cache file of a mount file is complete
+func isCached(cacheFile string, mountFile string) bool {
+	cached, err := os.Stat(cacheFile)
+	if err != nil {
+		return false
+	}
+	info, err := os.Stat(mountFile)
+	return err == nil && cached.Size() == info.Size()
+}
+
+// Read a file to the end with buf, unless expired
+func readFile(path string, buf []byte, expired func() bool) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var total int64
+	for {
+		if expired() {
+			return total, fmt.Errorf("prefetch time budget exhausted")
+		}
+		n, err := file.Read(buf)
+		total += int64(n)
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+// Method to prefetch a mount recorded for a container and record the progress in its state
+// Used before the hook returns and by the background prefetch process
+func PrefetchMount(hookConfig Config, containerID string, mountState MountState) PrefetchStats {
+	mount := mountState.GetMount()
+	prefetch := *mount.Prefetch
+
+	timeout := time.Duration(prefetch.TimeoutSec) * time.Second
+	if prefetch.Background {
+		if timeout <= 0 {
+			timeout = DefaultBackgroundPrefetchTimeoutSec * time.Second
+		}
+	} else {
+		if timeout <= 0 {
+			timeout = DefaultPrefetchTimeoutSec * time.Second
+		}
+		// The hook waits for the prefetch, it must end before the hook deadline
+		timeout = limitToDeadline(hookConfig, timeout)
+		if timeout <= 0 {
+			log.Printf("Not prefetching %s, the hook deadline is reached\n", mount.HostMountPoint)
+			stats := PrefetchStats{State: PrefetchTimedOut, StartedAt: time.Now(), FinishedAt: time.Now()}
+			if err := RecordPrefetch(hookConfig, containerID, mount.Name, stats); err != nil {
+				log.Printf("unable to record prefetch of %s %s\n", mount.Name, err)
+			}
+			return stats
+		}
+	}
+
+	log.Printf("Prefetching %s with a time budget of %s\n", mount.HostMountPoint, timeout)
+	return RunPrefetch(mount.HostMountPoint, mountState.CacheDir, prefetch, timeout, func(stats PrefetchStats) {
+		if err := RecordPrefetch(hookConfig, containerID, mount.Name, stats); err != nil {
+			log.Printf("unable to record prefetch of %s %s\n", mount.Name, err)
+		}
+	})
+}
+
+// Method to record the prefetch stats of a mount in the state of a container
+func RecordPrefetch(hookConfig Config, containerID string, mountName string, stats PrefetchStats) error {
+	return UpdateContainerState(hookConfig, containerID, func(state *ContainerState) error {
+		for i := range state.Mounts {
+			if state.Mounts[i].Mount.Name == mountName {
+				state.Mounts[i].Prefetch = &stats
+				return nil
+			}
+		}
+		return fmt.Errorf("mount %s not recorded for container %s", mountName, containerID)
+	})
+}
+
+// Method to prefetch a mount of a container in a background process
+// The process runs "<hook> prefetch", see the prefetch command, detached from the hook.
+// Its pid is recorded in the state of the mount, the teardown stops it, see StopBackgroundPrefetch
+func StartBackgroundPrefetch(hookConfig Config, containerID string, mountName string) error {
+	hook, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(hook, "prefetch", "--runtime-dir", runtimeBaseDir(hookConfig), containerID, mountName)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := runner.Start(cmd, DaemonConfig{}, ""); err != nil {
+		log.Printf("unable to start background prefetch %s\n", err)
+		return err
+	}
+	log.Printf("Prefetching mount %s in the background, pid %d\n", mountName, cmd.Process.Pid)
+
+	err = UpdateContainerState(hookConfig, containerID, func(state *ContainerState) error {
+		for i := range state.Mounts {
+			if state.Mounts[i].Mount.Name == mountName {
+				state.Mounts[i].PrefetchPid = cmd.Process.Pid
+				return nil
+			}
+		}
+		return fmt.Errorf("mount %s not recorded for container %s", mountName, containerID)
+	})
+	if err != nil {
+		// Not stopped at teardown if not recorded
+		log.Printf("unable to record background prefetch of %s %s\n", mountName, err)
+		cmd.Process.Kill()
+		cmd.Process.Wait()
+		return err
+	}
+
+	// Not waited for, the process outlives the hook
+	return cmd.Process.Release()
+}
+
+// Method to stop the background prefetch of a mount of a container, if still running
+// Called at teardown before unmounting, so the reads don't keep the mount busy
+func StopBackgroundPrefetch(containerID string, mountState MountState) {
+	pid := mountState.PrefetchPid
+	if pid <= 0 || !processExists(pid) {
+		return
+	}
+	// The pid may have been reused once the prefetch exited
+	if !isPrefetchProcess(pid, containerID, mountState.Mount.Name) {
+		return
+	}
+
+	log.Printf("Stopping background prefetch of mount %s, pid %d\n", mountState.Mount.Name, pid)
+	// Reads hung in the fuse daemon only end with SIGKILL
+	if err := syscall.Kill(pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+		log.Printf("unable to kill background prefetch %d %s\n", pid, err)
+		return
+	}
+	waitForExit([]int{pid}, time.Second)
+}
+
+// Check if pid runs the prefetch command for a mount of a container, see StartBackgroundPrefetch
+func isPrefetchProcess(pid int, containerID string, mountName string) bool {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		return false
+	}
+	// <hook> prefetch [flags] <container id> <mount name>
+	args := strings.Split(strings.TrimRight(string(data), "\x00"), "\x00")
+	n := len(args)
+	return n >= 4 && args[1] == "prefetch" && args[n-2] == containerID && args[n-1] == mountName
+}
@@ -0,0 +1,178 @@
+package internal
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Create files of the given sizes under dir
+func writeFiles(t *testing.T, dir string, files map[string]int) {
+	for name, size := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPrefetchFiles(t *testing.T) {
+	mountPoint := t.TempDir()
+	writeFiles(t, mountPoint, map[string]int{
+		"models/a.onnx":    10,
+		"models/b.onnx":    10,
+		"models/notes.txt": 1,
+		"data/c.bin":       1,
+	})
+	if err := os.WriteFile(filepath.Join(mountPoint, "prefetch.txt"), []byte("# warm up\n\ndata/*.bin\nmodels/a.onnx\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		prefetch PrefetchConfig
+		want     []string
+		wantErr  bool
+	}{
+		{name: "globs", prefetch: PrefetchConfig{Globs: []string{"models/*.onnx"}}, want: []string{"models/a.onnx", "models/b.onnx"}},
+		{name: "manifest and globs without duplicates", prefetch: PrefetchConfig{Globs: []string{"models/*.onnx"}, Manifest: "prefetch.txt"},
+			want: []string{"data/c.bin", "models/a.onnx", "models/b.onnx"}},
+		{name: "directories are skipped", prefetch: PrefetchConfig{Globs: []string{"*"}}, want: []string{"prefetch.txt"}},
+		{name: "no match", prefetch: PrefetchConfig{Globs: []string{"missing/*"}}},
+		{name: "pattern escaping the mount", prefetch: PrefetchConfig{Globs: []string{"../*"}}, wantErr: true},
+		{name: "absolute manifest", prefetch: PrefetchConfig{Manifest: "/etc/passwd"}, wantErr: true},
+		{name: "missing manifest", prefetch: PrefetchConfig{Manifest: "missing.txt"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := PrefetchFiles(mountPoint, tt.prefetch)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PrefetchFiles() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PrefetchFiles() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunPrefetch(t *testing.T) {
+	mountPoint := t.TempDir()
+	cacheDir := t.TempDir()
+	writeFiles(t, mountPoint, map[string]int{"models/a.onnx": 3 << 20, "models/b.onnx": 100, "models/c.onnx": 50})
+	// a is cached, b is partially cached
+	writeFiles(t, cacheDir, map[string]int{"models/a.onnx": 3 << 20, "models/b.onnx": 10})
+
+	var progress []PrefetchStats
+	stats := RunPrefetch(mountPoint, cacheDir, PrefetchConfig{Globs: []string{"models/*"}, Parallel: 2}, time.Minute,
+		func(stats PrefetchStats) { progress = append(progress, stats) })
+
+	want := PrefetchStats{State: PrefetchDone, Files: 3, FilesRead: 3, BytesRead: 3<<20 + 150, CacheHits: 1, CacheMisses: 2}
+	stats.StartedAt, stats.FinishedAt = time.Time{}, time.Time{}
+	if stats != want {
+		t.Errorf("RunPrefetch() = %+v, want %+v", stats, want)
+	}
+	if len(progress) == 0 || progress[len(progress)-1].State != PrefetchDone {
+		t.Errorf("last progress = %+v, want the final stats", progress)
+	}
+
+	// Without a cache directory, no cache stats
+	stats = RunPrefetch(mountPoint, "", PrefetchConfig{Globs: []string{"models/b.onnx"}}, 0, nil)
+	if stats.State != PrefetchDone || stats.CacheHits != 0 || stats.CacheMisses != 0 {
+		t.Errorf("RunPrefetch() without cache = %+v", stats)
+	}
+
+	stats = RunPrefetch(mountPoint, "", PrefetchConfig{Globs: []string{"/*"}}, 0, nil)
+	if stats.State != PrefetchFailed {
+		t.Errorf("RunPrefetch() with an invalid pattern = %+v, want %s", stats, PrefetchFailed)
+	}
+}
+
+func TestPrefetchMountRecordsStats(t *testing.T) {
+	hookConfig := Config{RuntimeDir: t.TempDir()}
+	mountPoint := t.TempDir()
+	writeFiles(t, mountPoint, map[string]int{"model.bin": 10})
+
+	mountState := MountState{
+		Mount: Mount{Name: "models", HostMountPoint: mountPoint, Prefetch: &PrefetchConfig{Globs: []string{"*.bin"}}},
+		Owner: "c1",
+	}
+	err := UpdateContainerState(hookConfig, "c1", func(state *ContainerState) error {
+		state.SetMount(MountState{Mount: Mount{Name: "models"}, Owner: "c1"})
+		// Replaced, not appended
+		state.SetMount(mountState)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	PrefetchMount(hookConfig, "c1", mountState)
+
+	state, err := ReadContainerState(hookConfig, "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Mounts) != 1 {
+		t.Fatalf("recorded mounts = %+v, want 1", state.Mounts)
+	}
+	stats := state.Mounts[0].Prefetch
+	if stats == nil || stats.State != PrefetchDone || stats.FilesRead != 1 || stats.BytesRead != 10 {
+		t.Errorf("recorded prefetch = %+v, want 1 file of 10 bytes done", stats)
+	}
+
+	if err := RecordPrefetch(hookConfig, "c1", "missing", PrefetchStats{}); err == nil {
+		t.Errorf("RecordPrefetch() recorded an unknown mount")
+	}
+}
+
+func TestStopBackgroundPrefetch(t *testing.T) {
+	// A process with the command line of the prefetch command, see StartBackgroundPrefetch
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "prefetch"), []byte("while :; do sleep 0.1; done\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	start := func(args ...string) *exec.Cmd {
+		cmd := &exec.Cmd{Path: "/bin/sh", Args: args, Dir: dir}
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		// Reaped in the background, so the killed process doesn't stay a zombie
+		go cmd.Wait()
+		t.Cleanup(func() { cmd.Process.Kill() })
+		return cmd
+	}
+
+	tests := []struct {
+		name        string
+		args        []string
+		containerID string
+		wantStopped bool
+	}{
+		{name: "prefetch", args: []string{"hook", "prefetch", "c1", "models"}, containerID: "c1", wantStopped: true},
+		{name: "prefetch of another container", args: []string{"hook", "prefetch", "c2", "models"}, containerID: "c1"},
+		{name: "reused pid", args: []string{"sh", "-c", "sleep 30", "c1", "models"}, containerID: "c1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := start(tt.args...)
+			mountState := MountState{Mount: Mount{Name: "models"}, PrefetchPid: cmd.Process.Pid}
+
+			StopBackgroundPrefetch(tt.containerID, mountState)
+			if stopped := !processExists(cmd.Process.Pid); stopped != tt.wantStopped {
+				t.Errorf("StopBackgroundPrefetch() stopped = %v, want %v", stopped, tt.wantStopped)
+			}
+		})
+	}
+
+	// Nothing recorded
+	StopBackgroundPrefetch("c1", MountState{Mount: Mount{Name: "models"}})
+}
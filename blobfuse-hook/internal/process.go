@@ -1,88 +1,185 @@
 package internal
 
 import (
+	"context"
+	"errors"
 	"os"
 	"os/exec"
-	"strings"
+	"strconv"
+	"syscall"
+	"time"
 
-	sysmount "github.com/moby/sys/mount"
+	"github.com/kata-hooks/hookutil"
+	"golang.org/x/sys/unix"
 )
 
-// Execute process using syscall.Exec
-// The blobfuse program path will be in hookConfig.ProgramPath
-// The host mount point will be in hookConfig.HostMountPoint
-// The container mount point will be in hookConfig.ContainerMountPoint
-// Also use the environment variables from the containerConfig.Process.Env to execute the process
+// Time to wait for the output of blobfuse2 once it exits
+const outputWaitDelay = time.Second
 
-func ExecuteBlobFuseProcess(env []string, hookConfig Config) error {
+// Create a struct to hold how the fuse daemon of a mount is started
+// Recorded in the container state to restart the daemon, see Supervisor.
+// Env may hold secrets, it is recorded redacted, see RestartEnv
+type DaemonLaunch struct {
+	ProgramPath string   `json:"program_path"`
+	Args        []string `json:"args"`
+	Env         []string `json:"env"`
+	ConfigFile  string   `json:"config_file"`
+
+	// Network namespace of the daemon, see DaemonNetNS
+	NetNS string `json:"netns,omitempty"`
+}
+
+// Method to build the launch of the fuse daemon of a mount
+// The fuse program path will be in hookConfig.ProgramPath, its arguments are built by the driver
+// The blobfuse2 config file is configFile, see RenderBlobFuseConfig
+// The process env is env, see BlobFuseProcessEnv
+func NewDaemonLaunch(env []string, hookConfig Config, values TemplateValues, configFile string) (DaemonLaunch, error) {
+	// Build the arguments for the process with the driver
+	// The arguments will be the host mount point and other required
+	arguments, err := driverArgs(hookConfig, values, configFile)
+	if err != nil {
+		log.Printf("unable to get %s arguments %s\n", hookConfig.Driver, err)
+		return DaemonLaunch{}, err
+	}
+	arguments = append(arguments, hookConfig.BlobFuseFlags...)
+
+	return DaemonLaunch{
+		ProgramPath: hookConfig.ProgramPath,
+		Args:        arguments,
+		Env:         env,
+		ConfigFile:  configFile,
+	}, nil
+}
+
+// Method to start the fuse daemon of a mount
+// Returns once the fuse mount is ready, see WaitForFuseMount
+func StartDaemon(hookConfig Config, mount Mount, launch DaemonLaunch) error {
 	// Create the host mount point directory path
-	err := os.MkdirAll(hookConfig.HostMountPoint, 0755)
+	err := os.MkdirAll(mount.HostMountPoint, 0755)
 	if err != nil {
 		log.Printf("unable to create host mount point directory %s\n", err)
 		return err
 	}
 
-	log.Printf("Executing program %s\n", hookConfig.ProgramPath)
-
-	// Build the arguments for the process
-	// The arguments will be the host mount point and other required
-	arguments := []string{
-		"mount",
-		hookConfig.HostMountPoint,
-		"--config-file=/etc/blobfuseconfig.yaml"}
+	log.Printf("Executing program %s with env %v\n", launch.ProgramPath, RedactEnv(launch.Env))
 
 	// Create a new command with the program path and arguments
-	cmd := exec.Command(hookConfig.ProgramPath, arguments...)
+	// The program is killed with its process group if it doesn't return in time
+	cmd := commandWithTimeout(hookConfig, ActionMount, launch.ProgramPath, launch.Args...)
+	defer cmd.done()
 
 	// Set the environment variables for the command
-	cmd.Env = env
+	cmd.Env = launch.Env
 
-	// Run the command
-	err = cmd.Run()
+	// Drop the privileges of the daemon and limit its resources
+	if err := prepareDaemonDirs(hookConfig, mount); err != nil {
+		return err
+	}
+	if err := setDaemonCredentials(cmd.Cmd, hookConfig.Daemon); err != nil {
+		log.Printf("unable to set daemon credentials %s\n", err)
+		return err
+	}
+	cgroupDir, cgroupFile, err := setDaemonCgroup(cmd.Cmd, hookConfig.Daemon, mount)
 	if err != nil {
-		log.Printf("unable to execute process %s\n", err)
 		return err
 	}
+	if cgroupFile != nil {
+		defer cgroupFile.Close()
+	}
+
+	// Stream the output into the hook log
+	entry := log.WithField("blobfuse", mount.Name)
+	stdout := newLineLogger(entry, "stdout")
+	stderr := newLineLogger(entry, "stderr")
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	// The daemon may keep the output pipes open after blobfuse2 returns
+	cmd.WaitDelay = outputWaitDelay
+
+	// Run the command
+	err = runner.Start(cmd.Cmd, hookConfig.Daemon, launch.NetNS)
+	if err == nil {
+		err = cmd.error(cmd.Wait())
+	}
+	stdout.Flush()
+	stderr.Flush()
+	logExitStatus(entry, cmd.ProcessState)
+	if err != nil && !errors.Is(err, exec.ErrWaitDelay) {
+		log.Printf("unable to execute process %s\n", err)
+		if errors.Is(err, context.DeadlineExceeded) {
+			// A daemon forked before the timeout may have left the process group
+			KillFuseDaemon(launch.ProgramPath, mount.HostMountPoint)
+		}
+		RemoveDaemonCgroup(cgroupDir)
+		return withLogTail(err, launch.ConfigFile)
+	}
 
+	// blobfuse2 daemonizes, wait for the fuse mount before bind mounting it
+	err = WaitForFuseMount(hookConfig, mount.HostMountPoint)
+	if err != nil {
+		RemoveDaemonCgroup(cgroupDir)
+		return withLogTail(err, launch.ConfigFile)
+	}
 	return nil
 }
 
-// Bind mount src to dst
-// The src will be the host mount point and dst will be the container mount point
+// Bind mount src to the container mount point in the container rootfs
+// The src will be the host mount point. The container mount point is resolved in the
+// rootfs, so neither ".." nor the symlinks of the container image can move the bind
+// outside of it, see hookutil.OpenInRoot. Returns the bind target on the host
+// If readOnly is set, the bind mount is remounted read-only
+func BindMount(srcMountPoint string, rootfsPath string, containerMountPoint string, readOnly bool) (string, error) {
+	rootFd, err := hookutil.OpenRoot(rootfsPath)
+	if err != nil {
+		log.Printf("unable to open the container rootfs %s\n", err)
+		return "", err
+	}
+	defer unix.Close(rootFd)
 
-func BindMount(srcMountPoint string, dstMountPoint string) error {
+	// Create the dst mount point directory path in the rootfs
+	dstFd, err := hookutil.OpenInRoot(rootFd, containerMountPoint)
+	if err != nil {
+		log.Printf("create container mount point directory returned err: %s\n", err)
+		return "", err
+	}
+	defer unix.Close(dstFd)
+	dstMountPoint, err := os.Readlink(procFdPath(dstFd))
+	if err != nil {
+		return "", err
+	}
 
 	log.Printf("Bind mounting host mount point %s to container mount point %s\n",
 		srcMountPoint, dstMountPoint)
 
-	// Create the dst mount point directory path
-	err := os.MkdirAll(dstMountPoint, 0755)
+	// Bind mount the host mount point to container mount point, through the resolved directory
+	err = mounter.Mount(srcMountPoint, procFdPath(dstFd), "none", "bind")
 	if err != nil {
-		log.Printf("create container mount point directory returned err: %s\n", err)
-		return err
+		log.Printf("bind mount srcMountPoint (%s) dstMountPoint (%s) returned err: %s\n", srcMountPoint, dstMountPoint, err)
+		return "", err
+	}
+	if !readOnly {
+		return dstMountPoint, nil
 	}
 
-	// Bind mount the host mount point to container mount point
-	err = sysmount.Mount(srcMountPoint, dstMountPoint, "none", "bind,rw")
+	// dstFd is below the bind mount, remount the bind mount through a new fd
+	bindFd, err := hookutil.OpenInRoot(rootFd, containerMountPoint)
 	if err != nil {
-		log.Printf("bind mount srcMountPoint (%s) dstMountPoint (%s) returned err: %s\n", srcMountPoint, dstMountPoint, err)
-		return err
+		log.Printf("unable to open the bind mount %s %s\n", dstMountPoint, err)
+		mounter.Unmount(dstMountPoint, syscall.MNT_DETACH)
+		return "", err
+	}
+	defer unix.Close(bindFd)
+	err = mounter.Mount("", procFdPath(bindFd), "none", "remount,bind,ro")
+	if err != nil {
+		log.Printf("unable to remount %s read-only %s\n", dstMountPoint, err)
+		mounter.Unmount(procFdPath(bindFd), syscall.MNT_DETACH)
+		return "", err
 	}
 
-	return nil
+	return dstMountPoint, nil
 }
 
-// Get CONTAINER_MOUNT_POINT value from containerConfig.Process.Env
-
-func GetContainerMountPoint(env []string) string {
-
-	for _, envVar := range env {
-		if envVar == "CONTAINER_MOUNT_POINT" {
-			// Split the envVar on "="
-			// The second part will be the value of CONTAINER_MOUNT_POINT
-			containerMountPoint := strings.Split(envVar, "=")[1]
-			return containerMountPoint
-		}
-	}
-	return ""
+// Return the path of the open file fd of the hook
+func procFdPath(fd int) string {
+	return "/proc/self/fd/" + strconv.Itoa(fd)
 }
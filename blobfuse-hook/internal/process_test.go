@@ -0,0 +1,96 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestBindMountReadOnly(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("bind mounting requires root")
+	}
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	rootfs := filepath.Join(dir, "rootfs")
+	for _, d := range []string{src, rootfs} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dst, err := BindMount(src, rootfs, "/data", true)
+	if err != nil {
+		t.Fatalf("BindMount() error = %v", err)
+	}
+	defer syscall.Unmount(dst, syscall.MNT_DETACH)
+	if dst != filepath.Join(rootfs, "data") {
+		t.Errorf("BindMount() = %s, want %s", dst, filepath.Join(rootfs, "data"))
+	}
+
+	err = os.WriteFile(filepath.Join(dst, "file"), []byte("data"), 0644)
+	if !isReadOnlyErr(err) {
+		t.Errorf("write to read-only bind mount error = %v, want EROFS", err)
+	}
+
+	// Only the bind mount is read-only
+	if err := os.WriteFile(filepath.Join(rootfs, "file"), []byte("data"), 0644); err != nil {
+		t.Errorf("write to the rootfs error = %v", err)
+	}
+}
+
+func TestBindMountInRootfs(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("bind mounting requires root")
+	}
+
+	tests := []struct {
+		name                string
+		link                string
+		containerMountPoint string
+		want                string
+	}{
+		{name: "mountpoint", containerMountPoint: "/data/in", want: "data/in"},
+		{name: "absolute symlink", link: "/outside", containerMountPoint: "/link", want: "outside"},
+		{name: "relative symlink", link: "../outside", containerMountPoint: "/link/in", want: "outside/in"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			src := filepath.Join(dir, "src")
+			rootfs := filepath.Join(dir, "rootfs")
+			// The symlinks resolve to outside of the rootfs on the host
+			for _, d := range []string{src, filepath.Join(rootfs, "outside"), filepath.Join(dir, "outside")} {
+				if err := os.MkdirAll(d, 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.link != "" {
+				if err := os.Symlink(tt.link, filepath.Join(rootfs, "link")); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			dst, err := BindMount(src, rootfs, tt.containerMountPoint, false)
+			if err != nil {
+				t.Fatalf("BindMount() error = %v", err)
+			}
+			defer syscall.Unmount(dst, syscall.MNT_DETACH)
+
+			if want := filepath.Join(rootfs, tt.want); dst != want {
+				t.Errorf("BindMount() = %s, want %s", dst, want)
+			}
+			if entries, _ := os.ReadDir(filepath.Join(dir, "outside")); len(entries) != 0 {
+				t.Errorf("bind mount escaped the rootfs, %v", entries)
+			}
+		})
+	}
+}
+
+func isReadOnlyErr(err error) bool {
+	pathErr, ok := err.(*os.PathError)
+	return ok && pathErr.Err == syscall.EROFS
+}
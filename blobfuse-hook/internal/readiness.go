@@ -0,0 +1,174 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Defaults of the FUSE mount readiness check
+const (
+	DefaultMountTimeoutSec = 30
+	DefaultMountPollMs     = 50
+	maxMountPollInterval   = time.Second
+)
+
+// Method to wait until a fuse filesystem is mounted at mountPoint and can be stat'ed
+// blobfuse2 daemonizes, so the mount may appear after the process returns.
+// Polls with an exponential backoff from hookConfig.MountPollMs up to 1s.
+// On timeout, or at the hook deadline, the daemon serving mountPoint is killed and an error returned
+func WaitForFuseMount(hookConfig Config, mountPoint string) error {
+	timeout := time.Duration(hookConfig.MountTimeoutSec) * time.Second
+	if timeout <= 0 {
+		timeout = DefaultMountTimeoutSec * time.Second
+	}
+	timeout = limitToDeadline(hookConfig, timeout)
+	interval := time.Duration(hookConfig.MountPollMs) * time.Millisecond
+	if interval <= 0 {
+		interval = DefaultMountPollMs * time.Millisecond
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		mounted, err := IsFuseMounted(mountPoint)
+		if err != nil {
+			log.Printf("unable to read mountinfo %s\n", err)
+			return err
+		}
+		if mounted {
+			if _, err = os.Stat(mountPoint); err == nil {
+				log.Printf("fuse mount at %s is ready\n", mountPoint)
+				return nil
+			}
+			log.Printf("fuse mount at %s not ready %s\n", mountPoint, err)
+		}
+
+		if time.Now().Add(interval).After(deadline) {
+			break
+		}
+		time.Sleep(interval)
+		interval *= 2
+		if interval > maxMountPollInterval {
+			interval = maxMountPollInterval
+		}
+	}
+
+	log.Printf("fuse mount at %s not ready after %s, killing %s\n", mountPoint, timeout, hookConfig.ProgramPath)
+	KillFuseDaemon(hookConfig.ProgramPath, mountPoint)
+	return fmt.Errorf("fuse mount at %s not ready after %s: %w", mountPoint, timeout.Round(time.Millisecond), context.DeadlineExceeded)
+}
+
+// Method to check if a fuse filesystem is mounted at mountPoint, see Mounter
+func IsFuseMounted(mountPoint string) (bool, error) {
+	return mounter.IsFuseMounted(mountPoint)
+}
+
+// Check if the mountinfo read from r has a fuse mount at mountPoint
+// See proc(5) for the format, eg.
+// 36 35 0:52 / /blobdata rw,nosuid,nodev shared:1 - fuse blobfuse2 rw,user_id=0,group_id=0
+func isFuseMountedIn(r io.Reader, mountPoint string) (bool, error) {
+	mountPoint = filepath.Clean(mountPoint)
+	if resolved, err := filepath.EvalSymlinks(mountPoint); err == nil {
+		mountPoint = resolved
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || unescapeMountInfo(fields[4]) != mountPoint {
+			continue
+		}
+		for i, field := range fields {
+			if field == "-" && i+1 < len(fields) {
+				fsType := fields[i+1]
+				if fsType == "fuse" || fsType == "fuseblk" || strings.HasPrefix(fsType, "fuse.") {
+					return true, nil
+				}
+				break
+			}
+		}
+	}
+	return false, scanner.Err()
+}
+
+// Decode the octal escapes of mountinfo paths, eg. \040 for a space
+func unescapeMountInfo(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// Method to kill the daemons of program serving mountPoint and lazily unmount mountPoint
+func KillFuseDaemon(programPath string, mountPoint string) {
+	for _, pid := range FindFuseDaemons(programPath, mountPoint) {
+		log.Printf("Killing %s pid %d\n", programPath, pid)
+		if err := syscall.Kill(pid, syscall.SIGKILL); err != nil {
+			log.Printf("unable to kill pid %d %s\n", pid, err)
+		}
+	}
+
+	// A dead daemon leaves a disconnected mount behind
+	if err := mounter.Unmount(mountPoint, syscall.MNT_DETACH); err != nil && err != syscall.EINVAL && err != syscall.ENOENT {
+		log.Printf("unable to unmount %s %s\n", mountPoint, err)
+	}
+}
+
+// Method to find the pids of the processes running program with mountPoint as an argument
+// An empty programPath matches any program
+func FindFuseDaemons(programPath string, mountPoint string) []int {
+	procDirs, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		log.Printf("unable to read /proc %s\n", err)
+		return nil
+	}
+
+	var pids []int
+	for _, procDir := range procDirs {
+		pid, err := strconv.Atoi(procDir.Name())
+		if err != nil || pid == os.Getpid() {
+			continue
+		}
+		cmdline, err := ioutil.ReadFile(filepath.Join("/proc", procDir.Name(), "cmdline"))
+		if err != nil {
+			continue
+		}
+		args := strings.Split(strings.TrimRight(string(cmdline), "\x00"), "\x00")
+		if isFuseDaemon(args, programPath, mountPoint) {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
+
+// Check if args run program with mountPoint as an argument
+func isFuseDaemon(args []string, programPath string, mountPoint string) bool {
+	if len(args) == 0 || (programPath != "" && filepath.Base(args[0]) != filepath.Base(programPath)) {
+		return false
+	}
+	for _, arg := range args[1:] {
+		if filepath.Clean(arg) == filepath.Clean(mountPoint) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIsFuseMountedIn(t *testing.T) {
+	mountInfo := `22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw
+36 22 0:52 / /blobdata/c1/default rw,nosuid,nodev,relatime shared:2 - fuse blobfuse2 rw,user_id=0,group_id=0
+37 22 0:53 / /blob\040data rw,nosuid,nodev,relatime shared:3 - fuse.rclone remote: rw,user_id=0,group_id=0
+38 22 0:54 / /tmp/cache rw,nosuid,nodev shared:4 - tmpfs tmpfs rw
+`
+
+	tests := []struct {
+		mountPoint string
+		want       bool
+	}{
+		{mountPoint: "/blobdata/c1/default", want: true},
+		{mountPoint: "/blobdata/c1/default/", want: true},
+		{mountPoint: "/blob data", want: true},
+		{mountPoint: "/tmp/cache", want: false},
+		{mountPoint: "/blobdata", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mountPoint, func(t *testing.T) {
+			got, err := isFuseMountedIn(strings.NewReader(mountInfo), tt.mountPoint)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("isFuseMountedIn() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsFuseDaemon(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		anyProgram bool
+		want       bool
+	}{
+		{name: "daemon of the mount", args: []string{"/usr/bin/blobfuse2", "mount", "/blobdata/c1/default", "--config-file=x"}, want: true},
+		{name: "daemon of another mount", args: []string{"blobfuse2", "mount", "/blobdata/c2/default"}, want: false},
+		{name: "other program", args: []string{"/bin/ls", "/blobdata/c1/default"}, want: false},
+		{name: "no args", want: false},
+		{name: "any program", args: []string{"/usr/bin/s3fs", "bucket", "/blobdata/c1/default"}, anyProgram: true, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			programPath := "/usr/bin/blobfuse2"
+			if tt.anyProgram {
+				programPath = ""
+			}
+			if got := isFuseDaemon(tt.args, programPath, "/blobdata/c1/default"); got != tt.want {
+				t.Errorf("isFuseDaemon() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWaitForFuseMountTimeout(t *testing.T) {
+	hookConfig := Config{ProgramPath: "/nonexistent/blobfuse2", MountTimeoutSec: 1, MountPollMs: 10}
+	if err := WaitForFuseMount(hookConfig, t.TempDir()); err == nil {
+		t.Errorf("WaitForFuseMount() succeeded without a fuse mount")
+	}
+}
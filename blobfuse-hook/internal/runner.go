@@ -0,0 +1,42 @@
+package internal
+
+import (
+	"os/exec"
+)
+
+// Create an interface to run the external programs of the hook, the fuse daemons and fusermount
+// The tests replace it to run without privileges, see SetRunner
+type Runner interface {
+	// Look up program in PATH, see exec.LookPath
+	LookPath(program string) (string, error)
+
+	// Start cmd with the nice level and I/O priority of daemon, in the network namespace
+	// netNS if set. See startDaemonProcess
+	Start(cmd *exec.Cmd, daemon DaemonConfig, netNS string) error
+
+	// Run cmd and return its combined stdout and stderr
+	CombinedOutput(cmd *exec.Cmd) ([]byte, error)
+}
+
+// Runner of the hook
+var runner Runner = ExecRunner{}
+
+// Set the runner of the hook
+func SetRunner(r Runner) {
+	runner = r
+}
+
+// Runner executing the programs on the host
+type ExecRunner struct{}
+
+func (ExecRunner) LookPath(program string) (string, error) {
+	return exec.LookPath(program)
+}
+
+func (ExecRunner) Start(cmd *exec.Cmd, daemon DaemonConfig, netNS string) error {
+	return startDaemonProcess(cmd, daemon, netNS)
+}
+
+func (ExecRunner) CombinedOutput(cmd *exec.Cmd) ([]byte, error) {
+	return cmd.CombinedOutput()
+}
@@ -0,0 +1,192 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Host mount scopes
+// container: every container gets its own host mountpoints (default)
+// pod: the containers of a pod share identical mounts
+// node: all the containers share identical mounts
+const (
+	ScopeContainer = "container"
+	ScopePod       = "pod"
+	ScopeNode      = "node"
+)
+
+// Annotations holding the pod UID, in order of preference
+var podUIDAnnotations = []string{
+	"io.kubernetes.pod.uid",
+	"io.kubernetes.cri.sandbox-uid",
+	"io.kubernetes.cri.sandbox-id",
+	"io.kubernetes.cri-o.SandboxID",
+}
+
+// Name of the directory holding the shared mount references in the runtime directory
+const sharedMountsDirName = "shared"
+
+// Method to get the owner of the mounts of a container
+// The owner names the host mountpoint and runtime directories of the mounts:
+// the container ID, "pod-<pod uid>" or "node" depending on hookConfig.HostMountScope
+func MountOwner(hookConfig Config, containerID string, annotations map[string]string) (string, error) {
+	switch hookConfig.HostMountScope {
+	case "", ScopeContainer:
+		return containerID, nil
+	case ScopePod:
+		for _, annotation := range podUIDAnnotations {
+			if podUID, ok := annotations[annotation]; ok && podUID != "" {
+				owner := "pod-" + podUID
+				if err := checkContainerID(owner); err != nil {
+					return "", err
+				}
+				return owner, nil
+			}
+		}
+		log.Printf("no pod UID annotation, using container scope\n")
+		return containerID, nil
+	case ScopeNode:
+		return ScopeNode, nil
+	default:
+		return "", fmt.Errorf("invalid host mount scope %q", hookConfig.HostMountScope)
+	}
+}
+
+// Check if the mounts of owner may be shared by several containers
+func IsSharedOwner(owner string, containerID string) bool {
+	return owner != containerID
+}
+
+// Method to compute the hash identifying a mount spec
+// Mounts sharing a host mountpoint must have the same spec hash
+func MountSpecHash(hookConfig Config, values TemplateValues) string {
+	// The rendered config belongs to the first container, ignore the container
+	values.ContainerID = ""
+
+	data, _ := json.Marshal(struct {
+		Driver           string
+		DriverArgs       []string
+		ProgramPath      string
+		ConfigTemplate   string
+		ConfigFile       string
+		NetworkNamespace string
+		Values           TemplateValues
+	}{hookConfig.Driver, hookConfig.DriverArgs, hookConfig.ProgramPath, hookConfig.ConfigTemplate, hookConfig.ConfigFile,
+		hookConfig.Daemon.NetworkNamespace, values})
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Create a struct to hold the references to a shared mount
+type sharedMount struct {
+	HostMountPoint string   `json:"host_mountpoint"`
+	SpecHash       string   `json:"spec_hash"`
+	Users          []string `json:"users"`
+}
+
+// Method to take a reference to the shared mount at mount.HostMountPoint for containerID
+// The first user mounts it by calling mountFn. Later users with the same spec hash
+// reuse the mount, users with a different spec hash are refused
+func AcquireSharedMount(hookConfig Config, mount Mount, specHash string, containerID string, mountFn func() error) error {
+	return withSharedMounts(hookConfig, mount, func(shared *sharedMount) error {
+		if len(shared.Users) != 0 {
+			if shared.SpecHash != specHash {
+				return fmt.Errorf("host mountpoint %s is in use by a different mount spec", mount.HostMountPoint)
+			}
+			log.Printf("Sharing mount %s with %v\n", mount.HostMountPoint, shared.Users)
+		} else {
+			if err := mountFn(); err != nil {
+				return err
+			}
+			shared.HostMountPoint = mount.HostMountPoint
+			shared.SpecHash = specHash
+		}
+
+		for _, user := range shared.Users {
+			if user == containerID {
+				return nil
+			}
+		}
+		shared.Users = append(shared.Users, containerID)
+		return nil
+	})
+}
+
+// Method to drop the reference of containerID to the shared mount at mount.HostMountPoint
+// The last user tears it down by calling unmountFn, if not nil
+func ReleaseSharedMount(hookConfig Config, mount Mount, containerID string, unmountFn func() error) error {
+	return withSharedMounts(hookConfig, mount, func(shared *sharedMount) error {
+		users := shared.Users[:0]
+		for _, user := range shared.Users {
+			if user != containerID {
+				users = append(users, user)
+			}
+		}
+		shared.Users = users
+
+		if len(shared.Users) != 0 {
+			log.Printf("Mount %s still used by %v\n", mount.HostMountPoint, shared.Users)
+			return nil
+		}
+		if unmountFn != nil {
+			return unmountFn()
+		}
+		return nil
+	})
+}
+
+// Run fn on the references of the shared mount at mount.HostMountPoint
+// The references are locked while fn runs and saved if fn succeeds
+func withSharedMounts(hookConfig Config, mount Mount, fn func(shared *sharedMount) error) error {
+	sharedDir := filepath.Join(runtimeBaseDir(hookConfig), sharedMountsDirName)
+	if err := mkdirRuntimeDir(hookConfig, sharedDir); err != nil {
+		log.Printf("unable to create shared mounts directory %s\n", err)
+		return err
+	}
+
+	// Serialize the hooks of all the containers
+	lockFile, err := os.OpenFile(filepath.Join(sharedDir, ".lock"), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	defer lockFile.Close()
+	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX); err != nil {
+		log.Printf("unable to lock shared mounts %s\n", err)
+		return err
+	}
+	defer syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
+
+	sum := sha256.Sum256([]byte(mount.HostMountPoint))
+	refFile := filepath.Join(sharedDir, hex.EncodeToString(sum[:8])+".json")
+
+	var shared sharedMount
+	if data, err := os.ReadFile(refFile); err == nil {
+		if err := json.Unmarshal(data, &shared); err != nil {
+			log.Printf("ignoring unparsable shared mount references %s\n", err)
+			shared = sharedMount{}
+		}
+	}
+
+	if err := fn(&shared); err != nil {
+		return err
+	}
+
+	if len(shared.Users) == 0 {
+		if err := os.Remove(refFile); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	data, err := json.Marshal(shared)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(refFile, data, 0600)
+}
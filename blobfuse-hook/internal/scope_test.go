@@ -0,0 +1,82 @@
+package internal
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMountOwner(t *testing.T) {
+	annotations := map[string]string{"io.kubernetes.pod.uid": "1234"}
+
+	tests := []struct {
+		name        string
+		scope       string
+		annotations map[string]string
+		want        string
+		wantErr     bool
+	}{
+		{name: "default scope", want: "c1"},
+		{name: "container scope", scope: ScopeContainer, annotations: annotations, want: "c1"},
+		{name: "pod scope", scope: ScopePod, annotations: annotations, want: "pod-1234"},
+		{name: "pod scope without pod uid", scope: ScopePod, want: "c1"},
+		{name: "node scope", scope: ScopeNode, want: ScopeNode},
+		{name: "invalid scope", scope: "cluster", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MountOwner(Config{HostMountScope: tt.scope}, "c1", tt.annotations)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MountOwner() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("MountOwner() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSharedMount(t *testing.T) {
+	hookConfig := Config{RuntimeDir: t.TempDir()}
+	mount := Mount{Name: "input", HostMountPoint: "/blobdata/pod-1234/input", Owner: "pod-1234"}
+
+	mounted, unmounted := 0, 0
+	mountFn := func() error { mounted++; return nil }
+	unmountFn := func() error { unmounted++; return nil }
+
+	if err := AcquireSharedMount(hookConfig, mount, "hash", "c1", mountFn); err != nil {
+		t.Fatal(err)
+	}
+	if err := AcquireSharedMount(hookConfig, mount, "hash", "c2", mountFn); err != nil {
+		t.Fatal(err)
+	}
+	if mounted != 1 {
+		t.Errorf("mounted %d times, want 1", mounted)
+	}
+
+	if err := AcquireSharedMount(hookConfig, mount, "otherhash", "c3", mountFn); err == nil {
+		t.Errorf("AcquireSharedMount() accepted a different mount spec")
+	}
+
+	if err := ReleaseSharedMount(hookConfig, mount, "c1", unmountFn); err != nil {
+		t.Fatal(err)
+	}
+	if unmounted != 0 {
+		t.Errorf("unmounted while still in use")
+	}
+	if err := ReleaseSharedMount(hookConfig, mount, "c2", unmountFn); err != nil {
+		t.Fatal(err)
+	}
+	if unmounted != 1 {
+		t.Errorf("unmounted %d times, want 1", unmounted)
+	}
+
+	// A failed mount takes no reference
+	failFn := func() error { return errors.New("mount failed") }
+	if err := AcquireSharedMount(hookConfig, mount, "hash", "c4", failFn); err == nil {
+		t.Errorf("AcquireSharedMount() ignored the mount failure")
+	}
+	if err := AcquireSharedMount(hookConfig, mount, "otherhash", "c5", mountFn); err != nil {
+		t.Errorf("AcquireSharedMount() error = %v after a failed mount", err)
+	}
+}
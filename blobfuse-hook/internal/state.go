@@ -0,0 +1,216 @@
+package internal
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Name of the file recording the mounts of a container in its runtime directory
+const stateFileName = "state.json"
+
+// Name of the lock file serializing the updates of the state of a container
+const stateLockFileName = ".state.lock"
+
+// Create a struct to hold what was mounted for a container
+// Recorded at mount time, read at teardown
+type ContainerState struct {
+	ContainerID string `json:"container_id"`
+
+	// Pid of the container process, to enter its mount namespace
+	Pid int `json:"pid,omitempty"`
+
+	Mounts []MountState `json:"mounts"`
+}
+
+// Create a struct to hold what was mounted for a mount of a container
+type MountState struct {
+	Mount Mount `json:"mount"`
+
+	// Owner of the mount, see MountOwner
+	Owner string `json:"owner"`
+
+	// Bind mount target in the container rootfs
+	BindTarget string `json:"bind_target"`
+
+	// Container rootfs the container mountpoint is resolved in, see BindMount
+	Rootfs string `json:"rootfs,omitempty"`
+
+	// Program serving the fuse mount
+	ProgramPath string `json:"program_path"`
+
+	// How the daemon was started, to restart it
+	// Its env is recorded redacted, see RestartEnv
+	Launch *DaemonLaunch `json:"launch,omitempty"`
+
+	// Bundle of the container that mounted it, its env is forwarded again on restart
+	Bundle string `json:"bundle,omitempty"`
+
+	// cgroup of the daemon, see DaemonConfig
+	Cgroup string `json:"cgroup,omitempty"`
+
+	// Cache directory of the daemon, if it caches files on disk
+	CacheDir string `json:"cache_dir,omitempty"`
+
+	// Progress of the prefetch of the mount, see PrefetchConfig
+	Prefetch *PrefetchStats `json:"prefetch,omitempty"`
+
+	// Pid of the background prefetch process, see StartBackgroundPrefetch
+	PrefetchPid int `json:"prefetch_pid,omitempty"`
+
+	// Whether the fuse mount is shared with other containers, see AcquireSharedMount
+	Shared bool `json:"shared,omitempty"`
+
+	// Hook config of the container, with its overrides applied, to restart the daemon
+	Config *Config `json:"config,omitempty"`
+
+	// Set by the teardown before unmounting, the supervisor leaves the mount alone
+	TearingDown bool `json:"tearing_down,omitempty"`
+}
+
+// Return the path of the state file of a container
+func stateFile(hookConfig Config, containerID string) string {
+	return filepath.Join(ContainerRuntimeDir(hookConfig, containerID), stateFileName)
+}
+
+// Method to record the mounts of a container
+func WriteContainerState(hookConfig Config, state ContainerState) error {
+	if err := checkContainerID(state.ContainerID); err != nil {
+		return err
+	}
+
+	runtimeDir := ContainerRuntimeDir(hookConfig, state.ContainerID)
+	if err := mkdirRuntimeDir(hookConfig, runtimeDir); err != nil {
+		log.Printf("unable to create runtime directory %s\n", err)
+		return err
+	}
+
+	// The daemon env may hold secrets, keep them off the disk
+	state.Mounts = append([]MountState(nil), state.Mounts...)
+	for i, mountState := range state.Mounts {
+		if mountState.Launch != nil {
+			launch := *mountState.Launch
+			launch.Env = RedactEnv(launch.Env)
+			state.Mounts[i].Launch = &launch
+		}
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Replace the state atomically, a partial state would leak mounts
+	tmpFile := stateFile(hookConfig, state.ContainerID) + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0600); err != nil {
+		log.Printf("unable to write state file %s\n", err)
+		return err
+	}
+	return os.Rename(tmpFile, stateFile(hookConfig, state.ContainerID))
+}
+
+// Method to read the mounts recorded for a container
+// Returns an empty state if nothing was recorded
+func ReadContainerState(hookConfig Config, containerID string) (ContainerState, error) {
+	state := ContainerState{ContainerID: containerID}
+	if err := checkContainerID(containerID); err != nil {
+		return state, err
+	}
+
+	data, err := os.ReadFile(stateFile(hookConfig, containerID))
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		log.Printf("unable to read state file %s\n", err)
+		return state, err
+	}
+
+	if err := json.Unmarshal(data, &state); err != nil {
+		log.Printf("unable to parse state file %s\n", err)
+		return state, err
+	}
+	return state, nil
+}
+
+// Method to update the state of a container with fn
+// The hook and the background prefetch update the state concurrently, updates are serialized
+func UpdateContainerState(hookConfig Config, containerID string, fn func(state *ContainerState) error) error {
+	if err := checkContainerID(containerID); err != nil {
+		return err
+	}
+
+	runtimeDir := ContainerRuntimeDir(hookConfig, containerID)
+	if err := mkdirRuntimeDir(hookConfig, runtimeDir); err != nil {
+		log.Printf("unable to create runtime directory %s\n", err)
+		return err
+	}
+	unlock, err := lockContainerState(hookConfig, containerID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	state, err := ReadContainerState(hookConfig, containerID)
+	if err != nil {
+		return err
+	}
+	if err := fn(&state); err != nil {
+		return err
+	}
+	return WriteContainerState(hookConfig, state)
+}
+
+// Method to lock the state of a container until unlock is called
+// Fails with a not exist error once the container is torn down, see os.IsNotExist
+func lockContainerState(hookConfig Config, containerID string) (func(), error) {
+	lockFile, err := os.OpenFile(filepath.Join(ContainerRuntimeDir(hookConfig, containerID), stateLockFileName), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX); err != nil {
+		log.Printf("unable to lock state %s\n", err)
+		lockFile.Close()
+		return nil, err
+	}
+	return func() {
+		syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
+		lockFile.Close()
+	}, nil
+}
+
+// Method to record a mount in the state of a container, replacing the mount of the same name
+func (s *ContainerState) SetMount(mountState MountState) {
+	for i := range s.Mounts {
+		if s.Mounts[i].Mount.Name == mountState.Mount.Name {
+			s.Mounts[i] = mountState
+			return
+		}
+	}
+	s.Mounts = append(s.Mounts, mountState)
+}
+
+// Method to build the env of the daemon of a recorded mount to restart it
+// The recorded env is redacted, so the env is built again from the env policy of the
+// recorded hook config and the env of the container in the recorded bundle, and the
+// secret files are read again, see BlobFuseProcessEnv
+func RestartEnv(mountState MountState, hookConfig Config) ([]string, error) {
+	var containerEnv []string
+	if mountState.Bundle != "" {
+		containerConfig, err := ReadOciConfigJson(filepath.Join(mountState.Bundle, "config.json"))
+		if err != nil {
+			log.Printf("unable to read the env of the container, not forwarding it %s\n", err)
+		} else if containerConfig.Process != nil {
+			containerEnv = containerConfig.Process.Env
+		}
+	}
+	return BlobFuseProcessEnv(containerEnv, hookConfig.BlobFuseEnv, hookConfig.Credentials)
+}
+
+// Return the mount recorded in a mount state
+func (m MountState) GetMount() Mount {
+	mount := m.Mount
+	mount.Owner = m.Owner
+	return mount
+}
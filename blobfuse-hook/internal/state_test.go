@@ -0,0 +1,78 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func TestWriteContainerStateRedactsEnv(t *testing.T) {
+	dir := t.TempDir()
+	hookConfig := Config{RuntimeDir: filepath.Join(dir, "run")}
+	launch := &DaemonLaunch{ProgramPath: "blobfuse2", Env: []string{"AZURE_STORAGE_ACCESS_KEY=secret"}}
+	state := ContainerState{ContainerID: "container", Mounts: []MountState{{Mount: Mount{Name: "default"}, Launch: launch}}}
+
+	if err := WriteContainerState(hookConfig, state); err != nil {
+		t.Fatalf("WriteContainerState() error = %v", err)
+	}
+	data, err := os.ReadFile(stateFile(hookConfig, "container"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret") {
+		t.Errorf("state file has the env value: %s", data)
+	}
+	if launch.Env[0] != "AZURE_STORAGE_ACCESS_KEY=secret" {
+		t.Errorf("WriteContainerState() modified the launch env %v", launch.Env)
+	}
+}
+
+func TestRestartEnv(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "access-key")
+	if err := os.WriteFile(keyFile, []byte("filekey\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	bundle := filepath.Join(dir, "bundle")
+	if err := os.Mkdir(bundle, 0755); err != nil {
+		t.Fatal(err)
+	}
+	containerConfig := specs.Spec{Process: &specs.Process{Env: []string{"HOOK=true", "AZURE_STORAGE_ACCOUNT=account"}}}
+	if err := WriteOciConfigJson(filepath.Join(bundle, "config.json"), containerConfig); err != nil {
+		t.Fatal(err)
+	}
+
+	hookConfig := Config{BlobFuseEnv: EnvPolicy{Host: []string{}, SecretFiles: map[string]string{"AZURE_STORAGE_ACCESS_KEY": keyFile}}}
+	tests := []struct {
+		name   string
+		bundle string
+		want   []string
+	}{
+		{
+			name:   "container env and secret files",
+			bundle: bundle,
+			want:   []string{"AZURE_STORAGE_ACCESS_KEY=filekey", "AZURE_STORAGE_ACCOUNT=account"},
+		},
+		{
+			name:   "container gone",
+			bundle: filepath.Join(dir, "missing"),
+			want:   []string{"AZURE_STORAGE_ACCESS_KEY=filekey"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := RestartEnv(MountState{Bundle: tt.bundle}, hookConfig)
+			if err != nil {
+				t.Fatalf("RestartEnv() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RestartEnv() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
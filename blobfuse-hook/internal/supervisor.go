@@ -0,0 +1,409 @@
+package internal
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// Defaults of the supervisor
+const (
+	DefaultSuperviseIntervalSec = 5
+	DefaultRestartBackoffSec    = 1
+	DefaultMaxRestartBackoffSec = 300
+	DefaultMaxRestarts          = 5
+	DefaultRestartWindowSec     = 3600
+)
+
+// Create a struct to hold the configuration of the supervisor, see Supervisor
+type SupervisorConfig struct {
+	// Interval between the health checks. Default is 5
+	IntervalSec int `json:"interval_sec,omitempty"`
+
+	// Delay before retrying a restart, doubled for each restart in the window
+	// up to max_backoff_sec. Defaults are 1 and 300
+	BackoffSec    int `json:"backoff_sec,omitempty"`
+	MaxBackoffSec int `json:"max_backoff_sec,omitempty"`
+
+	// Restarts allowed per mount in restart_window_sec, the mount is given up
+	// once exhausted. Defaults are 5 and 3600
+	MaxRestarts      int `json:"max_restarts,omitempty"`
+	RestartWindowSec int `json:"restart_window_sec,omitempty"`
+}
+
+// Create a struct to hold a fuse mount recorded in the hook state and the containers using it
+type supervisedMount struct {
+	MountState
+	binds []supervisedBind
+}
+
+// Create a struct to hold where a fuse mount is bound in a container
+type supervisedBind struct {
+	ContainerID         string
+	Pid                 int
+	BindTarget          string
+	Rootfs              string
+	ContainerMountPoint string
+}
+
+// Create a struct to hold the restarts of a mount
+type restartRecord struct {
+	restarts    []time.Time
+	nextAttempt time.Time
+	gaveUp      bool
+}
+
+// Create a struct to hold the supervisor
+// The supervisor watches the fuse daemons recorded in the hook state and restarts
+// the dead ones with their recorded launch, see DaemonLaunch
+type Supervisor struct {
+	hookConfig Config
+	config     SupervisorConfig
+
+	// Restarts by host mountpoint
+	records map[string]*restartRecord
+
+	// Replaced by the tests
+	load        func() ([]supervisedMount, error)
+	checkHealth func(supervisedMount) error
+	lock        func(supervisedMount) (supervisedMount, func(), error)
+	restart     func(supervisedMount) error
+}
+
+// Method to create a supervisor of the mounts recorded in hookConfig.RuntimeDir
+func NewSupervisor(hookConfig Config) *Supervisor {
+	config := hookConfig.Supervisor
+	if config.IntervalSec <= 0 {
+		config.IntervalSec = DefaultSuperviseIntervalSec
+	}
+	if config.BackoffSec <= 0 {
+		config.BackoffSec = DefaultRestartBackoffSec
+	}
+	if config.MaxBackoffSec <= 0 {
+		config.MaxBackoffSec = DefaultMaxRestartBackoffSec
+	}
+	if config.MaxRestarts <= 0 {
+		config.MaxRestarts = DefaultMaxRestarts
+	}
+	if config.RestartWindowSec <= 0 {
+		config.RestartWindowSec = DefaultRestartWindowSec
+	}
+
+	s := &Supervisor{
+		hookConfig: hookConfig,
+		config:     config,
+		records:    make(map[string]*restartRecord),
+	}
+	s.load = s.loadMounts
+	s.checkHealth = checkMountHealth
+	s.lock = s.lockMount
+	s.restart = s.restartMount
+	return s
+}
+
+// Method to check the mounts until stop is closed
+func (s *Supervisor) Run(stop <-chan struct{}) {
+	log.Printf("Supervising fuse daemons in %s every %ds\n", runtimeBaseDir(s.hookConfig), s.config.IntervalSec)
+
+	ticker := time.NewTicker(time.Duration(s.config.IntervalSec) * time.Second)
+	defer ticker.Stop()
+	for {
+		s.Check(time.Now())
+		select {
+		case <-stop:
+			log.Printf("Supervisor stopped\n")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Method to check the recorded mounts once and restart the unhealthy ones
+// A mount is restarted at most max_restarts times in restart_window_sec, with an
+// exponential backoff between the restarts. The states of the containers using a mount
+// are locked while it is restarted, so that their teardown waits, see lockMount
+func (s *Supervisor) Check(now time.Time) {
+	mounts, err := s.load()
+	if err != nil {
+		log.Printf("unable to load the hook state %s\n", err)
+		return
+	}
+
+	seen := make(map[string]bool)
+	for _, mount := range mounts {
+		hostMountPoint := mount.Mount.HostMountPoint
+		seen[hostMountPoint] = true
+
+		record, ok := s.records[hostMountPoint]
+		if !ok {
+			record = &restartRecord{}
+			s.records[hostMountPoint] = record
+		}
+
+		err := s.checkHealth(mount)
+		if err == nil {
+			if record.gaveUp {
+				log.Printf("%s is healthy again\n", hostMountPoint)
+				*record = restartRecord{}
+			}
+			continue
+		}
+		if record.gaveUp || now.Before(record.nextAttempt) {
+			continue
+		}
+		log.Printf("%s is unhealthy %s\n", hostMountPoint, err)
+
+		// Forget the restarts out of the window
+		window := now.Add(-time.Duration(s.config.RestartWindowSec) * time.Second)
+		for len(record.restarts) != 0 && record.restarts[0].Before(window) {
+			record.restarts = record.restarts[1:]
+		}
+		if len(record.restarts) >= s.config.MaxRestarts {
+			log.Printf("giving up %s after %d restarts in %ds\n", hostMountPoint, len(record.restarts), s.config.RestartWindowSec)
+			record.gaveUp = true
+			continue
+		}
+
+		locked, unlock, err := s.lock(mount)
+		if err != nil {
+			log.Printf("unable to lock the state of %s %s\n", hostMountPoint, err)
+			continue
+		}
+		if len(locked.binds) == 0 {
+			log.Printf("%s is torn down, not restarting it\n", hostMountPoint)
+			unlock()
+			continue
+		}
+
+		record.restarts = append(record.restarts, now)
+		record.nextAttempt = now.Add(s.backoff(len(record.restarts)))
+		err = s.restart(locked)
+		unlock()
+		if err != nil {
+			log.Printf("unable to restart %s %s, retrying after %s\n", hostMountPoint, err, record.nextAttempt.Sub(now))
+			continue
+		}
+		log.Printf("%s restarted (%d in the window)\n", hostMountPoint, len(record.restarts))
+	}
+
+	// Forget the mounts torn down
+	for hostMountPoint := range s.records {
+		if !seen[hostMountPoint] {
+			delete(s.records, hostMountPoint)
+		}
+	}
+}
+
+// Return the delay after the nth restart in the window
+func (s *Supervisor) backoff(restarts int) time.Duration {
+	backoff := time.Duration(s.config.BackoffSec) * time.Second
+	maxBackoff := time.Duration(s.config.MaxBackoffSec) * time.Second
+	for i := 1; i < restarts && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return backoff
+}
+
+// Method to load the fuse mounts recorded for all the containers
+// Mounts shared by several containers are grouped by host mountpoint.
+// Mounts recorded without a launch can't be restarted and are ignored, like the mounts
+// being torn down
+func (s *Supervisor) loadMounts() ([]supervisedMount, error) {
+	entries, err := ioutil.ReadDir(runtimeBaseDir(s.hookConfig))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	byMountPoint := make(map[string]*supervisedMount)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		state, err := ReadContainerState(s.hookConfig, entry.Name())
+		if err != nil {
+			continue
+		}
+		for _, mountState := range state.Mounts {
+			if mountState.Launch == nil || mountState.TearingDown {
+				continue
+			}
+			mount, ok := byMountPoint[mountState.Mount.HostMountPoint]
+			if !ok {
+				mount = &supervisedMount{MountState: mountState}
+				byMountPoint[mountState.Mount.HostMountPoint] = mount
+			}
+			mount.binds = append(mount.binds, supervisedBind{
+				ContainerID:         state.ContainerID,
+				Pid:                 state.Pid,
+				BindTarget:          mountState.BindTarget,
+				Rootfs:              mountState.Rootfs,
+				ContainerMountPoint: mountState.Mount.ContainerMountPoint,
+			})
+		}
+	}
+
+	mounts := make([]supervisedMount, 0, len(byMountPoint))
+	for _, mount := range byMountPoint {
+		mounts = append(mounts, *mount)
+	}
+	sort.Slice(mounts, func(i, j int) bool { return mounts[i].Mount.HostMountPoint < mounts[j].Mount.HostMountPoint })
+	return mounts, nil
+}
+
+// Method to lock the states of the containers using a mount until unlock is called
+// The mount is reloaded from the locked states. The containers torn down since it
+// was loaded, or tearing it down, are dropped from its binds
+func (s *Supervisor) lockMount(mount supervisedMount) (supervisedMount, func(), error) {
+	var unlocks []func()
+	unlock := func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
+		}
+	}
+
+	// The binds are sorted by container, the states are always locked in the same order
+	locked := supervisedMount{MountState: mount.MountState}
+	for _, bind := range mount.binds {
+		unlockState, err := lockContainerState(s.hookConfig, bind.ContainerID)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			unlock()
+			return mount, nil, err
+		}
+		unlocks = append(unlocks, unlockState)
+
+		state, err := ReadContainerState(s.hookConfig, bind.ContainerID)
+		if err != nil {
+			unlock()
+			return mount, nil, err
+		}
+		for _, mountState := range state.Mounts {
+			if mountState.Mount.HostMountPoint != mount.Mount.HostMountPoint || mountState.Launch == nil || mountState.TearingDown {
+				continue
+			}
+			if len(locked.binds) == 0 {
+				locked.MountState = mountState
+			}
+			locked.binds = append(locked.binds, bind)
+			break
+		}
+	}
+	return locked, unlock, nil
+}
+
+// Method to check that the daemon of a mount runs and its fuse mount is connected
+func checkMountHealth(mount supervisedMount) error {
+	hostMountPoint := mount.Mount.HostMountPoint
+	if len(FindFuseDaemons(mount.Launch.ProgramPath, hostMountPoint)) == 0 {
+		return fmt.Errorf("%s is not running", mount.Launch.ProgramPath)
+	}
+	mounted, err := IsFuseMounted(hostMountPoint)
+	if err != nil {
+		return err
+	}
+	if !mounted {
+		return fmt.Errorf("not mounted")
+	}
+	if _, err := os.Stat(hostMountPoint); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Method to restart the daemon of a mount and bind it again in the containers using it
+// The binds of the dead daemon are disconnected, see syscall.ENOTCONN, and are replaced.
+// The daemon is started with the hook config recorded for the container, see MountState.
+// Called with the states of the containers locked, see lockMount
+func (s *Supervisor) restartMount(mount supervisedMount) error {
+	hostMountPoint := mount.Mount.HostMountPoint
+	launch := *mount.Launch
+
+	KillFuseDaemon(launch.ProgramPath, hostMountPoint)
+	RemoveDaemonCgroup(mount.Cgroup)
+
+	// The network namespace of an exited container may be held by the others of its pod
+	if launch.NetNS != "" {
+		if _, err := os.Stat(launch.NetNS); err != nil {
+			for _, bind := range mount.binds {
+				if bind.Pid > 0 && processExists(bind.Pid) {
+					launch.NetNS = fmt.Sprintf("/proc/%d/ns/net", bind.Pid)
+					break
+				}
+			}
+		}
+	}
+
+	hookConfig := s.hookConfig
+	if mount.Config != nil {
+		hookConfig = *mount.Config
+	}
+	hookConfig.ProgramPath = launch.ProgramPath
+	env, err := RestartEnv(mount.MountState, hookConfig)
+	if err != nil {
+		log.Printf("unable to get the env of %s %s\n", hostMountPoint, err)
+		return err
+	}
+	launch.Env = env
+	if err := StartDaemon(hookConfig, mount.GetMount(), launch); err != nil {
+		return err
+	}
+
+	var firstErr error
+	for _, bind := range mount.binds {
+		if err := rebind(mount.MountState, bind); err != nil {
+			log.Printf("unable to bind %s in container %s %s\n", hostMountPoint, bind.ContainerID, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// Method to replace the bind of a restarted fuse mount in a container
+// The bind target in the container rootfs is replaced in the hook mount namespace, and
+// the container mountpoint in the container mount namespace when it is another one
+func rebind(mountState MountState, bind supervisedBind) error {
+	mount := mountState.GetMount()
+
+	// A stopped container is left to the poststop teardown
+	if bind.Pid > 0 && !processExists(bind.Pid) {
+		log.Printf("container %s is not running, not binding %s\n", bind.ContainerID, mount.HostMountPoint)
+		return nil
+	}
+
+	if bind.BindTarget != "" {
+		if err := mounter.Unmount(bind.BindTarget, syscall.MNT_DETACH); err != nil && err != syscall.EINVAL && err != syscall.ENOENT {
+			log.Printf("unable to unmount %s %s\n", bind.BindTarget, err)
+			return err
+		}
+		// States recorded without the rootfs hold the bind target only
+		rootfs, containerMountPoint := bind.Rootfs, bind.ContainerMountPoint
+		if rootfs == "" {
+			rootfs, containerMountPoint = "/", bind.BindTarget
+		}
+		if _, err := BindMount(mount.HostMountPoint, rootfs, containerMountPoint, mount.ReadOnly); err != nil {
+			return err
+		}
+	}
+
+	if bind.Pid <= 0 {
+		return nil
+	}
+	other, err := inOtherMountNamespace(bind.Pid)
+	if err != nil || !other {
+		return err
+	}
+	return RebindInContainer(bind.Pid, mount.HostMountPoint, bind.ContainerMountPoint, mount.ReadOnly)
+}
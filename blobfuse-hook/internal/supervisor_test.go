@@ -0,0 +1,266 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSupervisorCheck(t *testing.T) {
+	s := NewSupervisor(Config{Supervisor: SupervisorConfig{BackoffSec: 1, MaxBackoffSec: 4, MaxRestarts: 3, RestartWindowSec: 60}})
+
+	mount := supervisedMount{MountState: MountState{Mount: Mount{HostMountPoint: "/blobdata/c1/input"}, Launch: &DaemonLaunch{}}, binds: []supervisedBind{{ContainerID: "c1"}}}
+	healthy := false
+	restarts := 0
+	s.load = func() ([]supervisedMount, error) { return []supervisedMount{mount}, nil }
+	s.checkHealth = func(supervisedMount) error {
+		if healthy {
+			return nil
+		}
+		return fmt.Errorf("not mounted")
+	}
+	s.lock = func(m supervisedMount) (supervisedMount, func(), error) { return m, func() {}, nil }
+	s.restart = func(supervisedMount) error {
+		restarts++
+		return fmt.Errorf("restart failed")
+	}
+
+	start := time.Now()
+	tests := []struct {
+		name         string
+		after        time.Duration
+		healthy      bool
+		wantRestarts int
+	}{
+		{name: "first restart", after: 0, wantRestarts: 1},
+		{name: "within backoff", after: 500 * time.Millisecond, wantRestarts: 1},
+		{name: "after backoff", after: time.Second, wantRestarts: 2},
+		{name: "backoff doubled", after: 2500 * time.Millisecond, wantRestarts: 2},
+		{name: "after doubled backoff", after: 3 * time.Second, wantRestarts: 3},
+		{name: "budget exhausted", after: 10 * time.Second, wantRestarts: 3},
+		{name: "given up", after: 20 * time.Second, wantRestarts: 3},
+		{name: "healthy again", after: 21 * time.Second, healthy: true, wantRestarts: 3},
+		{name: "restarted after recovering", after: 22 * time.Second, wantRestarts: 4},
+	}
+
+	for _, tt := range tests {
+		healthy = tt.healthy
+		s.Check(start.Add(tt.after))
+		if restarts != tt.wantRestarts {
+			t.Errorf("%s: restarts = %d, want %d", tt.name, restarts, tt.wantRestarts)
+		}
+	}
+
+	// Mounts torn down once loaded are not restarted
+	s.lock = func(m supervisedMount) (supervisedMount, func(), error) { return supervisedMount{}, func() {}, nil }
+	s.Check(start.Add(40 * time.Second))
+	if restarts != 4 {
+		t.Errorf("torn down mount: restarts = %d, want 4", restarts)
+	}
+
+	// Mounts torn down are forgotten
+	s.load = func() ([]supervisedMount, error) { return nil, nil }
+	s.Check(start.Add(23 * time.Second))
+	if len(s.records) != 0 {
+		t.Errorf("records = %v, want none", s.records)
+	}
+}
+
+func TestSupervisorBackoff(t *testing.T) {
+	s := NewSupervisor(Config{Supervisor: SupervisorConfig{BackoffSec: 2, MaxBackoffSec: 10}})
+
+	tests := []struct {
+		restarts int
+		want     time.Duration
+	}{
+		{restarts: 1, want: 2 * time.Second},
+		{restarts: 2, want: 4 * time.Second},
+		{restarts: 3, want: 8 * time.Second},
+		{restarts: 4, want: 10 * time.Second},
+		{restarts: 100, want: 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := s.backoff(tt.restarts); got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.restarts, got, tt.want)
+		}
+	}
+}
+
+func TestSupervisorLoadMounts(t *testing.T) {
+	hookConfig := Config{RuntimeDir: t.TempDir(), HostMountScope: ScopePod}
+
+	shared := MountState{Mount: Mount{Name: "input", HostMountPoint: "/blobdata/pod-1/input"}, Owner: "pod-1", Launch: &DaemonLaunch{ProgramPath: "/usr/bin/blobfuse2"}, Shared: true}
+	for i, containerID := range []string{"c1", "c2"} {
+		shared.BindTarget = "/bundle/" + containerID + "/rootfs/in"
+		state := ContainerState{ContainerID: containerID, Pid: 100 + i, Mounts: []MountState{shared}}
+		if containerID == "c1" {
+			// Recorded without a launch
+			state.Mounts = append(state.Mounts, MountState{Mount: Mount{Name: "output", HostMountPoint: "/blobdata/c1/output"}, Owner: "c1"})
+		}
+		if err := WriteContainerState(hookConfig, state); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Being torn down
+	tearingDown := MountState{Mount: Mount{Name: "output", HostMountPoint: "/blobdata/c3/output"}, Owner: "c3", Launch: &DaemonLaunch{}, TearingDown: true}
+	if err := WriteContainerState(hookConfig, ContainerState{ContainerID: "c3", Mounts: []MountState{tearingDown}}); err != nil {
+		t.Fatal(err)
+	}
+	// Runtime directory of the pod, without state
+	if err := os.MkdirAll(ContainerRuntimeDir(hookConfig, "pod-1"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	mounts, err := NewSupervisor(hookConfig).loadMounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mounts) != 1 || mounts[0].Mount.HostMountPoint != "/blobdata/pod-1/input" {
+		t.Fatalf("loadMounts() = %+v, want the shared mount", mounts)
+	}
+	want := []supervisedBind{
+		{ContainerID: "c1", Pid: 100, BindTarget: "/bundle/c1/rootfs/in"},
+		{ContainerID: "c2", Pid: 101, BindTarget: "/bundle/c2/rootfs/in"},
+	}
+	if fmt.Sprint(mounts[0].binds) != fmt.Sprint(want) {
+		t.Errorf("binds = %+v, want %+v", mounts[0].binds, want)
+	}
+}
+
+func TestSupervisorLockMount(t *testing.T) {
+	hookConfig := Config{RuntimeDir: t.TempDir(), HostMountScope: ScopePod}
+	s := NewSupervisor(hookConfig)
+
+	shared := MountState{Mount: Mount{Name: "input", HostMountPoint: "/blobdata/pod-1/input"}, Owner: "pod-1", Launch: &DaemonLaunch{ProgramPath: "/usr/bin/blobfuse2"}, Shared: true}
+	for _, containerID := range []string{"c1", "c2", "c3"} {
+		if err := WriteContainerState(hookConfig, ContainerState{ContainerID: containerID, Mounts: []MountState{shared}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mounts, err := s.loadMounts()
+	if err != nil || len(mounts) != 1 || len(mounts[0].binds) != 3 {
+		t.Fatalf("loadMounts() = %+v, %v, want the shared mount in 3 containers", mounts, err)
+	}
+
+	// c1 is torn down and c2 is tearing down once the mount is loaded
+	if err := RemoveContainerRuntimeDir(hookConfig, "c1"); err != nil {
+		t.Fatal(err)
+	}
+	err = UpdateContainerState(hookConfig, "c2", func(state *ContainerState) error {
+		state.Mounts[0].TearingDown = true
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	locked, unlock, err := s.lockMount(mounts[0])
+	if err != nil {
+		t.Fatalf("lockMount() error = %v", err)
+	}
+	if len(locked.binds) != 1 || locked.binds[0].ContainerID != "c3" {
+		t.Errorf("locked binds = %+v, want c3 only", locked.binds)
+	}
+
+	// The teardown waits for the unlock
+	updated := make(chan error)
+	go func() {
+		updated <- UpdateContainerState(hookConfig, "c3", func(state *ContainerState) error { return nil })
+	}()
+	select {
+	case err := <-updated:
+		t.Fatalf("state updated while locked, %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	unlock()
+	if err := <-updated; err != nil {
+		t.Errorf("UpdateContainerState() error = %v", err)
+	}
+}
+
+func TestRebindInContainer(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("entering a mount namespace requires root")
+	}
+	if _, err := exec.LookPath("unshare"); err != nil {
+		t.Skip("unshare not found")
+	}
+
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source")
+	target := filepath.Join(dir, "target")
+	for _, d := range []string{source, target} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(source, "blob"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A container in its own mount namespace
+	cmd := exec.Command("unshare", "--mount", "--propagation", "private", "sleep", "30")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+	for i := 0; i < 100; i++ {
+		if other, _ := inOtherMountNamespace(cmd.Process.Pid); other {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Twice, the second replaces the first
+	for i := 0; i < 2; i++ {
+		if err := RebindInContainer(cmd.Process.Pid, source, target, true); err != nil {
+			t.Fatalf("RebindInContainer() error = %v", err)
+		}
+	}
+
+	mountInfo, err := os.ReadFile(fmt.Sprintf("/proc/%d/mountinfo", cmd.Process.Pid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for _, line := range strings.Split(string(mountInfo), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 5 && fields[4] == target {
+			count++
+			if !strings.HasPrefix(fields[5], "ro") {
+				t.Errorf("container mount options = %s, want ro", fields[5])
+			}
+		}
+	}
+	if count != 1 {
+		t.Errorf("%d mounts at %s in the container, want 1", count, target)
+	}
+
+	// The hook mount namespace is untouched
+	if mounted, _ := isMountPoint(target); mounted {
+		t.Errorf("%s mounted in the hook mount namespace", target)
+	}
+}
+
+// Check if /proc/self/mountinfo has a mount at target
+func isMountPoint(target string) (bool, error) {
+	mountInfo, err := os.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		return false, err
+	}
+	for _, line := range strings.Split(string(mountInfo), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 4 && fields[4] == target {
+			return true, nil
+		}
+	}
+	return false, nil
+}
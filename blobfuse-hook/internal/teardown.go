@@ -0,0 +1,194 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Default time to wait for the fuse daemon to exit once unmounted
+const DefaultUnmountTimeoutSec = 10
+
+// Programs unmounting fuse mounts, in order of preference
+var fusermountPrograms = []string{"fusermount3", "fusermount"}
+
+// Method to tear down the mounts recorded for a container
+// The mounts are marked as tearing down in the state first, see Supervisor.
+// For each mount, the background prefetch is stopped, the bind mount in the container rootfs
+// is unmounted, then the fuse mount, unless still used by other containers, and its cache
+// and rendered config are removed.
+// Teardown continues on errors, the first error is returned
+func TeardownContainer(hookConfig Config, containerID string) error {
+	state, err := ReadContainerState(hookConfig, containerID)
+	if err != nil {
+		return err
+	}
+	if len(state.Mounts) == 0 {
+		log.Printf("No mounts recorded for container %s\n", containerID)
+	} else {
+		// Mark the mounts first, the supervisor doesn't restart their daemons any more
+		err = UpdateContainerState(hookConfig, containerID, func(locked *ContainerState) error {
+			for i := range locked.Mounts {
+				locked.Mounts[i].TearingDown = true
+			}
+			state = *locked
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	var firstErr error
+	for _, mountState := range state.Mounts {
+		if err := TeardownMount(hookConfig, containerID, mountState); err != nil {
+			log.Printf("unable to tear down mount %s %s\n", mountState.Mount.Name, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if firstErr != nil {
+		// Keep the state to retry
+		return firstErr
+	}
+
+	return RemoveContainerRuntimeDir(hookConfig, containerID)
+}
+
+// Method to tear down a mount of a container
+func TeardownMount(hookConfig Config, containerID string, mountState MountState) error {
+	mount := mountState.GetMount()
+	log.Printf("Tearing down mount %s\n", mount.Name)
+
+	StopBackgroundPrefetch(containerID, mountState)
+
+	if mountState.BindTarget != "" {
+		if err := unmount(mountState.BindTarget); err != nil {
+			return err
+		}
+	}
+
+	unmountFuse := func() error {
+		if err := UnmountFuse(hookConfig, mountState.ProgramPath, mount.HostMountPoint); err != nil {
+			return err
+		}
+		RemoveDaemonCgroup(mountState.Cgroup)
+		if err := RemoveMountRuntimeDir(hookConfig, mount); err != nil {
+			return err
+		}
+		// Remove the runtime directory of a pod or node once its last mount is gone
+		if mount.Owner != containerID {
+			os.Remove(ContainerRuntimeDir(hookConfig, mount.Owner))
+		}
+		return nil
+	}
+
+	if mountState.Shared {
+		return ReleaseSharedMount(hookConfig, mount, containerID, unmountFuse)
+	}
+	return unmountFuse()
+}
+
+// Method to unmount the fuse mount at mountPoint and wait for its daemon to exit
+// Uses fusermount3 -u, or a lazy unmount if it fails. The daemon is killed if it
+// doesn't exit within hookConfig.UnmountTimeoutSec
+func UnmountFuse(hookConfig Config, programPath string, mountPoint string) error {
+	// Find the daemon before unmounting, it exits once unmounted
+	pids := FindFuseDaemons(programPath, mountPoint)
+
+	mounted, err := IsFuseMounted(mountPoint)
+	if err != nil {
+		return err
+	}
+	if mounted {
+		if err := fusermountUnmount(hookConfig, mountPoint); err != nil {
+			log.Printf("fusermount failed, unmounting %s lazily %s\n", mountPoint, err)
+			if err := mounter.Unmount(mountPoint, syscall.MNT_DETACH); err != nil {
+				log.Printf("unable to unmount %s %s\n", mountPoint, err)
+				return err
+			}
+		}
+	} else {
+		log.Printf("%s is not mounted\n", mountPoint)
+	}
+
+	timeout := time.Duration(hookConfig.UnmountTimeoutSec) * time.Second
+	if timeout <= 0 {
+		timeout = DefaultUnmountTimeoutSec * time.Second
+	}
+	waitForExit(pids, limitToDeadline(hookConfig, timeout))
+
+	// Remove the mountpoint directory if empty
+	if err := os.Remove(mountPoint); err != nil && !os.IsNotExist(err) {
+		log.Printf("unable to remove mountpoint %s\n", err)
+	}
+	return nil
+}
+
+// Run fusermount -u on mountPoint, killed if it doesn't return in time
+func fusermountUnmount(hookConfig Config, mountPoint string) error {
+	for _, program := range fusermountPrograms {
+		path, err := runner.LookPath(program)
+		if err != nil {
+			continue
+		}
+		cmd := commandWithTimeout(hookConfig, ActionUnmount, path, "-u", mountPoint)
+		output, err := runner.CombinedOutput(cmd.Cmd)
+		err = cmd.error(err)
+		cmd.done()
+		if err != nil {
+			return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+		}
+		log.Printf("%s unmounted by %s\n", mountPoint, program)
+		return nil
+	}
+	return fmt.Errorf("fusermount not found")
+}
+
+// Unmount a bind mount, lazily if busy. Missing or unmounted targets are ignored
+func unmount(target string) error {
+	err := mounter.Unmount(target, 0)
+	if err == syscall.EBUSY {
+		log.Printf("%s is busy, unmounting lazily\n", target)
+		err = mounter.Unmount(target, syscall.MNT_DETACH)
+	}
+	if err != nil && err != syscall.EINVAL && err != syscall.ENOENT {
+		log.Printf("unable to unmount %s %s\n", target, err)
+		return err
+	}
+	return nil
+}
+
+// Wait for the processes to exit, killing the remaining ones after timeout
+func waitForExit(pids []int, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for _, pid := range pids {
+		for processExists(pid) {
+			if time.Now().After(deadline) {
+				log.Printf("pid %d did not exit after %s, killing it\n", pid, timeout)
+				if err := syscall.Kill(pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+					log.Printf("unable to kill pid %d %s\n", pid, err)
+				}
+				break
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+}
+
+// Check if a process exists and is not a zombie
+func processExists(pid int) bool {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+	// The state follows the parenthesized command name
+	stat := string(data)
+	if i := strings.LastIndex(stat, ")"); i >= 0 && i+2 < len(stat) {
+		return stat[i+2] != 'Z'
+	}
+	return true
+}
@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestContainerState(t *testing.T) {
+	hookConfig := Config{RuntimeDir: t.TempDir()}
+
+	state, err := ReadContainerState(hookConfig, "c1")
+	if err != nil || len(state.Mounts) != 0 {
+		t.Fatalf("ReadContainerState() = %+v, %v, want an empty state", state, err)
+	}
+
+	state.Mounts = []MountState{{
+		Mount:       Mount{Name: "input", ContainerName: "input", HostMountPoint: "/blobdata/c1/input", ContainerMountPoint: "/in"},
+		Owner:       "c1",
+		BindTarget:  "/bundle/rootfs/in",
+		ProgramPath: "/usr/bin/blobfuse2",
+	}}
+	if err := WriteContainerState(hookConfig, state); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ReadContainerState(hookConfig, "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, state) {
+		t.Errorf("ReadContainerState() = %+v, want %+v", got, state)
+	}
+	if got.Mounts[0].GetMount().Owner != "c1" {
+		t.Errorf("GetMount() has no owner")
+	}
+
+	if _, err := ReadContainerState(hookConfig, "../c1"); err == nil {
+		t.Errorf("ReadContainerState() accepted an invalid container id")
+	}
+}
+
+func TestTeardownContainer(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("unmounting requires root")
+	}
+
+	dir := t.TempDir()
+	hookConfig := Config{RuntimeDir: filepath.Join(dir, "run"), HostMountScope: ScopePod, UnmountTimeoutSec: 1}
+
+	// Two containers of a pod sharing an unmounted mount
+	mount := Mount{Name: "input", HostMountPoint: filepath.Join(dir, "blobdata", "pod-1", "input"), ContainerMountPoint: "/in", Owner: "pod-1"}
+	if err := os.MkdirAll(mount.HostMountPoint, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(MountRuntimeDir(hookConfig, mount), "cache"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	for _, containerID := range []string{"c1", "c2"} {
+		if err := AcquireSharedMount(hookConfig, mount, "hash", containerID, func() error { return nil }); err != nil {
+			t.Fatal(err)
+		}
+		state := ContainerState{ContainerID: containerID, Mounts: []MountState{{
+			Mount:       mount,
+			Owner:       mount.Owner,
+			BindTarget:  filepath.Join(dir, containerID, "rootfs", "in"),
+			ProgramPath: "/nonexistent/blobfuse2",
+			Shared:      true,
+		}}}
+		if err := WriteContainerState(hookConfig, state); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := TeardownContainer(hookConfig, "c1"); err != nil {
+		t.Fatalf("TeardownContainer(c1) error = %v", err)
+	}
+	if _, err := os.Stat(ContainerRuntimeDir(hookConfig, "c1")); !os.IsNotExist(err) {
+		t.Errorf("runtime directory of c1 not removed")
+	}
+	if _, err := os.Stat(MountRuntimeDir(hookConfig, mount)); err != nil {
+		t.Errorf("mount still used by c2 was torn down: %v", err)
+	}
+
+	if err := TeardownContainer(hookConfig, "c2"); err != nil {
+		t.Fatalf("TeardownContainer(c2) error = %v", err)
+	}
+	if _, err := os.Stat(MountRuntimeDir(hookConfig, mount)); !os.IsNotExist(err) {
+		t.Errorf("runtime directory of the mount not removed")
+	}
+	if _, err := os.Stat(mount.HostMountPoint); !os.IsNotExist(err) {
+		t.Errorf("host mountpoint not removed")
+	}
+
+	// Nothing recorded
+	if err := TeardownContainer(hookConfig, "c3"); err != nil {
+		t.Errorf("TeardownContainer(c3) error = %v", err)
+	}
+}
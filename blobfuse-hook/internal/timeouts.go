@@ -0,0 +1,157 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// Default time limit of fusermount
+const DefaultUnmountCommandTimeoutSec = 10
+
+// Time kept before the OCI hook timeout to report the error and clean up, at most
+// a tenth of the timeout
+const maxHookDeadlineMargin = 2 * time.Second
+
+// Actions of the hook running external programs, see CommandTimeouts
+const (
+	ActionMount   = "mount"
+	ActionUnmount = "unmount"
+)
+
+// Create a struct to hold the time limits of the external programs run by the hook
+// A program still running at its limit is killed with its process group. The limits
+// are shortened to end before the OCI hook timeout, see HookDeadline
+type CommandTimeouts struct {
+	// Fuse program until it daemonizes. Default is mount_timeout_sec
+	MountSec int `json:"mount_sec,omitempty"`
+
+	// fusermount unmounting a fuse mount. Default is 10
+	UnmountSec int `json:"unmount_sec,omitempty"`
+}
+
+// Create a struct to hold the error of an external program killed at its time limit
+type TimeoutError struct {
+	Program string
+	Action  string
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s %s timed out after %s, killed", filepath.Base(e.Program), e.Action, e.Timeout.Round(time.Millisecond))
+}
+
+// Timeout errors match context.DeadlineExceeded with errors.Is
+func (e *TimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// Method to get the deadline of the hook from its OCI hook timeout in the container config
+// The hook is the entry of the stage running hookPath. Returns the zero time when the
+// hook is not found or has no timeout
+func HookDeadline(containerConfig specs.Spec, stage string, hookPath string, start time.Time) time.Time {
+	if containerConfig.Hooks == nil {
+		return time.Time{}
+	}
+
+	var hooks []specs.Hook
+	switch stage {
+	case StagePrestart:
+		hooks = append(append(append(hooks, containerConfig.Hooks.Prestart...), containerConfig.Hooks.CreateRuntime...), containerConfig.Hooks.CreateContainer...)
+	case StagePoststart:
+		hooks = append(append(hooks, containerConfig.Hooks.Poststart...), containerConfig.Hooks.StartContainer...)
+	case StagePoststop:
+		hooks = containerConfig.Hooks.Poststop
+	}
+
+	for _, hook := range hooks {
+		if hook.Timeout == nil || *hook.Timeout <= 0 {
+			continue
+		}
+		if hook.Path != hookPath && filepath.Base(hook.Path) != filepath.Base(hookPath) {
+			continue
+		}
+		timeout := time.Duration(*hook.Timeout) * time.Second
+		margin := timeout / 10
+		if margin > maxHookDeadlineMargin {
+			margin = maxHookDeadlineMargin
+		}
+		return start.Add(timeout - margin)
+	}
+	return time.Time{}
+}
+
+// Method to shorten timeout to end at the hook deadline, if set
+func limitToDeadline(hookConfig Config, timeout time.Duration) time.Duration {
+	if hookConfig.Deadline.IsZero() {
+		return timeout
+	}
+	if remaining := time.Until(hookConfig.Deadline); remaining < timeout {
+		return remaining
+	}
+	return timeout
+}
+
+// Method to get the time limit of the program run for action
+func CommandTimeout(hookConfig Config, action string) time.Duration {
+	var seconds int
+	switch action {
+	case ActionMount:
+		seconds = hookConfig.Timeouts.MountSec
+		if seconds <= 0 {
+			seconds = hookConfig.MountTimeoutSec
+		}
+		if seconds <= 0 {
+			seconds = DefaultMountTimeoutSec
+		}
+	case ActionUnmount:
+		seconds = hookConfig.Timeouts.UnmountSec
+		if seconds <= 0 {
+			seconds = DefaultUnmountCommandTimeoutSec
+		}
+	}
+	return limitToDeadline(hookConfig, time.Duration(seconds)*time.Second)
+}
+
+// Create a struct to hold an external program run for an action with its time limit
+type timedCommand struct {
+	*exec.Cmd
+	action  string
+	timeout time.Duration
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// Method to create the command of program run for action, with its time limit
+// The command runs in its own process group, killed as a whole once the limit is reached,
+// so the children of a hung program don't outlive it. Call done once the command exited
+func commandWithTimeout(hookConfig Config, action string, program string, args ...string) *timedCommand {
+	timeout := CommandTimeout(hookConfig, action)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+
+	cmd := exec.CommandContext(ctx, program, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		log.Printf("%s %s not done after %s, killing process group %d\n", filepath.Base(program), action, timeout, cmd.Process.Pid)
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	return &timedCommand{Cmd: cmd, action: action, timeout: timeout, ctx: ctx, cancel: cancel}
+}
+
+// Method to release the time limit of the command
+func (c *timedCommand) done() {
+	c.cancel()
+}
+
+// Method to return a TimeoutError when the command failed with err by reaching its time limit
+func (c *timedCommand) error(err error) error {
+	if err != nil && c.ctx.Err() == context.DeadlineExceeded {
+		return &TimeoutError{Program: c.Path, Action: c.action, Timeout: c.timeout}
+	}
+	return err
+}
@@ -0,0 +1,134 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func TestHookDeadline(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	timeout := func(sec int) *int { return &sec }
+
+	tests := []struct {
+		name  string
+		hooks *specs.Hooks
+		stage string
+		want  time.Time
+	}{
+		{name: "no hooks", stage: StagePrestart},
+		{
+			name:  "prestart hook with a timeout",
+			hooks: &specs.Hooks{Prestart: []specs.Hook{{Path: "/usr/bin/other", Timeout: timeout(5)}, {Path: "/usr/bin/blobfuse-hook", Timeout: timeout(30)}}},
+			stage: StagePrestart,
+			want:  start.Add(28 * time.Second),
+		},
+		{
+			name:  "short timeout keeps a tenth",
+			hooks: &specs.Hooks{CreateRuntime: []specs.Hook{{Path: "/usr/bin/blobfuse-hook", Timeout: timeout(5)}}},
+			stage: StagePrestart,
+			want:  start.Add(4500 * time.Millisecond),
+		},
+		{
+			name:  "hook found by name",
+			hooks: &specs.Hooks{Poststart: []specs.Hook{{Path: "/opt/hooks/blobfuse-hook", Timeout: timeout(10)}}},
+			stage: StagePoststart,
+			want:  start.Add(9 * time.Second),
+		},
+		{
+			name:  "hook of another stage",
+			hooks: &specs.Hooks{Poststop: []specs.Hook{{Path: "/usr/bin/blobfuse-hook", Timeout: timeout(10)}}},
+			stage: StagePrestart,
+		},
+		{
+			name:  "hook without a timeout",
+			hooks: &specs.Hooks{Poststop: []specs.Hook{{Path: "/usr/bin/blobfuse-hook"}}},
+			stage: StagePoststop,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := HookDeadline(specs.Spec{Hooks: tt.hooks}, tt.stage, "/usr/bin/blobfuse-hook", start)
+			if !got.Equal(tt.want) {
+				t.Errorf("HookDeadline() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommandTimeout(t *testing.T) {
+	tests := []struct {
+		name       string
+		hookConfig Config
+		action     string
+		want       time.Duration
+	}{
+		{name: "default mount", action: ActionMount, want: DefaultMountTimeoutSec * time.Second},
+		{name: "mount from the readiness timeout", hookConfig: Config{MountTimeoutSec: 60}, action: ActionMount, want: time.Minute},
+		{name: "mount", hookConfig: Config{MountTimeoutSec: 60, Timeouts: CommandTimeouts{MountSec: 20}}, action: ActionMount, want: 20 * time.Second},
+		{name: "default unmount", action: ActionUnmount, want: DefaultUnmountCommandTimeoutSec * time.Second},
+		{name: "unmount", hookConfig: Config{Timeouts: CommandTimeouts{UnmountSec: 3}}, action: ActionUnmount, want: 3 * time.Second},
+		{name: "far hook deadline", hookConfig: Config{Deadline: time.Now().Add(time.Hour)}, action: ActionUnmount, want: DefaultUnmountCommandTimeoutSec * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CommandTimeout(tt.hookConfig, tt.action); got != tt.want {
+				t.Errorf("CommandTimeout() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	// Shortened to end at a close hook deadline
+	got := CommandTimeout(Config{Deadline: time.Now().Add(2 * time.Second)}, ActionMount)
+	if got <= 0 || got > 2*time.Second {
+		t.Errorf("CommandTimeout() = %s, want at most the 2s left before the hook deadline", got)
+	}
+}
+
+func TestCommandWithTimeout(t *testing.T) {
+	// The program returns in time
+	cmd := commandWithTimeout(Config{}, ActionUnmount, "/bin/sh", "-c", "echo ok")
+	output, err := cmd.Output()
+	cmd.done()
+	if err = cmd.error(err); err != nil || strings.TrimSpace(string(output)) != "ok" {
+		t.Fatalf("command = %q, %v, want ok", output, err)
+	}
+
+	// The program and its child hang, the process group is killed at the hook deadline
+	hookConfig := Config{Deadline: time.Now().Add(300 * time.Millisecond)}
+	cmd = commandWithTimeout(hookConfig, ActionMount, "/bin/sh", "-c", "sleep 30 & echo $!; wait")
+	var stdout strings.Builder
+	cmd.Stdout = &stdout
+	start := time.Now()
+	err = cmd.error(cmd.Run())
+	cmd.done()
+
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("command error = %v, want a timeout error", err)
+	}
+	if timeoutErr.Action != ActionMount || !strings.Contains(err.Error(), "sh mount timed out") {
+		t.Errorf("timeout error = %q", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("command killed after %s", elapsed)
+	}
+
+	child, err := strconv.Atoi(strings.TrimSpace(stdout.String()))
+	if err != nil {
+		t.Fatalf("unable to read the child pid %q", stdout.String())
+	}
+	for i := 0; i < 50 && processExists(child); i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if processExists(child) {
+		t.Errorf("child pid %d of the killed command is still running", child)
+	}
+}
@@ -17,3 +17,13 @@ func ConvertOptionsToString(options []string) string {
 	// Return the options string
 	return optionsString
 }
+
+// Check if a string is present in a slice of strings
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
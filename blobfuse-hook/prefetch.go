@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/bpradipt/kata-hooks/blobfuse-hook/internal"
+	"github.com/spf13/cobra"
+)
+
+// Create the "prefetch" command, started by the hook for the background prefetch
+func newPrefetchCommand() *cobra.Command {
+	var runtimeDir string
+
+	prefetchCmd := &cobra.Command{
+		Use:   "prefetch <container id> <mount name>",
+		Short: "Prefetch a blob mount of a container",
+		Long: "Read the prefetch files of a mount recorded in the hook state to warm its cache, " +
+			"recording the progress in the state. Started by the hook for background prefetches",
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			log.Out = os.Stderr
+			internal.SetLogger(log)
+
+			hookConfig := internal.Config{RuntimeDir: runtimeDir}
+			containerID, mountName := args[0], args[1]
+
+			state, err := internal.ReadContainerState(hookConfig, containerID)
+			if err != nil {
+				return err
+			}
+			for _, mountState := range state.Mounts {
+				if mountState.Mount.Name == mountName && mountState.Mount.Prefetch != nil {
+					stats := internal.PrefetchMount(hookConfig, containerID, mountState)
+					if stats.State != internal.PrefetchDone {
+						return fmt.Errorf("prefetch of mount %s %s", mountName, stats.State)
+					}
+					return nil
+				}
+			}
+			return fmt.Errorf("no prefetch recorded for mount %s of container %s", mountName, containerID)
+		},
+	}
+
+	prefetchCmd.Flags().StringVar(&runtimeDir, "runtime-dir", internal.DefaultRuntimeDir, "Directory holding the hook state")
+	return prefetchCmd
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/bpradipt/kata-hooks/blobfuse-hook/internal"
+)
+
+// Name of the stub fuse program, a link to the test binary, see TestMain
+const stubProgram = "blobfuse2"
+
+// File created in the host mountpoint by the stub program instead of a fuse mount
+const stubMountMarker = ".stub-fuse"
+
+// Run the test binary as the stub fuse program when started through its link
+func TestMain(m *testing.M) {
+	if filepath.Base(os.Args[0]) == stubProgram {
+		os.Exit(runStubProgram(os.Args))
+	}
+	os.Exit(m.Run())
+}
+
+// Create a struct to hold how the stub program behaves, read from stub.json next to it
+type stubBehavior struct {
+	// Exit without mounting
+	NoMount bool `json:"no_mount,omitempty"`
+
+	// Never return, until killed
+	Hang bool `json:"hang,omitempty"`
+
+	// Exit code and stderr
+	ExitCode int    `json:"exit_code,omitempty"`
+	Stderr   string `json:"stderr,omitempty"`
+}
+
+// Create a struct to hold a run of the stub program, appended to calls.jsonl next to it
+type stubCall struct {
+	Args []string `json:"args"`
+	Env  []string `json:"env"`
+}
+
+// Record the call and "mount" by creating the marker in the mountpoint, see stubMounter
+// blobfuse2 is called as: blobfuse2 mount <mountpoint> --config-file=<file> ...
+func runStubProgram(args []string) int {
+	dir := filepath.Dir(args[0])
+
+	var behavior stubBehavior
+	if data, err := os.ReadFile(filepath.Join(dir, "stub.json")); err == nil {
+		if err := json.Unmarshal(data, &behavior); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+
+	call, _ := json.Marshal(stubCall{Args: args[1:], Env: os.Environ()})
+	calls, err := os.OpenFile(filepath.Join(dir, "calls.jsonl"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	fmt.Fprintf(calls, "%s\n", call)
+	calls.Close()
+
+	if behavior.Hang {
+		for {
+			time.Sleep(time.Hour)
+		}
+	}
+	if behavior.Stderr != "" {
+		fmt.Fprintln(os.Stderr, behavior.Stderr)
+	}
+	if behavior.ExitCode != 0 {
+		return behavior.ExitCode
+	}
+	if !behavior.NoMount && len(args) > 2 && args[1] == "mount" {
+		if err := os.WriteFile(filepath.Join(args[2], stubMountMarker), nil, 0644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+	return 0
+}
+
+// Mounter recording the mounts instead of making them
+// A fuse mount is the marker created by the stub program in the mountpoint
+type stubMounter struct {
+	mu     sync.Mutex
+	mounts map[string]string
+
+	// Error of the bind mounts, if set
+	bindErr error
+}
+
+func newStubMounter() *stubMounter {
+	return &stubMounter{mounts: make(map[string]string)}
+}
+
+func (m *stubMounter) Mount(source string, target string, fsType string, options string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if strings.HasPrefix(options, "remount") {
+		return nil
+	}
+	if m.bindErr != nil {
+		return m.bindErr
+	}
+	m.mounts[resolveProcFd(target)] = source
+	return nil
+}
+
+func (m *stubMounter) Unmount(target string, flags int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	target = resolveProcFd(target)
+	if _, ok := m.mounts[target]; ok {
+		delete(m.mounts, target)
+		return nil
+	}
+	err := os.Remove(filepath.Join(target, stubMountMarker))
+	if os.IsNotExist(err) {
+		return syscall.EINVAL
+	}
+	return err
+}
+
+func (m *stubMounter) IsFuseMounted(mountPoint string) (bool, error) {
+	_, err := os.Stat(filepath.Join(mountPoint, stubMountMarker))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Return the path of the directory open at a /proc/self/fd target, see internal.BindMount
+func resolveProcFd(target string) string {
+	if !strings.HasPrefix(target, "/proc/self/fd/") {
+		return target
+	}
+	if resolved, err := os.Readlink(target); err == nil {
+		return resolved
+	}
+	return target
+}
+
+// Return the source mounted at target, if any
+func (m *stubMounter) source(target string) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	source, ok := m.mounts[target]
+	return source, ok
+}
+
+// Runner starting the programs without the daemon attributes, and without the host fusermount
+type stubRunner struct {
+	internal.ExecRunner
+}
+
+func (stubRunner) LookPath(program string) (string, error) {
+	return "", exec.ErrNotFound
+}
+
+func (stubRunner) Start(cmd *exec.Cmd, daemon internal.DaemonConfig, netNS string) error {
+	return cmd.Start()
+}
+
+// Create a struct to hold the directory of the stub program
+type stub struct {
+	t   *testing.T
+	dir string
+}
+
+// Link the stub program in a temp dir and make the hook use the stub mounter and runner
+func newStub(t *testing.T, behavior stubBehavior) (*stub, *stubMounter) {
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &stub{t: t, dir: t.TempDir()}
+	if err := os.Symlink(executable, s.path()); err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(behavior)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(s.dir, "stub.json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	mounter := newStubMounter()
+	internal.SetMounter(mounter)
+	internal.SetRunner(stubRunner{})
+	t.Cleanup(func() {
+		internal.SetMounter(internal.SystemMounter{})
+		internal.SetRunner(internal.ExecRunner{})
+	})
+	return s, mounter
+}
+
+// Return the path of the stub program
+func (s *stub) path() string {
+	return filepath.Join(s.dir, stubProgram)
+}
+
+// Return the recorded runs of the stub program
+func (s *stub) calls() []stubCall {
+	data, err := os.ReadFile(filepath.Join(s.dir, "calls.jsonl"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		s.t.Fatal(err)
+	}
+
+	var calls []stubCall
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	for decoder.More() {
+		var call stubCall
+		if err := decoder.Decode(&call); err != nil {
+			s.t.Fatal(err)
+		}
+		calls = append(calls, call)
+	}
+	return calls
+}
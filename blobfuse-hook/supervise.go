@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/bpradipt/kata-hooks/blobfuse-hook/internal"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// Create the "supervise" command
+// hookConfigFile points to the --config flag of the root command
+func newSuperviseCommand(hookConfigFile *string) *cobra.Command {
+	var debug bool
+
+	superviseCmd := &cobra.Command{
+		Use:   "supervise",
+		Short: "Restart the crashed fuse daemons of the hook mounts",
+		Long: "Watch the fuse daemons recorded in the hook state and restart the dead or disconnected ones " +
+			"with the same config, binding them again into the containers. Runs until SIGTERM or SIGINT",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Long running, log to stderr for the service manager
+			log.Out = os.Stderr
+			if debug {
+				log.SetLevel(logrus.DebugLevel)
+			}
+			internal.SetLogger(log)
+
+			hookConfig, err := internal.ReadConfig(*hookConfigFile)
+			if err != nil {
+				return err
+			}
+
+			stop := make(chan struct{})
+			signals := make(chan os.Signal, 1)
+			signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+			go func() {
+				sig := <-signals
+				log.Infof("Received %s, stopping\n", sig)
+				close(stop)
+			}()
+
+			internal.NewSupervisor(hookConfig).Run(stop)
+			return nil
+		},
+	}
+
+	superviseCmd.Flags().BoolVarP(&debug, "debug", "d", false, "Enable debug mode (default is false)")
+	return superviseCmd
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+
+	"github.com/kata-hooks/generic-hook/internal"
+	"github.com/spf13/cobra"
+)
+
+// Create the "cdi" command and its subcommands
+// hookConfigFile points to the --config flag of the root command
+func newCdiCommand(hookConfigFile *string) *cobra.Command {
+	var outputFile, kind, deviceName string
+
+	cdiCmd := &cobra.Command{
+		Use:   "cdi",
+		Short: "Container Device Interface (CDI) helpers",
+	}
+
+	generateCmd := &cobra.Command{
+		Use:   "generate",
+		Short: "Generate a CDI spec from the hook config",
+		Long: "Generate a CDI spec describing the devices, mounts and env of the hook config, " +
+			"with one CDI device per preset and one for the rest of the hook config, " +
+			"so that CDI-aware runtimes can inject them without the hook",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Keep stdout for the generated spec
+			log.Out = os.Stderr
+			internal.SetLogger(log)
+
+			hookConfig, err := internal.ReadConfig(*hookConfigFile)
+			if err != nil {
+				return err
+			}
+
+			cdiSpec, err := internal.GenerateCDISpec(hookConfig, kind, deviceName)
+			if err != nil {
+				return err
+			}
+
+			return internal.WriteCDISpec(outputFile, cdiSpec)
+		},
+	}
+
+	generateCmd.Flags().StringVarP(&outputFile, "output", "o", "", "Path to the CDI spec file (default is stdout)")
+	generateCmd.Flags().StringVar(&kind, "kind", internal.DefaultCDIKind, "CDI kind (vendor/class) of the generated devices")
+	generateCmd.Flags().StringVar(&deviceName, "name", "default", "CDI device name for the devices, mounts and env of the hook config outside the presets")
+
+	cdiCmd.AddCommand(generateCmd)
+	return cdiCmd
+}
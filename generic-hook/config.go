@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/kata-hooks/generic-hook/internal"
+	"github.com/spf13/cobra"
+)
+
+// Create the "config" command and its subcommands
+// hookConfigFile points to the --config flag of the root command
+func newConfigCommand(hookConfigFile *string) *cobra.Command {
+	configCmd := &cobra.Command{
+		Use:   "config",
+		Short: "Hook config helpers",
+	}
+
+	dumpCmd := &cobra.Command{
+		Use:   "dump",
+		Short: "Print the hook config with the presets expanded",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Keep stdout for the config
+			log.Out = os.Stderr
+			internal.SetLogger(log)
+
+			hookConfig, err := internal.ReadConfig(*hookConfigFile)
+			if err != nil {
+				return err
+			}
+
+			data, err := json.MarshalIndent(hookConfig, "", "  ")
+			if err != nil {
+				return err
+			}
+
+			fmt.Println(string(data))
+			return nil
+		},
+	}
+
+	configCmd.AddCommand(dumpCmd)
+	return configCmd
+}
@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 
 	"github.com/kata-hooks/generic-hook/internal"
+	"github.com/kata-hooks/hookutil"
 	spec "github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -64,14 +65,20 @@ func startOciHook(hookConfig *internal.Config, debug bool) error {
 	log.Printf("rootfsPath is %s\n", rootfsPath)
 
 	// Get all the activation flags
-	activationFlags := internal.GetActivationFlags(containerConfig.Process.Env, hookConfig.ActivationFlagAll,
-		hookConfig.ActivationFlagFiles, hookConfig.ActivationFlagDirs, hookConfig.ActivationFlagMounts, hookConfig.ActivationFlagDevices)
+	activationFlags := internal.GetActivationFlags(containerConfig.Process.Env, hookConfig)
 
 	// Check if ActivationFlagMounts is present in activationFlags
-	if activationFlags&8 == 8 {
+	if activationFlags&internal.ActivateMounts != 0 {
 		log.Infof("Activation flag %s is set to true. Creating mounts specified in hookConfig", hookConfig.ActivationFlagMounts)
 		// Create the hookConfig mounts
-		err = internal.CreateMounts(rootfsPath, hookConfig)
+		switch hookConfig.Mode {
+		case internal.ModeSpec:
+			err = internal.AddMountsToOciSpec(containerConfig, hookConfig)
+		case internal.ModeInject:
+			err = internal.InjectMounts(containerPid, hookConfig)
+		default:
+			err = internal.CreateMounts(rootfsPath, hookConfig)
+		}
 		if err != nil {
 			log.Printf("unable to create mounts defined in hook config %s\n", err)
 			return err
@@ -79,16 +86,93 @@ func startOciHook(hookConfig *internal.Config, debug bool) error {
 	}
 
 	// Check if ActivationFlagDevices is present in activationFlags
-	if activationFlags&16 == 16 {
+	if activationFlags&internal.ActivateDevices != 0 {
 		log.Infof("Activation flag %s is set to true. Creating devices specified in hookConfig", hookConfig.ActivationFlagDevices)
 		// Create the hookConfig devices
-		err = internal.CreateDevices(rootfsPath, hookConfig)
+		if hookConfig.Mode == internal.ModeSpec {
+			err = internal.AddDevicesToOciSpec(containerConfig, hookConfig)
+			if err == nil {
+				err = internal.AddDeviceWhitelistToOciSpec(containerConfig, hookConfig)
+			}
+			// Allow the devices in the device cgroup
+			if err == nil {
+				err = internal.AddDeviceRulesToOciSpec(containerConfig, hookConfig)
+			}
+			// Add the capabilities the preset devices need
+			if err == nil {
+				err = internal.AddPresetCapabilitiesToOciSpec(containerConfig, hookConfig)
+			}
+		} else {
+			err = internal.CreateDevices(rootfsPath, hookConfig)
+		}
 		if err != nil {
 			log.Printf("unable to create devices defined in hook config %s\n", err)
 			return err
 		}
 	}
 
+	// Check if ActivationFlagProcess is present in activationFlags
+	if activationFlags&internal.ActivateProcess != 0 {
+		log.Infof("Activation flag %s is set to true. Adjusting the container process", hookConfig.ActivationFlagProcess)
+		// Capabilities, rlimits and sysctls can only be changed through the spec
+		if hookConfig.Mode == internal.ModeSpec {
+			err = internal.AddProcessAdjustmentsToOciSpec(containerConfig, hookConfig)
+			if err != nil {
+				log.Printf("unable to adjust the container process %s\n", err)
+				return err
+			}
+		} else {
+			log.Infof("Process adjustments are only applied in %s mode, skipping", internal.ModeSpec)
+		}
+	}
+
+	// Mask paths whenever extra access is granted, or when ActivationFlagMask is present
+	if activationFlags&(internal.ActivateMask|internal.ActivateMounts|internal.ActivateDevices|internal.ActivateProcess) != 0 {
+		log.Infof("Masking paths and making paths read-only as specified in hookConfig")
+		switch hookConfig.Mode {
+		case internal.ModeSpec:
+			err = internal.AddMaskedPathsToOciSpec(containerConfig, hookConfig)
+		case internal.ModeInject:
+			err = internal.InjectMaskedPaths(containerPid, hookConfig)
+		default:
+			err = internal.MaskPaths(rootfsPath, hookConfig)
+		}
+		if err != nil {
+			log.Printf("unable to mask paths defined in hook config %s\n", err)
+			return err
+		}
+	}
+
+	// Check if ActivationFlagEnv is present in activationFlags
+	if activationFlags&internal.ActivateEnv != 0 {
+		log.Infof("Activation flag %s is set to true. Adding env and facts", hookConfig.ActivationFlagEnv)
+		facts := internal.NewFacts(s.ID, hookConfig, activationFlags)
+
+		// The container process env can only be changed through the spec
+		if hookConfig.Mode == internal.ModeSpec {
+			env, err := hookutil.RenderEnv(hookConfig.Env, facts)
+			if err != nil {
+				log.Printf("unable to render env defined in hook config %s\n", err)
+				return err
+			}
+			err = hookutil.AddEnvToOciSpec(containerConfig, env)
+			if err != nil {
+				log.Printf("unable to add env defined in hook config %s\n", err)
+				return err
+			}
+		} else if len(hookConfig.Env) != 0 {
+			log.Warnf("env defined in hook config is only added in %s mode, the workload has to read the facts file\n", internal.ModeSpec)
+		}
+
+		if hookConfig.FactsFile != "" {
+			err = hookutil.WriteFactsFile(rootfsPath, hookConfig.FactsFile, internal.FactsHookName, facts)
+			if err != nil {
+				log.Printf("unable to write facts file %s\n", err)
+				return err
+			}
+		}
+	}
+
 	if debug {
 		log.Debugf("updated containerConfig contents: %v", containerConfig)
 	}
@@ -175,10 +259,13 @@ func main() {
 	rootCmd.Flags().BoolVarP(&debug, "debug", "d", false, "Enable debug mode (default is false)")
 	rootCmd.Flags().BoolP("start", "s", true, "Start the OCI hook")
 	rootCmd.Flags().BoolVarP(&version, "version", "v", false, "Print the version")
-	rootCmd.Flags().StringVarP(&hookConfigFile, "config", "c", "/usr/share/oci/hooks/hookconfig.json", "Path to the hook config file (default /usr/share/oci/hooks/hookconfig.json))")
+	rootCmd.PersistentFlags().StringVarP(&hookConfigFile, "config", "c", "/usr/share/oci/hooks/hookconfig.json", "Path to the hook config file (default /usr/share/oci/hooks/hookconfig.json))")
 	// Log file or create a temp file
 	rootCmd.Flags().StringVarP(&logFile, "log", "l", "", "Path to the log file (default is temp file)")
 
+	rootCmd.AddCommand(newCdiCommand(&hookConfigFile))
+	rootCmd.AddCommand(newConfigCommand(&hookConfigFile))
+
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
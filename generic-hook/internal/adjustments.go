@@ -0,0 +1,209 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// Method to apply the hookConfig process adjustments to the containerConfig
+// Capabilities, rlimits and sysctls are checked against hookConfig.Allowed
+// before anything is changed, so a refused entry leaves the spec untouched
+func AddProcessAdjustmentsToOciSpec(containerConfig *specs.Spec, hookConfig *Config) error {
+
+	if containerConfig.Process == nil {
+		return fmt.Errorf("container spec has no process")
+	}
+
+	if err := CheckProcessAdjustments(hookConfig); err != nil {
+		log.Printf("process adjustments refused %s\n", err)
+		return err
+	}
+
+	addCapabilities(containerConfig.Process, hookConfig.Capabilities)
+	dropCapabilities(containerConfig.Process, hookConfig.CapabilitiesDrop)
+	setRlimits(containerConfig.Process, hookConfig.Rlimits)
+
+	if len(hookConfig.Sysctls) != 0 {
+		if containerConfig.Linux == nil {
+			containerConfig.Linux = &specs.Linux{}
+		}
+		if containerConfig.Linux.Sysctl == nil {
+			containerConfig.Linux.Sysctl = make(map[string]string)
+		}
+		for key, value := range hookConfig.Sysctls {
+			containerConfig.Linux.Sysctl[key] = value
+		}
+		log.Printf("containerConfig.Linux.Sysctl: %v\n", containerConfig.Linux.Sysctl)
+	}
+
+	return nil
+}
+
+// Method to add the capabilities of the hookConfig presets to the containerConfig
+// They come with the preset devices, so they are added along with the devices in
+// spec mode. Like the process adjustments, they have to be in hookConfig.Allowed
+func AddPresetCapabilitiesToOciSpec(containerConfig *specs.Spec, hookConfig *Config) error {
+	if len(hookConfig.PresetCapabilities) == 0 {
+		return nil
+	}
+
+	if containerConfig.Process == nil {
+		return fmt.Errorf("container spec has no process")
+	}
+
+	if refused := refusedCapabilities(hookConfig.PresetCapabilities, hookConfig.Allowed.Capabilities); len(refused) != 0 {
+		err := fmt.Errorf("preset capabilities not allowed by hook config: %s", strings.Join(refused, ", "))
+		log.Printf("preset capabilities refused %s\n", err)
+		return err
+	}
+
+	addCapabilities(containerConfig.Process, hookConfig.PresetCapabilities)
+	return nil
+}
+
+// Method to check the process adjustments against hookConfig.Allowed
+// Dropping capabilities is always allowed
+func CheckProcessAdjustments(hookConfig *Config) error {
+	refused := refusedCapabilities(hookConfig.Capabilities, hookConfig.Allowed.Capabilities)
+
+	for _, rlimit := range hookConfig.Rlimits {
+		max, ok := hookConfig.Allowed.Rlimits[rlimit.Type]
+		if !ok || rlimit.Hard > max || rlimit.Soft > rlimit.Hard {
+			refused = append(refused, rlimit.Type)
+		}
+	}
+
+	for key := range hookConfig.Sysctls {
+		if !isSysctlAllowed(key, hookConfig.Allowed.Sysctls) {
+			refused = append(refused, key)
+		}
+	}
+
+	if len(refused) != 0 {
+		return fmt.Errorf("not allowed by hook config: %s", strings.Join(refused, ", "))
+	}
+	return nil
+}
+
+// Return the capabilities missing from the allowed capabilities
+func refusedCapabilities(capabilities []string, allowed []string) []string {
+	var refused []string
+	for _, capability := range capabilities {
+		capability = NormalizeCapability(capability)
+		found := false
+		for _, allowedCapability := range allowed {
+			if NormalizeCapability(allowedCapability) == capability {
+				found = true
+				break
+			}
+		}
+		if !found {
+			refused = append(refused, capability)
+		}
+	}
+	return refused
+}
+
+// Check if the sysctl key matches one of the allowed patterns
+// A pattern ending with '*' matches any key with the same prefix
+func isSysctlAllowed(key string, allowed []string) bool {
+	for _, pattern := range allowed {
+		if strings.HasSuffix(pattern, "*") {
+			if strings.HasPrefix(key, strings.TrimSuffix(pattern, "*")) {
+				return true
+			}
+		} else if key == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// Add the capabilities to the bounding, effective and permitted sets
+func addCapabilities(process *specs.Process, capabilities []string) {
+	if len(capabilities) == 0 {
+		return
+	}
+	if process.Capabilities == nil {
+		process.Capabilities = &specs.LinuxCapabilities{}
+	}
+
+	caps := process.Capabilities
+	for _, capability := range capabilities {
+		capability = NormalizeCapability(capability)
+		caps.Bounding = appendUnique(caps.Bounding, capability)
+		caps.Effective = appendUnique(caps.Effective, capability)
+		caps.Permitted = appendUnique(caps.Permitted, capability)
+	}
+
+	log.Printf("added capabilities %v\n", capabilities)
+}
+
+// Remove the capabilities from all the capability sets
+func dropCapabilities(process *specs.Process, capabilities []string) {
+	if len(capabilities) == 0 || process.Capabilities == nil {
+		return
+	}
+
+	caps := process.Capabilities
+	for _, capability := range capabilities {
+		capability = NormalizeCapability(capability)
+		caps.Bounding = removeString(caps.Bounding, capability)
+		caps.Effective = removeString(caps.Effective, capability)
+		caps.Inheritable = removeString(caps.Inheritable, capability)
+		caps.Permitted = removeString(caps.Permitted, capability)
+		caps.Ambient = removeString(caps.Ambient, capability)
+	}
+
+	log.Printf("dropped capabilities %v\n", capabilities)
+}
+
+// Set the rlimits, replacing any existing rlimit of the same type
+func setRlimits(process *specs.Process, rlimits []specs.POSIXRlimit) {
+	for _, rlimit := range rlimits {
+		replaced := false
+		for i := range process.Rlimits {
+			if process.Rlimits[i].Type == rlimit.Type {
+				process.Rlimits[i] = rlimit
+				replaced = true
+			}
+		}
+		if !replaced {
+			process.Rlimits = append(process.Rlimits, rlimit)
+		}
+	}
+
+	if len(rlimits) != 0 {
+		log.Printf("containerConfig.Process.Rlimits: %v\n", process.Rlimits)
+	}
+}
+
+// Convert a capability name to the CAP_XXX form used in the OCI spec
+func NormalizeCapability(capability string) string {
+	capability = strings.ToUpper(capability)
+	if !strings.HasPrefix(capability, "CAP_") {
+		capability = "CAP_" + capability
+	}
+	return capability
+}
+
+// Append s to list if it is not already present
+func appendUnique(list []string, s string) []string {
+	if containsString(list, s) {
+		return list
+	}
+	return append(list, s)
+}
+
+// Remove all occurrences of s from list
+func removeString(list []string, s string) []string {
+	result := list[:0]
+	for _, item := range list {
+		if item != s {
+			result = append(result, item)
+		}
+	}
+	return result
+}
@@ -0,0 +1,125 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func TestCheckProcessAdjustments(t *testing.T) {
+	allowed := Allowlist{
+		Capabilities: []string{"CAP_SYS_ADMIN", "net_admin"},
+		Rlimits:      map[string]uint64{"RLIMIT_MEMLOCK": 1024},
+		Sysctls:      []string{"net.ipv4.*", "kernel.msgmax"},
+	}
+
+	tests := []struct {
+		name       string
+		hookConfig Config
+		wantErr    bool
+	}{
+		{name: "nothing to adjust"},
+		{name: "allowed capability", hookConfig: Config{Capabilities: []string{"sys_admin", "CAP_NET_ADMIN"}}},
+		{name: "capability not allowed", hookConfig: Config{Capabilities: []string{"CAP_SYS_PTRACE"}}, wantErr: true},
+		{name: "drop is always allowed", hookConfig: Config{CapabilitiesDrop: []string{"CAP_SYS_PTRACE"}}},
+		{name: "allowed rlimit", hookConfig: Config{Rlimits: []specs.POSIXRlimit{{Type: "RLIMIT_MEMLOCK", Hard: 1024, Soft: 512}}}},
+		{name: "rlimit above the maximum", hookConfig: Config{Rlimits: []specs.POSIXRlimit{{Type: "RLIMIT_MEMLOCK", Hard: 2048, Soft: 512}}}, wantErr: true},
+		{name: "soft rlimit above hard", hookConfig: Config{Rlimits: []specs.POSIXRlimit{{Type: "RLIMIT_MEMLOCK", Hard: 512, Soft: 1024}}}, wantErr: true},
+		{name: "rlimit not allowed", hookConfig: Config{Rlimits: []specs.POSIXRlimit{{Type: "RLIMIT_NOFILE", Hard: 1, Soft: 1}}}, wantErr: true},
+		{name: "sysctl allowed by prefix", hookConfig: Config{Sysctls: map[string]string{"net.ipv4.ip_forward": "1"}}},
+		{name: "sysctl allowed", hookConfig: Config{Sysctls: map[string]string{"kernel.msgmax": "1"}}},
+		{name: "sysctl not allowed", hookConfig: Config{Sysctls: map[string]string{"kernel.msgmnb": "1"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hookConfig := tt.hookConfig
+			hookConfig.Allowed = allowed
+			if err := CheckProcessAdjustments(&hookConfig); (err != nil) != tt.wantErr {
+				t.Errorf("CheckProcessAdjustments() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAddProcessAdjustmentsToOciSpec(t *testing.T) {
+	hookConfig := &Config{
+		Capabilities:     []string{"sys_admin"},
+		CapabilitiesDrop: []string{"CAP_NET_RAW"},
+		Rlimits:          []specs.POSIXRlimit{{Type: "RLIMIT_MEMLOCK", Hard: 1024, Soft: 1024}},
+		Sysctls:          map[string]string{"net.ipv4.ip_forward": "1"},
+		Allowed: Allowlist{
+			Capabilities: []string{"CAP_SYS_ADMIN"},
+			Rlimits:      map[string]uint64{"RLIMIT_MEMLOCK": 1024},
+			Sysctls:      []string{"net.ipv4.*"},
+		},
+	}
+	containerConfig := &specs.Spec{
+		Process: &specs.Process{
+			Capabilities: &specs.LinuxCapabilities{
+				Bounding:  []string{"CAP_CHOWN", "CAP_NET_RAW"},
+				Effective: []string{"CAP_CHOWN", "CAP_NET_RAW"},
+				Permitted: []string{"CAP_CHOWN", "CAP_NET_RAW"},
+			},
+			Rlimits: []specs.POSIXRlimit{{Type: "RLIMIT_MEMLOCK", Hard: 64, Soft: 64}},
+		},
+	}
+
+	if err := AddProcessAdjustmentsToOciSpec(containerConfig, hookConfig); err != nil {
+		t.Fatalf("AddProcessAdjustmentsToOciSpec() error = %v", err)
+	}
+
+	wantCaps := []string{"CAP_CHOWN", "CAP_SYS_ADMIN"}
+	caps := containerConfig.Process.Capabilities
+	for name, set := range map[string][]string{"bounding": caps.Bounding, "effective": caps.Effective, "permitted": caps.Permitted} {
+		if !reflect.DeepEqual(set, wantCaps) {
+			t.Errorf("%s capabilities = %v, want %v", name, set, wantCaps)
+		}
+	}
+	if !reflect.DeepEqual(containerConfig.Process.Rlimits, hookConfig.Rlimits) {
+		t.Errorf("rlimits = %v, want %v", containerConfig.Process.Rlimits, hookConfig.Rlimits)
+	}
+	if containerConfig.Linux.Sysctl["net.ipv4.ip_forward"] != "1" {
+		t.Errorf("sysctls = %v", containerConfig.Linux.Sysctl)
+	}
+
+	// A refused adjustment leaves the spec untouched
+	hookConfig.Capabilities = []string{"CAP_SYS_PTRACE"}
+	containerConfig = &specs.Spec{Process: &specs.Process{}}
+	if err := AddProcessAdjustmentsToOciSpec(containerConfig, hookConfig); err == nil {
+		t.Fatalf("AddProcessAdjustmentsToOciSpec() error = nil, want a refused capability")
+	}
+	if !reflect.DeepEqual(containerConfig, &specs.Spec{Process: &specs.Process{}}) {
+		t.Errorf("refused adjustments changed the spec %+v", containerConfig)
+	}
+}
+
+func TestAddPresetCapabilitiesToOciSpec(t *testing.T) {
+	hookConfig := &Config{Presets: []string{"fuse"}}
+	if err := ExpandPresets(hookConfig); err != nil {
+		t.Fatal(err)
+	}
+
+	// The preset capabilities have to be in the allowlist
+	containerConfig := &specs.Spec{Process: &specs.Process{}}
+	if err := AddPresetCapabilitiesToOciSpec(containerConfig, hookConfig); err == nil {
+		t.Fatalf("AddPresetCapabilitiesToOciSpec() error = nil, want a refused capability")
+	}
+	if containerConfig.Process.Capabilities != nil {
+		t.Errorf("refused preset capabilities changed the spec %+v", containerConfig.Process.Capabilities)
+	}
+
+	hookConfig.Allowed.Capabilities = []string{"sys_admin"}
+	if err := AddPresetCapabilitiesToOciSpec(containerConfig, hookConfig); err != nil {
+		t.Fatalf("AddPresetCapabilitiesToOciSpec() error = %v", err)
+	}
+	if caps := containerConfig.Process.Capabilities; caps == nil || !reflect.DeepEqual(caps.Effective, []string{"CAP_SYS_ADMIN"}) {
+		t.Errorf("capabilities = %+v, want CAP_SYS_ADMIN", caps)
+	}
+
+	// They are not process adjustments
+	if err := CheckProcessAdjustments(hookConfig); err != nil {
+		t.Errorf("CheckProcessAdjustments() error = %v", err)
+	}
+}
@@ -0,0 +1,244 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/kata-hooks/hookutil"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+// CDI spec version written by the hook
+// https://github.com/cncf-tags/container-device-interface/blob/main/SPEC.md
+const CDIVersion = "0.5.0"
+
+// Default CDI kind (vendor/class) used for the generated spec
+const DefaultCDIKind = "kata-hooks.io/generic"
+
+// CDI device names must be alphanumeric and may contain '_', '.', ':' and '-'
+var cdiDeviceNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.:-]*$`)
+
+// Create a struct to hold a CDI spec
+// Only the fields the hook can generate are described here
+type CDISpec struct {
+	Version string      `json:"cdiVersion"`
+	Kind    string      `json:"kind"`
+	Devices []CDIDevice `json:"devices"`
+}
+
+// Create a struct to hold a CDI device
+type CDIDevice struct {
+	Name           string            `json:"name"`
+	ContainerEdits CDIContainerEdits `json:"containerEdits"`
+}
+
+// Create a struct to hold the CDI container edits of a device
+type CDIContainerEdits struct {
+	Env         []string        `json:"env,omitempty"`
+	DeviceNodes []CDIDeviceNode `json:"deviceNodes,omitempty"`
+	Mounts      []CDIMount      `json:"mounts,omitempty"`
+}
+
+// Create a struct to hold a CDI device node
+type CDIDeviceNode struct {
+	Path        string       `json:"path"`
+	Type        string       `json:"type,omitempty"`
+	Major       int64        `json:"major"`
+	Minor       int64        `json:"minor"`
+	FileMode    *os.FileMode `json:"fileMode,omitempty"`
+	Permissions string       `json:"permissions,omitempty"`
+	UID         *uint32      `json:"uid,omitempty"`
+	GID         *uint32      `json:"gid,omitempty"`
+}
+
+// Create a struct to hold a CDI mount
+type CDIMount struct {
+	HostPath      string   `json:"hostPath"`
+	ContainerPath string   `json:"containerPath"`
+	Type          string   `json:"type,omitempty"`
+	Options       []string `json:"options,omitempty"`
+}
+
+// Method to generate a CDI spec from the hookConfig, with one CDI device per profile
+// Each preset of hookConfig.Presets is a profile and becomes a CDI device named after
+// the preset. The devices, mounts and env of the hookConfig itself are the profile
+// named deviceName, left out when it is empty and presets are listed.
+// The env is rendered without a container ID. Dirs and files have no CDI equivalent
+// and are skipped
+func GenerateCDISpec(hookConfig *Config, kind string, deviceName string) (*CDISpec, error) {
+
+	if kind == "" {
+		kind = DefaultCDIKind
+	}
+
+	if !cdiDeviceNameRegexp.MatchString(deviceName) {
+		return nil, fmt.Errorf("invalid CDI device name %q", deviceName)
+	}
+
+	log.Printf("Generating CDI spec kind %s device %s presets %v\n", kind, deviceName, hookConfig.Presets)
+
+	// The presets were expanded into the hookConfig, keep their entries out of its profile
+	var presetDevices []CDIDevice
+	var presetDevicePaths, presetMountDestinations []string
+	for _, name := range hookConfig.Presets {
+		if name == deviceName {
+			return nil, fmt.Errorf("CDI device name %q is also a preset", deviceName)
+		}
+		preset, err := expandPreset(name)
+		if err != nil {
+			return nil, err
+		}
+		edits, err := cdiContainerEdits(preset.Devices, preset.Mounts)
+		if err != nil {
+			return nil, err
+		}
+		presetDevices = append(presetDevices, CDIDevice{Name: name, ContainerEdits: edits})
+
+		for _, device := range preset.Devices {
+			presetDevicePaths = append(presetDevicePaths, device.Path)
+		}
+		for _, mount := range preset.Mounts {
+			presetMountDestinations = append(presetMountDestinations, mount.Destination)
+		}
+	}
+
+	var devices []specs.LinuxDevice
+	for _, device := range hookConfig.Devices {
+		if !containsString(presetDevicePaths, device.Path) {
+			devices = append(devices, device)
+		}
+	}
+	var mounts []specs.Mount
+	for _, mount := range hookConfig.Mounts {
+		if !containsString(presetMountDestinations, mount.Destination) {
+			mounts = append(mounts, mount)
+		}
+	}
+	edits, err := cdiContainerEdits(devices, mounts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Render the env with the facts known without a container
+	if len(hookConfig.Env) != 0 {
+		facts := NewFacts("", hookConfig, ActivateMounts|ActivateDevices)
+		env, err := hookutil.RenderEnv(hookConfig.Env, facts)
+		if err != nil {
+			return nil, err
+		}
+		edits.Env = env
+	}
+
+	if len(hookConfig.Dirs) != 0 || len(hookConfig.Files) != 0 {
+		log.Printf("dirs and files cannot be expressed in CDI and are skipped\n")
+	}
+
+	cdiSpec := &CDISpec{
+		Version: CDIVersion,
+		Kind:    kind,
+	}
+	if len(presetDevices) == 0 || len(edits.DeviceNodes) != 0 || len(edits.Mounts) != 0 || len(edits.Env) != 0 {
+		cdiSpec.Devices = append(cdiSpec.Devices, CDIDevice{Name: deviceName, ContainerEdits: edits})
+	}
+	cdiSpec.Devices = append(cdiSpec.Devices, presetDevices...)
+
+	return cdiSpec, nil
+}
+
+// Convert devices and mounts to CDI container edits
+func cdiContainerEdits(devices []specs.LinuxDevice, mounts []specs.Mount) (CDIContainerEdits, error) {
+	var edits CDIContainerEdits
+
+	// Loop through the devices
+	for _, device := range devices {
+		node, err := cdiDeviceNode(device)
+		if err != nil {
+			log.Printf("unable to describe device %s %s\n", device.Path, err)
+			return edits, err
+		}
+		edits.DeviceNodes = append(edits.DeviceNodes, node)
+	}
+
+	// Loop through the mounts
+	for _, mount := range mounts {
+		edits.Mounts = append(edits.Mounts, CDIMount{
+			HostPath:      mount.Source,
+			ContainerPath: mount.Destination,
+			Type:          mount.Type,
+			Options:       mount.Options,
+		})
+	}
+
+	return edits, nil
+}
+
+// Convert a specs.LinuxDevice to a CDI device node
+// A character or block device without a major and minor gets the ones of the host
+// device node at the same path, as runtimes cannot create it otherwise
+func cdiDeviceNode(device specs.LinuxDevice) (CDIDeviceNode, error) {
+	node := CDIDeviceNode{
+		Path:        device.Path,
+		Type:        device.Type,
+		Major:       device.Major,
+		Minor:       device.Minor,
+		FileMode:    device.FileMode,
+		Permissions: "rwm",
+		UID:         device.UID,
+		GID:         device.GID,
+	}
+
+	if (node.Type == "c" || node.Type == "b" || node.Type == "u") && node.Major == 0 && node.Minor == 0 {
+		major, minor, err := hostDeviceNumbers(device.Path, node.Type)
+		if err != nil {
+			return CDIDeviceNode{}, err
+		}
+		node.Major, node.Minor = major, minor
+	}
+
+	return node, nil
+}
+
+// Read the major and minor of the host device node at path, of the given type
+func hostDeviceNumbers(path string, deviceType string) (int64, int64, error) {
+	var stat unix.Stat_t
+	if err := unix.Stat(path, &stat); err != nil {
+		return 0, 0, fmt.Errorf("no major and minor for %s and unable to stat it: %w", path, err)
+	}
+
+	want := uint32(unix.S_IFCHR)
+	if deviceType == "b" {
+		want = unix.S_IFBLK
+	}
+	if stat.Mode&unix.S_IFMT != want {
+		return 0, 0, fmt.Errorf("%s is not a device of type %s", path, deviceType)
+	}
+
+	return int64(unix.Major(uint64(stat.Rdev))), int64(unix.Minor(uint64(stat.Rdev))), nil
+}
+
+// Write the CDI spec to a file
+// If cdiSpecPath is empty, the spec is written to stdout
+func WriteCDISpec(cdiSpecPath string, cdiSpec *CDISpec) error {
+	cdiSpecData, err := json.MarshalIndent(cdiSpec, "", "  ")
+	if err != nil {
+		log.Printf("unable to marshal CDI spec %s\n", err)
+		return err
+	}
+	cdiSpecData = append(cdiSpecData, '\n')
+
+	if cdiSpecPath == "" {
+		_, err = os.Stdout.Write(cdiSpecData)
+		return err
+	}
+
+	err = os.WriteFile(cdiSpecPath, cdiSpecData, 0644)
+	if err != nil {
+		log.Printf("unable to write CDI spec %s\n", err)
+		return err
+	}
+	log.Printf("CDI spec written to %s\n", cdiSpecPath)
+	return nil
+}
@@ -0,0 +1,133 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func TestGenerateCDISpec(t *testing.T) {
+	hookConfig := &Config{
+		Devices: []specs.LinuxDevice{
+			{Path: "/dev/fuse", Type: "c", Major: 10, Minor: 229},
+			{Path: "/dev/null", Type: "c"},
+		},
+		Mounts: []specs.Mount{
+			{Destination: "/data", Type: "bind", Source: "/srv/data", Options: []string{"rbind", "ro"}},
+		},
+		Dirs: []Dir{{Path: "/skipped"}},
+		Env:  map[string]string{"HOOK_DEVICES": "{{ join .Devices \",\" }}"},
+	}
+
+	cdiSpec, err := GenerateCDISpec(hookConfig, "", "fuse")
+	if err != nil {
+		t.Fatalf("GenerateCDISpec() error = %v", err)
+	}
+	if cdiSpec.Version != CDIVersion || cdiSpec.Kind != DefaultCDIKind || len(cdiSpec.Devices) != 1 {
+		t.Fatalf("GenerateCDISpec() = %+v", cdiSpec)
+	}
+
+	device := cdiSpec.Devices[0]
+	if device.Name != "fuse" {
+		t.Errorf("device name = %q, want fuse", device.Name)
+	}
+	wantNodes := []CDIDeviceNode{
+		{Path: "/dev/fuse", Type: "c", Major: 10, Minor: 229, Permissions: "rwm"},
+		{Path: "/dev/null", Type: "c", Major: 1, Minor: 3, Permissions: "rwm"},
+	}
+	if !reflect.DeepEqual(device.ContainerEdits.DeviceNodes, wantNodes) {
+		t.Errorf("device nodes = %+v, want %+v", device.ContainerEdits.DeviceNodes, wantNodes)
+	}
+	wantMounts := []CDIMount{{HostPath: "/srv/data", ContainerPath: "/data", Type: "bind", Options: []string{"rbind", "ro"}}}
+	if !reflect.DeepEqual(device.ContainerEdits.Mounts, wantMounts) {
+		t.Errorf("mounts = %+v, want %+v", device.ContainerEdits.Mounts, wantMounts)
+	}
+	wantEnv := []string{"HOOK_DEVICES=/dev/fuse,/dev/null"}
+	if !reflect.DeepEqual(device.ContainerEdits.Env, wantEnv) {
+		t.Errorf("env = %v, want %v", device.ContainerEdits.Env, wantEnv)
+	}
+}
+
+func TestGenerateCDISpecProfiles(t *testing.T) {
+	tests := []struct {
+		name        string
+		hookConfig  Config
+		wantDevices []string
+		wantNodes   map[string][]string
+	}{
+		{
+			name:        "one device per preset",
+			hookConfig:  Config{Presets: []string{"fuse", "kvm"}},
+			wantDevices: []string{"fuse", "kvm"},
+			wantNodes:   map[string][]string{"fuse": {"/dev/fuse"}, "kvm": {"/dev/kvm"}},
+		},
+		{
+			name: "hook config entries outside the presets",
+			hookConfig: Config{
+				Presets: []string{"tun"},
+				Devices: []specs.LinuxDevice{{Path: "/dev/null", Type: "c", Major: 1, Minor: 3}},
+				Mounts:  []specs.Mount{{Destination: "/data", Type: "bind", Source: "/srv/data"}},
+			},
+			wantDevices: []string{"default", "tun"},
+			wantNodes:   map[string][]string{"default": {"/dev/null"}, "tun": {"/dev/net/tun"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hookConfig := tt.hookConfig
+			if err := ExpandPresets(&hookConfig); err != nil {
+				t.Fatal(err)
+			}
+			cdiSpec, err := GenerateCDISpec(&hookConfig, "", "default")
+			if err != nil {
+				t.Fatalf("GenerateCDISpec() error = %v", err)
+			}
+
+			var names []string
+			nodes := make(map[string][]string)
+			for _, device := range cdiSpec.Devices {
+				names = append(names, device.Name)
+				for _, node := range device.ContainerEdits.DeviceNodes {
+					nodes[device.Name] = append(nodes[device.Name], node.Path)
+				}
+			}
+			if !reflect.DeepEqual(names, tt.wantDevices) {
+				t.Errorf("CDI devices = %v, want %v", names, tt.wantDevices)
+			}
+			if !reflect.DeepEqual(nodes, tt.wantNodes) {
+				t.Errorf("device nodes = %v, want %v", nodes, tt.wantNodes)
+			}
+		})
+	}
+
+	// The device name of the hook config can't be a preset name
+	hookConfig := Config{Presets: []string{"fuse"}}
+	if _, err := GenerateCDISpec(&hookConfig, "", "fuse"); err == nil {
+		t.Errorf("GenerateCDISpec() error = nil, want a name conflict")
+	}
+}
+
+func TestGenerateCDISpecErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		deviceName string
+		devices    []specs.LinuxDevice
+	}{
+		{name: "invalid device name", deviceName: "-fuse"},
+		{name: "device name with a slash", deviceName: "a/b"},
+		{name: "missing device node", deviceName: "dev", devices: []specs.LinuxDevice{{Path: "/dev/does-not-exist", Type: "c"}}},
+		{name: "not a character device", deviceName: "dev", devices: []specs.LinuxDevice{{Path: "/dev", Type: "c"}}},
+		{name: "not a block device", deviceName: "dev", devices: []specs.LinuxDevice{{Path: "/dev/null", Type: "b"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := GenerateCDISpec(&Config{Devices: tt.devices}, "", tt.deviceName)
+			if err == nil {
+				t.Errorf("GenerateCDISpec() error = nil, want an error")
+			}
+		})
+	}
+}
@@ -2,10 +2,12 @@ package internal
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/fs"
 	"os"
 	"strings"
 
+	"github.com/kata-hooks/hookutil"
 	"github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/sirupsen/logrus"
 )
@@ -36,6 +38,16 @@ type Config struct {
 	ActivationFlagDirs    string `json:"activation_flag_dirs"`
 	ActivationFlagMounts  string `json:"activation_flag_mounts"`
 	ActivationFlagDevices string `json:"activation_flag_devices"`
+	ActivationFlagProcess string `json:"activation_flag_process,omitempty"`
+	ActivationFlagEnv     string `json:"activation_flag_env,omitempty"`
+	ActivationFlagMask    string `json:"activation_flag_mask,omitempty"`
+
+	// Mode decides how mounts and devices are added to the container
+	// "direct" (default) creates them in the container rootfs
+	// "spec" adds them to the container config.json
+	// "inject" mounts the mounts in the container mount namespace, for a hook run
+	// after pivot_root, eg. at poststart. Devices are created as in direct mode
+	Mode string `json:"mode,omitempty"`
 
 	// Example devices
 	/*
@@ -81,8 +93,94 @@ type Config struct {
 		   },
 	*/
 	Mounts []specs.Mount `json:"mounts"`
+
+	// Built-in presets to expand into devices, device cgroup rules,
+	// capabilities and mounts. Eg. ["fuse", "kvm", "tun"]
+	Presets []string `json:"presets,omitempty"`
+
+	// Device cgroup rules to add to the container spec along with the devices, in spec mode
+	DeviceRules []specs.LinuxDeviceCgroup `json:"device_rules,omitempty"`
+
+	// Capabilities of the presets, added to the container process along with the
+	// devices when Allowed has them. Only set by ExpandPresets
+	PresetCapabilities []string `json:"preset_capabilities,omitempty"`
+
+	// Process adjustments, applied in spec mode when ActivationFlagProcess is present
+	// Capabilities to add to and drop from the container process. Eg. ["CAP_SYS_ADMIN"]
+	Capabilities     []string `json:"capabilities,omitempty"`
+	CapabilitiesDrop []string `json:"capabilities_drop,omitempty"`
+
+	// Example rlimits
+	/*
+		[
+			{
+				"type": "RLIMIT_MEMLOCK",
+				"hard": 18446744073709551615,
+				"soft": 18446744073709551615
+			}
+		]
+	*/
+	Rlimits []specs.POSIXRlimit `json:"rlimits,omitempty"`
+
+	// Namespaced sysctls. Eg. {"net.ipv4.ip_forward": "1"}
+	Sysctls map[string]string `json:"sysctls,omitempty"`
+
+	// What the process adjustments and the preset capabilities may grant
+	// Capabilities, rlimits and sysctls not covered here are refused
+	Allowed Allowlist `json:"allowed"`
+
+	// Env to add to the container process when ActivationFlagEnv is present, in spec
+	// mode. In the other modes the workload reads the facts file, see FactsFile
+	// Values are templates rendered with the hook facts
+	// Eg. {"HOOK_MOUNTS": "{{ join .Mounts \":\" }}"}
+	Env map[string]string `json:"env,omitempty"`
+
+	// Path of the facts file in the container rootfs. Eg. /run/kata-hooks/facts.json
+	// The facts file is written when ActivationFlagEnv is present
+	FactsFile string `json:"facts_file,omitempty"`
+
+	// Paths in the container to hide and to make read-only. Eg. ["/proc/kcore"]
+	// They are applied with any activation granting mounts, devices or process
+	// adjustments, or on their own when ActivationFlagMask is present. They are
+	// masked in the container rootfs, added to the container config in spec mode,
+	// or masked in the container mount namespace in inject mode
+	MaskedPaths   []string `json:"masked_paths,omitempty"`
+	ReadonlyPaths []string `json:"readonly_paths,omitempty"`
+}
+
+// Create a struct to hold what the process adjustments may grant
+type Allowlist struct {
+	// Capabilities that may be added
+	Capabilities []string `json:"capabilities,omitempty"`
+	// Maximum hard limit per rlimit type. Eg. {"RLIMIT_MEMLOCK": 18446744073709551615}
+	Rlimits map[string]uint64 `json:"rlimits,omitempty"`
+	// Sysctls that may be set. A trailing '*' matches any suffix. Eg. ["net.ipv4.*"]
+	Sysctls []string `json:"sysctls,omitempty"`
 }
 
+// Modes of adding mounts and devices to the container
+const (
+	ModeDirect = "direct"
+	ModeSpec   = "spec"
+	ModeInject = "inject"
+)
+
+// Bits of the activation flags bit mask
+const (
+	ActivateAll = 1 << iota
+	ActivateFiles
+	ActivateDirs
+	ActivateMounts
+	ActivateDevices
+	ActivateProcess
+	ActivateEnv
+	ActivateMask
+)
+
+// Bits set by ActivationFlagAll
+const activateEverything = ActivateAll | ActivateFiles | ActivateDirs | ActivateMounts |
+	ActivateDevices | ActivateProcess | ActivateEnv | ActivateMask
+
 // Create a struct to hold the directory configuration
 type Dir struct {
 	Path string `json:"path"`
@@ -120,6 +218,24 @@ func ReadConfig(configFile string) (*Config, error) {
 		return nil, err
 	}
 
+	// Default to direct mode
+	switch config.Mode {
+	case "":
+		config.Mode = ModeDirect
+	case ModeDirect, ModeSpec, ModeInject:
+	default:
+		log.Printf("invalid mode %s\n", config.Mode)
+		return nil, fmt.Errorf("invalid mode %q, valid modes are %s, %s and %s", config.Mode, ModeDirect, ModeSpec, ModeInject)
+	}
+
+	// Expand the presets, which alone set the preset capabilities
+	config.PresetCapabilities = nil
+	err = ExpandPresets(&config)
+	if err != nil {
+		log.Printf("unable to expand presets %s\n", err)
+		return nil, err
+	}
+
 	// Return the configuration
 	return &config, nil
 }
@@ -127,59 +243,57 @@ func ReadConfig(configFile string) (*Config, error) {
 // Set the logger
 func SetLogger(logger *logrus.Logger) {
 	log = logger
+	hookutil.SetLogger(logger)
 }
 
 // Method to check which all activation flags are present in the env string slice
 // Return a bit mask with the flags that are present set to 1
-func GetActivationFlags(env []string, activationFlagAll string, activationFlagFiles string, activationFlagDirs string,
-	activationFlagMounts string, activationFlagDevices string) int {
+// ActivationFlagAll sets the bits of all the activation flags
+func GetActivationFlags(env []string, hookConfig *Config) int {
 
-	log.Printf("Searching for activation flags %s, %s, %s, %s, %s\n", activationFlagAll, activationFlagFiles,
-		activationFlagDirs, activationFlagMounts, activationFlagDevices)
-	var activationFlags int = 0
-	if IsActivationFlagPresent(env, activationFlagAll) {
-		activationFlags = activationFlags | 1
-	}
-	if IsActivationFlagPresent(env, activationFlagFiles) {
-		activationFlags = activationFlags | 2
-	}
-	if IsActivationFlagPresent(env, activationFlagDirs) {
-		activationFlags = activationFlags | 4
-	}
-	if IsActivationFlagPresent(env, activationFlagMounts) {
-		activationFlags = activationFlags | 8
-	}
-	if IsActivationFlagPresent(env, activationFlagDevices) {
-		activationFlags = activationFlags | 16
+	flags := []struct {
+		name string
+		bit  int
+	}{
+		{hookConfig.ActivationFlagAll, ActivateAll},
+		{hookConfig.ActivationFlagFiles, ActivateFiles},
+		{hookConfig.ActivationFlagDirs, ActivateDirs},
+		{hookConfig.ActivationFlagMounts, ActivateMounts},
+		{hookConfig.ActivationFlagDevices, ActivateDevices},
+		{hookConfig.ActivationFlagProcess, ActivateProcess},
+		{hookConfig.ActivationFlagEnv, ActivateEnv},
+		{hookConfig.ActivationFlagMask, ActivateMask},
 	}
 
-	// Print which activation flags are present
-	if activationFlags&1 == 1 {
-		log.Printf("Activation flag %s is present\n", activationFlagAll)
-	}
-	if activationFlags&2 == 2 {
-		log.Printf("Activation flag %s is present\n", activationFlagFiles)
-	}
-	if activationFlags&4 == 4 {
-		log.Printf("Activation flag %s is present\n", activationFlagDirs)
-	}
-	if activationFlags&8 == 8 {
-		log.Printf("Activation flag %s is present\n", activationFlagMounts)
+	var activationFlags int = 0
+	for _, flag := range flags {
+		// An unset activation flag never activates
+		if flag.name == "" {
+			continue
+		}
+		if IsActivationFlagPresent(env, flag.name) {
+			activationFlags = activationFlags | flag.bit
+		}
 	}
-	if activationFlags&16 == 16 {
-		log.Printf("Activation flag %s is present\n", activationFlagDevices)
+
+	if activationFlags&ActivateAll != 0 {
+		activationFlags = activationFlags | activateEverything
 	}
 
 	return activationFlags
 }
 
 // Method to check if ActivationFlag is present in a slice of strings
+// An empty activation flag is never present
 func IsActivationFlagPresent(env []string, activationFlag string) bool {
 	log.Printf("Searching for activation flag %s\n", activationFlag)
+	if activationFlag == "" {
+		return false
+	}
 	for _, val := range env {
 		// env strings are of the form key=value
 		// Match key with activationFlag
-		if strings.Contains(val, activationFlag) {
+		if strings.SplitN(val, "=", 2)[0] == activationFlag {
 			log.Printf("Activation flag %s is present\n", activationFlag)
 			return true
 		}
@@ -0,0 +1,45 @@
+package internal
+
+import (
+	"testing"
+)
+
+func TestGetActivationFlags(t *testing.T) {
+	hookConfig := &Config{
+		ActivationFlagAll:     "HOOK_ALL",
+		ActivationFlagMounts:  "HOOK_MOUNTS",
+		ActivationFlagDevices: "HOOK_DEVICES",
+		ActivationFlagEnv:     "HOOK_ENV",
+	}
+
+	tests := []struct {
+		name string
+		env  []string
+		want int
+	}{
+		{name: "no env"},
+		{name: "no activation flag", env: []string{"PATH=/usr/bin", "HOME=/root"}},
+		{name: "one activation flag", env: []string{"PATH=/usr/bin", "HOOK_MOUNTS=true"}, want: ActivateMounts},
+		{
+			name: "several activation flags",
+			env:  []string{"HOOK_DEVICES=true", "HOOK_ENV=1", "HOOK_MOUNTS=yes"},
+			want: ActivateMounts | ActivateDevices | ActivateEnv,
+		},
+		{
+			name: "all activation flags",
+			env:  []string{"HOOK_ALL=true"},
+			want: ActivateAll | ActivateFiles | ActivateDirs | ActivateMounts | ActivateDevices | ActivateProcess | ActivateEnv | ActivateMask,
+		},
+		{name: "activation flag in another key or value", env: []string{"HOOK_MOUNTS_OFF=true", "NAME=HOOK_ENV"}},
+		// The unset flags, eg. ActivationFlagProcess, never activate
+		{name: "unset activation flags", env: []string{"HOOK_PROCESS=true", "=", ""}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := GetActivationFlags(tt.env, hookConfig); got != tt.want {
+				t.Errorf("GetActivationFlags() = %b, want %b", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package internal
+
+// Name of the hook in the facts file
+const FactsHookName = "generic-hook"
+
+// Create a struct to hold what the hook discovered for a container
+// The facts are available to the env templates, eg. "{{ join .Mounts \":\" }}",
+// and are written to the facts file
+type Facts struct {
+	ContainerID string   `json:"container_id"`
+	Mounts      []string `json:"mounts,omitempty"`
+	Devices     []string `json:"devices,omitempty"`
+}
+
+// Method to collect the facts of the activated mounts and devices
+func NewFacts(containerID string, hookConfig *Config, activationFlags int) Facts {
+	facts := Facts{ContainerID: containerID}
+
+	if activationFlags&ActivateMounts != 0 {
+		for _, mount := range hookConfig.Mounts {
+			facts.Mounts = append(facts.Mounts, mount.Destination)
+		}
+	}
+	if activationFlags&ActivateDevices != 0 {
+		for _, device := range hookConfig.Devices {
+			facts.Devices = append(facts.Devices, device.Path)
+		}
+	}
+
+	return facts
+}
@@ -0,0 +1,38 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func TestNewFacts(t *testing.T) {
+	hookConfig := &Config{
+		Mounts:  []specs.Mount{{Destination: "/data"}, {Destination: "/cache"}},
+		Devices: []specs.LinuxDevice{{Path: "/dev/fuse"}},
+	}
+
+	tests := []struct {
+		name            string
+		activationFlags int
+		want            Facts
+	}{
+		{name: "nothing activated", want: Facts{ContainerID: "abc"}},
+		{name: "mounts", activationFlags: ActivateMounts, want: Facts{ContainerID: "abc", Mounts: []string{"/data", "/cache"}}},
+		{name: "devices", activationFlags: ActivateDevices | ActivateEnv, want: Facts{ContainerID: "abc", Devices: []string{"/dev/fuse"}}},
+		{
+			name:            "mounts and devices",
+			activationFlags: ActivateMounts | ActivateDevices,
+			want:            Facts{ContainerID: "abc", Mounts: []string{"/data", "/cache"}, Devices: []string{"/dev/fuse"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewFacts("abc", hookConfig, tt.activationFlags); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewFacts() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,17 @@
+package internal
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Set a logger for the package, discarding the output
+func TestMain(m *testing.M) {
+	logger := logrus.New()
+	logger.Out = io.Discard
+	SetLogger(logger)
+	os.Exit(m.Run())
+}
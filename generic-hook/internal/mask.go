@@ -0,0 +1,170 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/kata-hooks/hookutil"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+// Method to add the hookConfig masked and read-only paths to the containerConfig
+// The runtime masks them once the container mounts, eg. procfs, are made
+func AddMaskedPathsToOciSpec(containerConfig *specs.Spec, hookConfig *Config) error {
+	if containerConfig.Linux == nil {
+		containerConfig.Linux = &specs.Linux{}
+	}
+
+	for _, path := range hookConfig.MaskedPaths {
+		containerConfig.Linux.MaskedPaths = appendUnique(containerConfig.Linux.MaskedPaths, path)
+	}
+	for _, path := range hookConfig.ReadonlyPaths {
+		containerConfig.Linux.ReadonlyPaths = appendUnique(containerConfig.Linux.ReadonlyPaths, path)
+	}
+
+	log.Printf("containerConfig.Linux.MaskedPaths: %v\n", containerConfig.Linux.MaskedPaths)
+	log.Printf("containerConfig.Linux.ReadonlyPaths: %v\n", containerConfig.Linux.ReadonlyPaths)
+	return nil
+}
+
+// Method to mask the hookConfig masked paths and make the read-only paths read-only in
+// the rootfs, for the direct mode where the hook runs after the runtime read the
+// container config. Files are masked by binding /dev/null over them and directories by
+// mounting an empty read-only tmpfs over them. Read-only paths are bound onto themselves
+// and made read-only with their submounts. The paths are resolved in the rootfs, so
+// symlinks can't escape it. Paths missing from the rootfs, eg. in procfs which is not
+// mounted yet, are skipped. Requires Linux 5.12
+func MaskPaths(rootfsPath string, hookConfig *Config) error {
+
+	log.Printf("Masking paths %v and making paths %v read-only in %s\n",
+		hookConfig.MaskedPaths, hookConfig.ReadonlyPaths, rootfsPath)
+
+	rootFd, err := unix.Open(rootfsPath, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		log.Printf("unable to open rootfs %s\n", err)
+		return err
+	}
+	defer unix.Close(rootFd)
+
+	return maskPathsInRoot(rootFd, hookConfig, func(fn func() error) error { return fn() })
+}
+
+// Method to mask the hookConfig masked paths and make the read-only paths read-only in
+// the mount namespace of the container process pid, for the inject mode where the
+// container config is no longer read. The paths are resolved in the container root,
+// see MaskPaths
+func InjectMaskedPaths(pid int, hookConfig *Config) error {
+
+	log.Printf("Masking paths %v and making paths %v read-only in the mount namespace of pid %d\n",
+		hookConfig.MaskedPaths, hookConfig.ReadonlyPaths, pid)
+
+	rootFd, err := unix.Open(fmt.Sprintf("/proc/%d/root", pid), unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		log.Printf("unable to open root of pid %d %s\n", pid, err)
+		return err
+	}
+	defer unix.Close(rootFd)
+
+	return maskPathsInRoot(rootFd, hookConfig, func(fn func() error) error {
+		return hookutil.InMountNamespace(pid, fn)
+	})
+}
+
+// Mask the hookConfig paths resolved in the root rootFd, with inNamespace running the
+// mount calls in the mount namespace of the root
+func maskPathsInRoot(rootFd int, hookConfig *Config, inNamespace func(fn func() error) error) error {
+	for _, path := range hookConfig.MaskedPaths {
+		err := withPathInRoot(rootFd, path, func(targetFd int, isDir bool) error {
+			if err := maskPath(inNamespace, targetFd, isDir); err != nil {
+				return err
+			}
+			log.Printf("masked %s\n", path)
+			return nil
+		})
+		if err != nil {
+			log.Printf("masking (%s) threw error (%s)\n", path, err)
+			return err
+		}
+	}
+
+	for _, path := range hookConfig.ReadonlyPaths {
+		err := withPathInRoot(rootFd, path, func(targetFd int, isDir bool) error {
+			if err := remountReadonly(inNamespace, targetFd); err != nil {
+				return err
+			}
+			log.Printf("remounted %s read-only\n", path)
+			return nil
+		})
+		if err != nil {
+			log.Printf("remounting (%s) read-only threw error (%s)\n", path, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Open path in the root rootFd and run fn with it, if it exists
+func withPathInRoot(rootFd int, path string, fn func(targetFd int, isDir bool) error) error {
+	how := &unix.OpenHow{
+		Flags:   unix.O_PATH | unix.O_CLOEXEC,
+		Resolve: unix.RESOLVE_IN_ROOT | unix.RESOLVE_NO_MAGICLINKS,
+	}
+	targetFd, err := unix.Openat2(rootFd, path, how)
+	if err == unix.ENOENT {
+		log.Printf("skipping missing path %s\n", path)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer unix.Close(targetFd)
+
+	var stat unix.Stat_t
+	if err := unix.Fstat(targetFd, &stat); err != nil {
+		return err
+	}
+	return fn(targetFd, stat.Mode&unix.S_IFMT == unix.S_IFDIR)
+}
+
+// Mount /dev/null or an empty read-only tmpfs over targetFd, see maskPathsInRoot
+func maskPath(inNamespace func(fn func() error) error, targetFd int, isDir bool) error {
+	var treeFd int
+	var err error
+	if isDir {
+		treeFd, err = stageMount(specs.Mount{
+			Source:  "tmpfs",
+			Type:    "tmpfs",
+			Options: []string{"ro", "nosuid", "nodev", "noexec"},
+		}, true)
+	} else {
+		treeFd, err = unix.OpenTree(unix.AT_FDCWD, os.DevNull, unix.OPEN_TREE_CLONE|unix.OPEN_TREE_CLOEXEC)
+	}
+	if err != nil {
+		return err
+	}
+	defer unix.Close(treeFd)
+
+	return inNamespace(func() error {
+		return unix.MoveMount(treeFd, "", targetFd, "", unix.MOVE_MOUNT_F_EMPTY_PATH|unix.MOVE_MOUNT_T_EMPTY_PATH)
+	})
+}
+
+// Bind targetFd onto itself with its submounts and make them all read-only, see
+// maskPathsInRoot
+func remountReadonly(inNamespace func(fn func() error) error, targetFd int) error {
+	return inNamespace(func() error {
+		treeFd, err := unix.OpenTree(targetFd, "", unix.OPEN_TREE_CLONE|unix.OPEN_TREE_CLOEXEC|unix.AT_EMPTY_PATH|unix.AT_RECURSIVE)
+		if err != nil {
+			return err
+		}
+		defer unix.Close(treeFd)
+
+		attr := &unix.MountAttr{Attr_set: unix.MOUNT_ATTR_RDONLY}
+		if err := unix.MountSetattr(treeFd, "", unix.AT_EMPTY_PATH|unix.AT_RECURSIVE, attr); err != nil {
+			return err
+		}
+		return unix.MoveMount(treeFd, "", targetFd, "", unix.MOVE_MOUNT_F_EMPTY_PATH|unix.MOVE_MOUNT_T_EMPTY_PATH)
+	})
+}
@@ -0,0 +1,183 @@
+package internal
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+func TestAddMaskedPathsToOciSpec(t *testing.T) {
+	hookConfig := &Config{
+		MaskedPaths:   []string{"/proc/kcore", "/sys/firmware"},
+		ReadonlyPaths: []string{"/proc/sys"},
+	}
+
+	tests := []struct {
+		name             string
+		linux            *specs.Linux
+		wantMasked       []string
+		wantReadonlyPath []string
+	}{
+		{
+			name:             "no linux section",
+			wantMasked:       []string{"/proc/kcore", "/sys/firmware"},
+			wantReadonlyPath: []string{"/proc/sys"},
+		},
+		{
+			name:             "paths already present are kept once",
+			linux:            &specs.Linux{MaskedPaths: []string{"/proc/kcore", "/proc/keys"}, ReadonlyPaths: []string{"/proc/bus"}},
+			wantMasked:       []string{"/proc/kcore", "/proc/keys", "/sys/firmware"},
+			wantReadonlyPath: []string{"/proc/bus", "/proc/sys"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			containerConfig := &specs.Spec{Linux: tt.linux}
+			if err := AddMaskedPathsToOciSpec(containerConfig, hookConfig); err != nil {
+				t.Fatalf("AddMaskedPathsToOciSpec() error = %v", err)
+			}
+			if !reflect.DeepEqual(containerConfig.Linux.MaskedPaths, tt.wantMasked) {
+				t.Errorf("masked paths = %v, want %v", containerConfig.Linux.MaskedPaths, tt.wantMasked)
+			}
+			if !reflect.DeepEqual(containerConfig.Linux.ReadonlyPaths, tt.wantReadonlyPath) {
+				t.Errorf("read-only paths = %v, want %v", containerConfig.Linux.ReadonlyPaths, tt.wantReadonlyPath)
+			}
+		})
+	}
+}
+
+func TestMaskPaths(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("requires root")
+	}
+
+	rootfs := t.TempDir()
+	outside := t.TempDir()
+	for _, path := range []string{"secretdir", "readonly"} {
+		if err := os.MkdirAll(filepath.Join(rootfs, path), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, path := range []string{filepath.Join(rootfs, "secret"), filepath.Join(rootfs, "secretdir/key"), filepath.Join(outside, "secret")} {
+		if err := os.WriteFile(path, []byte("secret"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Resolved in the rootfs, not on the host
+	if err := os.Symlink(outside, filepath.Join(rootfs, "escape")); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		for _, path := range []string{"secret", "secretdir", "readonly"} {
+			for unix.Unmount(filepath.Join(rootfs, path), unix.MNT_DETACH) == nil {
+			}
+		}
+	})
+
+	hookConfig := &Config{
+		MaskedPaths:   []string{"/secret", "/secretdir", "/missing", "/escape/secret"},
+		ReadonlyPaths: []string{"/readonly"},
+	}
+	if err := MaskPaths(rootfs, hookConfig); err != nil {
+		t.Fatalf("MaskPaths() error = %v", err)
+	}
+
+	if data, err := os.ReadFile(filepath.Join(rootfs, "secret")); err != nil || len(data) != 0 {
+		t.Errorf("masked file = %q, %v, want empty", data, err)
+	}
+	if entries, err := os.ReadDir(filepath.Join(rootfs, "secretdir")); err != nil || len(entries) != 0 {
+		t.Errorf("masked directory = %v, %v, want empty", entries, err)
+	}
+	if err := os.WriteFile(filepath.Join(rootfs, "readonly/file"), nil, 0644); !errors.Is(err, syscall.EROFS) {
+		t.Errorf("writing readonly/file error = %v, want read-only", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(outside, "secret")); string(data) != "secret" {
+		t.Errorf("file outside the rootfs = %q, want secret", data)
+	}
+}
+
+// Start a process in a private mount namespace with a tmpfs mounted on dir/readonly/sub
+// Returns its pid once the tmpfs is mounted
+func startInMountNamespace(t *testing.T, dir string) int {
+	script := `mount -t tmpfs tmpfs "$1/readonly/sub" && touch "$1/ready" && exec sleep 60`
+	cmd := exec.Command("unshare", "--mount", "--propagation", "private", "sh", "-c", script, "sh", dir)
+	if err := cmd.Start(); err != nil {
+		t.Skipf("unable to start a process in a mount namespace %s", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat(filepath.Join(dir, "ready")); err == nil {
+			return cmd.Process.Pid
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Skip("no tmpfs mounted in the mount namespace")
+	return 0
+}
+
+func TestInjectMaskedPaths(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("requires root")
+	}
+
+	dir := t.TempDir()
+	for _, path := range []string{"secretdir", "readonly/sub"} {
+		if err := os.MkdirAll(filepath.Join(dir, path), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, path := range []string{"secret", "secretdir/key"} {
+		if err := os.WriteFile(filepath.Join(dir, path), []byte("secret"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pid := startInMountNamespace(t, dir)
+	hookConfig := &Config{
+		MaskedPaths:   []string{filepath.Join(dir, "secret"), filepath.Join(dir, "secretdir"), filepath.Join(dir, "missing")},
+		ReadonlyPaths: []string{filepath.Join(dir, "readonly")},
+	}
+	if err := InjectMaskedPaths(pid, hookConfig); err != nil {
+		t.Fatalf("InjectMaskedPaths() error = %v", err)
+	}
+
+	// Seen from the container
+	inContainer := func(path string) string {
+		return filepath.Join("/proc", strconv.Itoa(pid), "root", dir, path)
+	}
+	if data, err := os.ReadFile(inContainer("secret")); err != nil || len(data) != 0 {
+		t.Errorf("masked file = %q, %v, want empty", data, err)
+	}
+	if entries, err := os.ReadDir(inContainer("secretdir")); err != nil || len(entries) != 0 {
+		t.Errorf("masked directory = %v, %v, want empty", entries, err)
+	}
+	for _, path := range []string{"readonly/file", "readonly/sub/file"} {
+		err := os.WriteFile(inContainer(path), nil, 0644)
+		if !errors.Is(err, syscall.EROFS) {
+			t.Errorf("writing %s error = %v, want read-only", path, err)
+		}
+	}
+
+	// The host is unchanged
+	if data, _ := os.ReadFile(filepath.Join(dir, "secret")); string(data) != "secret" {
+		t.Errorf("host file = %q, want secret", data)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "readonly/file"), nil, 0644); err != nil {
+		t.Errorf("host read-only path is not writable %s", err)
+	}
+}
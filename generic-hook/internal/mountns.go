@@ -0,0 +1,91 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/kata-hooks/hookutil"
+	sysmount "github.com/moby/sys/mount"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+// Method to mount the hookConfig mounts in the mount namespace of the container process pid
+// Unlike CreateMounts in the container rootfs, this works after pivot_root and with a private
+// rootfs propagation, eg. from a poststart hook. Each mount is made in a private mount namespace
+// and moved into the container with open_tree and move_mount, which requires Linux 5.6
+func InjectMounts(pid int, hookConfig *Config) error {
+
+	log.Printf("Injecting mounts %v into the mount namespace of pid %d\n", hookConfig.Mounts, pid)
+
+	for _, mount := range hookConfig.Mounts {
+		err := injectMount(pid, mount)
+		if err == unix.ENOSYS {
+			err = fmt.Errorf("open_tree not supported, mount injection requires Linux 5.6: %w", err)
+		}
+		if err != nil {
+			log.Printf("injecting mount (%s) threw error (%s)\n", mount.Destination, err)
+			return err
+		}
+		log.Printf("injected %s\n", mount.Destination)
+	}
+	return nil
+}
+
+// Mount in a private mount namespace, clone the mount and move the clone into the container
+func injectMount(pid int, mount specs.Mount) error {
+	// Bind mounts of files need a file as mountpoint
+	isDir := true
+	if info, err := os.Stat(mount.Source); err == nil && !info.IsDir() {
+		isDir = false
+	}
+
+	treeFd, err := stageMount(mount, isDir)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(treeFd)
+
+	return hookutil.MoveMountIntoContainer(pid, treeFd, mount.Destination, hookutil.MoveMountOptions{File: !isDir})
+}
+
+// Mount in a private mount namespace and return the clone of the mount as a detached tree
+// The mountpoint is a directory, or a file if not isDir
+func stageMount(mount specs.Mount, isDir bool) (int, error) {
+	stagingDir, err := os.MkdirTemp("", "generic-hook-inject")
+	if err != nil {
+		return -1, err
+	}
+	defer os.RemoveAll(stagingDir)
+	staging := filepath.Join(stagingDir, "mount")
+	if isDir {
+		err = os.Mkdir(staging, 0755)
+	} else {
+		err = os.WriteFile(staging, nil, 0644)
+	}
+	if err != nil {
+		return -1, err
+	}
+
+	treeFd := -1
+	err = hookutil.RunOnDedicatedThread(func() error {
+		// The staging mount is never visible to the other namespaces
+		if err := unix.Unshare(unix.CLONE_FS | unix.CLONE_NEWNS); err != nil {
+			return err
+		}
+		if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+			return err
+		}
+		if err := sysmount.Mount(mount.Source, staging, mount.Type, ConvertOptionsToString(mount.Options)); err != nil {
+			return err
+		}
+		fd, err := unix.OpenTree(unix.AT_FDCWD, staging, unix.OPEN_TREE_CLONE|unix.OPEN_TREE_CLOEXEC|unix.AT_RECURSIVE)
+		if err != nil {
+			return err
+		}
+		treeFd = fd
+		return nil
+	})
+	return treeFd, err
+}
@@ -0,0 +1,29 @@
+package internal
+
+import (
+	"os"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+func TestStageMountWithoutOptions(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("requires root")
+	}
+
+	treeFd, err := stageMount(specs.Mount{Source: "tmpfs", Type: "tmpfs"}, true)
+	if err != nil {
+		t.Fatalf("stageMount() error = %v", err)
+	}
+	defer unix.Close(treeFd)
+
+	var statfs unix.Statfs_t
+	if err := unix.Fstatfs(treeFd, &statfs); err != nil {
+		t.Fatal(err)
+	}
+	if statfs.Type != unix.TMPFS_MAGIC {
+		t.Errorf("staged mount type = %x, want tmpfs", statfs.Type)
+	}
+}
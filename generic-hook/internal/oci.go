@@ -55,6 +55,10 @@ func AddMountsToOciSpec(containerConfig *specs.Spec, hookConfig *Config) error {
 
 // Method to add hookConfig devices to the containerConfig devices
 func AddDevicesToOciSpec(containerConfig *specs.Spec, hookConfig *Config) error {
+	if containerConfig.Linux == nil {
+		containerConfig.Linux = &specs.Linux{}
+	}
+
 	// Add the hookConfig devices to the containerConfig devices
 	containerConfig.Linux.Devices = append(containerConfig.Linux.Devices, hookConfig.Devices...)
 
@@ -90,16 +94,25 @@ func AddDeviceWhitelistToOciSpec(containerConfig *specs.Spec, hookConfig *Config
 			}
 	*/
 
+	if containerConfig.Linux == nil {
+		containerConfig.Linux = &specs.Linux{}
+	}
+	if containerConfig.Linux.Resources == nil {
+		containerConfig.Linux.Resources = &specs.LinuxResources{}
+	}
+
 	// Loop through the hookConfig.Devices
 	for _, device := range hookConfig.Devices {
+		// Copy major and minor, device is reused by the loop
+		major, minor := device.Major, device.Minor
 
 		// Populate the deviceCgroup struct members from the device members
 		deviceCgroup := specs.LinuxDeviceCgroup{
 			Allow:  true,
 			Access: "rwm",
 			Type:   device.Type,
-			Major:  &device.Major,
-			Minor:  &device.Minor,
+			Major:  &major,
+			Minor:  &minor,
 		}
 
 		// Append the deviceCgroup to the containerConfig.Linux.Resources.Devices
@@ -110,3 +123,27 @@ func AddDeviceWhitelistToOciSpec(containerConfig *specs.Spec, hookConfig *Config
 	log.Printf("containerConfig.Linux.Resources.Devices: %v\n", containerConfig.Linux.Resources.Devices)
 	return nil
 }
+
+// Method to add the hookConfig device cgroup rules to the containerConfig
+func AddDeviceRulesToOciSpec(containerConfig *specs.Spec, hookConfig *Config) error {
+	if len(hookConfig.DeviceRules) == 0 {
+		return nil
+	}
+
+	if containerConfig.Linux == nil {
+		containerConfig.Linux = &specs.Linux{}
+	}
+	if containerConfig.Linux.Resources == nil {
+		containerConfig.Linux.Resources = &specs.LinuxResources{}
+	}
+
+	// Skip the rules already present, eg. added by AddDeviceWhitelistToOciSpec
+	for _, rule := range hookConfig.DeviceRules {
+		if !hasDeviceRule(containerConfig.Linux.Resources.Devices, rule) {
+			containerConfig.Linux.Resources.Devices = append(containerConfig.Linux.Resources.Devices, rule)
+		}
+	}
+
+	log.Printf("containerConfig.Linux.Resources.Devices: %v\n", containerConfig.Linux.Resources.Devices)
+	return nil
+}
@@ -0,0 +1,235 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+// Create a struct to hold a preset
+// A preset is a named bundle of devices, device cgroup rules, capabilities
+// and mounts that is merged into the hook configuration. The capabilities are
+// needed to use the devices and are granted along with them
+type Preset struct {
+	Devices      []specs.LinuxDevice       `json:"devices,omitempty"`
+	DeviceRules  []specs.LinuxDeviceCgroup `json:"device_rules,omitempty"`
+	Capabilities []string                  `json:"capabilities,omitempty"`
+	Mounts       []specs.Mount             `json:"mounts,omitempty"`
+
+	// Host character devices matching this pattern are added with their major and
+	// minor numbers, for the devices with a dynamic major number
+	HostDevices string `json:"host_devices,omitempty"`
+}
+
+// Built-in presets for common device bundles
+var presets = map[string]Preset{
+	"fuse": {
+		Devices:      []specs.LinuxDevice{charDevice("/dev/fuse", 10, 229)},
+		DeviceRules:  []specs.LinuxDeviceCgroup{allowCharDevice(10, 229)},
+		Capabilities: []string{"CAP_SYS_ADMIN"},
+	},
+	"kvm": {
+		Devices:     []specs.LinuxDevice{charDevice("/dev/kvm", 10, 232)},
+		DeviceRules: []specs.LinuxDeviceCgroup{allowCharDevice(10, 232)},
+	},
+	"tun": {
+		Devices:      []specs.LinuxDevice{charDevice("/dev/net/tun", 10, 200)},
+		DeviceRules:  []specs.LinuxDeviceCgroup{allowCharDevice(10, 200)},
+		Capabilities: []string{"CAP_NET_ADMIN"},
+	},
+	"vhost-net": {
+		Devices:      []specs.LinuxDevice{charDevice("/dev/vhost-net", 10, 238)},
+		DeviceRules:  []specs.LinuxDeviceCgroup{allowCharDevice(10, 238)},
+		Capabilities: []string{"CAP_NET_ADMIN"},
+	},
+	// The IOMMU group devices have a dynamic major number, they are read from
+	// the host nodes when the preset is expanded
+	"vfio": {
+		Devices:      []specs.LinuxDevice{charDevice("/dev/vfio/vfio", 10, 196)},
+		DeviceRules:  []specs.LinuxDeviceCgroup{allowCharDevice(10, 196)},
+		Capabilities: []string{"CAP_IPC_LOCK"},
+		HostDevices:  "/dev/vfio/*",
+	},
+}
+
+// Return the names of the built-in presets
+func PresetNames() []string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Method to expand the presets listed in hookConfig.Presets
+// The preset contents are appended to the hookConfig, the capabilities to
+// hookConfig.PresetCapabilities. Entries already present in the hookConfig
+// (same device path, mount destination or capability) are kept
+func ExpandPresets(hookConfig *Config) error {
+
+	for _, name := range hookConfig.Presets {
+		preset, err := expandPreset(name)
+		if err != nil {
+			return err
+		}
+
+		for _, device := range preset.Devices {
+			if !hasDevice(hookConfig.Devices, device.Path) {
+				hookConfig.Devices = append(hookConfig.Devices, device)
+			}
+		}
+		for _, rule := range preset.DeviceRules {
+			if !hasDeviceRule(hookConfig.DeviceRules, rule) {
+				hookConfig.DeviceRules = append(hookConfig.DeviceRules, rule)
+			}
+		}
+		for _, capability := range preset.Capabilities {
+			if !containsString(hookConfig.PresetCapabilities, capability) {
+				hookConfig.PresetCapabilities = append(hookConfig.PresetCapabilities, capability)
+			}
+		}
+		for _, mount := range preset.Mounts {
+			if !hasMount(hookConfig.Mounts, mount.Destination) {
+				hookConfig.Mounts = append(hookConfig.Mounts, mount)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Get the preset name with its host devices added to its devices and device rules
+func expandPreset(name string) (Preset, error) {
+	preset, ok := presets[name]
+	if !ok {
+		return Preset{}, fmt.Errorf("unknown preset %q, valid presets are %v", name, PresetNames())
+	}
+
+	log.Printf("Expanding preset %s\n", name)
+
+	hostDevices, err := hostCharDevices(preset.HostDevices)
+	if err != nil {
+		return Preset{}, fmt.Errorf("preset %q: %w", name, err)
+	}
+
+	// Don't share the slices of the built-in preset
+	preset.Devices = append([]specs.LinuxDevice(nil), preset.Devices...)
+	preset.DeviceRules = append([]specs.LinuxDeviceCgroup(nil), preset.DeviceRules...)
+	for _, device := range hostDevices {
+		if hasDevice(preset.Devices, device.Path) {
+			continue
+		}
+		preset.Devices = append(preset.Devices, device)
+		rule := allowCharDevice(device.Major, device.Minor)
+		if !hasDeviceRule(preset.DeviceRules, rule) {
+			preset.DeviceRules = append(preset.DeviceRules, rule)
+		}
+	}
+	preset.HostDevices = ""
+	return preset, nil
+}
+
+// Create a character device entry with 0666 permissions owned by root
+func charDevice(path string, major int64, minor int64) specs.LinuxDevice {
+	fileMode := os.FileMode(0666)
+	var uid, gid uint32
+	return specs.LinuxDevice{
+		Path:     path,
+		Type:     "c",
+		Major:    major,
+		Minor:    minor,
+		FileMode: &fileMode,
+		UID:      &uid,
+		GID:      &gid,
+	}
+}
+
+// Get the host character devices matching pattern, with the numbers, mode and owner
+// of the host nodes. Nothing when pattern is empty or matches no device
+func hostCharDevices(pattern string) ([]specs.LinuxDevice, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var devices []specs.LinuxDevice
+	for _, path := range paths {
+		var stat unix.Stat_t
+		if err := unix.Stat(path, &stat); err != nil {
+			return nil, err
+		}
+		if stat.Mode&unix.S_IFMT != unix.S_IFCHR {
+			log.Printf("skipping %s, not a character device\n", path)
+			continue
+		}
+		fileMode := os.FileMode(stat.Mode & 0777)
+		uid, gid := stat.Uid, stat.Gid
+		devices = append(devices, specs.LinuxDevice{
+			Path:     path,
+			Type:     "c",
+			Major:    int64(unix.Major(uint64(stat.Rdev))),
+			Minor:    int64(unix.Minor(uint64(stat.Rdev))),
+			FileMode: &fileMode,
+			UID:      &uid,
+			GID:      &gid,
+		})
+	}
+	if len(devices) == 0 {
+		log.Printf("no host devices match %s\n", pattern)
+	}
+	return devices, nil
+}
+
+// Create a device cgroup rule allowing a character device
+func allowCharDevice(major int64, minor int64) specs.LinuxDeviceCgroup {
+	return specs.LinuxDeviceCgroup{
+		Allow:  true,
+		Type:   "c",
+		Major:  &major,
+		Minor:  &minor,
+		Access: "rwm",
+	}
+}
+
+func hasDevice(devices []specs.LinuxDevice, path string) bool {
+	for _, device := range devices {
+		if device.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+func hasDeviceRule(rules []specs.LinuxDeviceCgroup, rule specs.LinuxDeviceCgroup) bool {
+	for _, r := range rules {
+		if r.Allow == rule.Allow && r.Type == rule.Type && r.Access == rule.Access &&
+			equalInt64Ptr(r.Major, rule.Major) && equalInt64Ptr(r.Minor, rule.Minor) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasMount(mounts []specs.Mount, destination string) bool {
+	for _, mount := range mounts {
+		if mount.Destination == destination {
+			return true
+		}
+	}
+	return false
+}
+
+func equalInt64Ptr(a *int64, b *int64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
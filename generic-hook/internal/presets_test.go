@@ -0,0 +1,145 @@
+package internal
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func TestExpandPresets(t *testing.T) {
+	tests := []struct {
+		name             string
+		hookConfig       Config
+		wantDevices      []string
+		wantRules        int
+		wantCapabilities []string
+		wantMounts       []string
+		wantErr          bool
+	}{
+		{
+			name: "no presets",
+		},
+		{
+			name:             "fuse",
+			hookConfig:       Config{Presets: []string{"fuse"}},
+			wantDevices:      []string{"/dev/fuse"},
+			wantRules:        1,
+			wantCapabilities: []string{"CAP_SYS_ADMIN"},
+		},
+		{
+			name:             "host devices added with their numbers",
+			hookConfig:       Config{Presets: []string{"test-host-devices"}},
+			wantDevices:      []string{"/dev/null", "/dev/zero"},
+			wantRules:        2,
+			wantCapabilities: []string{"CAP_IPC_LOCK"},
+		},
+		{
+			name:             "shared capability added once",
+			hookConfig:       Config{Presets: []string{"tun", "vhost-net"}},
+			wantDevices:      []string{"/dev/net/tun", "/dev/vhost-net"},
+			wantRules:        2,
+			wantCapabilities: []string{"CAP_NET_ADMIN"},
+		},
+		{
+			name: "hook config entries are kept",
+			hookConfig: Config{
+				Presets: []string{"kvm"},
+				Devices: []specs.LinuxDevice{{Path: "/dev/kvm", Type: "c", Major: 10, Minor: 232}},
+			},
+			wantDevices: []string{"/dev/kvm"},
+			wantRules:   1,
+		},
+		{
+			name:       "unknown preset",
+			hookConfig: Config{Presets: []string{"gpu"}},
+			wantErr:    true,
+		},
+	}
+
+	// Expand a copy of the built-in presets with a test preset
+	builtinPresets := presets
+	presets = make(map[string]Preset, len(builtinPresets)+1)
+	for name, preset := range builtinPresets {
+		presets[name] = preset
+	}
+	t.Cleanup(func() { presets = builtinPresets })
+
+	// Matches /dev/null and /dev/zero, the static device is not added twice
+	presets["test-host-devices"] = Preset{
+		Devices:      []specs.LinuxDevice{charDevice("/dev/null", 1, 3)},
+		DeviceRules:  []specs.LinuxDeviceCgroup{allowCharDevice(1, 3)},
+		Capabilities: []string{"CAP_IPC_LOCK"},
+		HostDevices:  "/dev/[nz][ue][lr][lo]",
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hookConfig := tt.hookConfig
+			err := ExpandPresets(&hookConfig)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ExpandPresets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			var devices, mounts []string
+			for _, device := range hookConfig.Devices {
+				devices = append(devices, device.Path)
+			}
+			for _, mount := range hookConfig.Mounts {
+				mounts = append(mounts, mount.Destination)
+			}
+			if !reflect.DeepEqual(devices, tt.wantDevices) {
+				t.Errorf("devices = %v, want %v", devices, tt.wantDevices)
+			}
+			if len(hookConfig.DeviceRules) != tt.wantRules {
+				t.Errorf("device rules = %v, want %d", hookConfig.DeviceRules, tt.wantRules)
+			}
+			if !reflect.DeepEqual(hookConfig.PresetCapabilities, tt.wantCapabilities) {
+				t.Errorf("preset capabilities = %v, want %v", hookConfig.PresetCapabilities, tt.wantCapabilities)
+			}
+			if !reflect.DeepEqual(mounts, tt.wantMounts) {
+				t.Errorf("mounts = %v, want %v", mounts, tt.wantMounts)
+			}
+		})
+	}
+}
+
+func TestHostCharDevices(t *testing.T) {
+	devices, err := hostCharDevices("/dev/zero")
+	if err != nil {
+		t.Fatalf("hostCharDevices() error = %v", err)
+	}
+	if len(devices) != 1 || devices[0].Major != 1 || devices[0].Minor != 5 || devices[0].Type != "c" {
+		t.Errorf("hostCharDevices() = %+v, want c 1:5", devices)
+	}
+
+	devices, err = hostCharDevices(filepath.Join(t.TempDir(), "*"))
+	if err != nil || len(devices) != 0 {
+		t.Errorf("hostCharDevices() of no device = %v, %v", devices, err)
+	}
+}
+
+func TestAddDeviceRulesToOciSpec(t *testing.T) {
+	hookConfig := &Config{Presets: []string{"fuse"}}
+	if err := ExpandPresets(hookConfig); err != nil {
+		t.Fatal(err)
+	}
+
+	// The device whitelist and the preset rule allow the same device, it is added once
+	containerConfig := &specs.Spec{}
+	if err := AddDeviceWhitelistToOciSpec(containerConfig, hookConfig); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddDeviceRulesToOciSpec(containerConfig, hookConfig); err != nil {
+		t.Fatal(err)
+	}
+
+	rules := containerConfig.Linux.Resources.Devices
+	if len(rules) != 1 || rules[0].Type != "c" || *rules[0].Major != 10 || *rules[0].Minor != 229 || !rules[0].Allow {
+		t.Errorf("device rules = %+v, want one rule allowing c 10:229", rules)
+	}
+}
@@ -14,14 +14,26 @@ func ConvertOptionsToString(options []string) string {
 		optionsString = optionsString + "," + option
 	}
 
-	// Remove the first comma
-	optionsString = optionsString[1:]
+	// Remove the first comma, if any
+	if optionsString != "" {
+		optionsString = optionsString[1:]
+	}
 
 	log.Printf("options string %s\n", optionsString)
 	// Return the options string
 	return optionsString
 }
 
+// Check if a string is present in a slice of strings
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
 func isDir(path string) bool {
 	info, err := os.Stat(path)
 	if err != nil {
@@ -15,6 +15,11 @@ func TestConvertOptionsToString(t *testing.T) {
 			options:  []string{},
 			expected: "",
 		},
+		{
+			name:     "no options",
+			options:  nil,
+			expected: "",
+		},
 		{
 			name:     "single option",
 			options:  []string{"foo"},
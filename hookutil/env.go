@@ -0,0 +1,66 @@
+package hookutil
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// Method to render the env templates with the facts
+// Returns a sorted slice of key=value strings
+func RenderEnv(env map[string]string, facts interface{}) ([]string, error) {
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	funcs := template.FuncMap{"join": strings.Join}
+
+	var rendered []string
+	for _, key := range keys {
+		tmpl, err := template.New(key).Funcs(funcs).Option("missingkey=error").Parse(env[key])
+		if err != nil {
+			log.Printf("unable to parse env template %s %s\n", key, err)
+			return nil, err
+		}
+
+		var value bytes.Buffer
+		if err := tmpl.Execute(&value, facts); err != nil {
+			log.Printf("unable to render env template %s %s\n", key, err)
+			return nil, err
+		}
+		rendered = append(rendered, key+"="+value.String())
+	}
+
+	return rendered, nil
+}
+
+// Method to add env to the containerConfig process env
+// Entries with an existing key are overridden
+func AddEnvToOciSpec(containerConfig *specs.Spec, env []string) error {
+	if containerConfig.Process == nil {
+		return fmt.Errorf("container spec has no process")
+	}
+
+	for _, entry := range env {
+		key := strings.SplitN(entry, "=", 2)[0]
+		replaced := false
+		for i, existing := range containerConfig.Process.Env {
+			if strings.SplitN(existing, "=", 2)[0] == key {
+				containerConfig.Process.Env[i] = entry
+				replaced = true
+			}
+		}
+		if !replaced {
+			containerConfig.Process.Env = append(containerConfig.Process.Env, entry)
+		}
+	}
+
+	log.Printf("containerConfig.Process.Env updated with %d entries\n", len(env))
+	return nil
+}
@@ -0,0 +1,83 @@
+package hookutil
+
+import (
+	"io"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// Discard the log of the package
+func TestMain(m *testing.M) {
+	log.Out = io.Discard
+	os.Exit(m.Run())
+}
+
+func TestRenderEnv(t *testing.T) {
+	facts := struct {
+		ContainerID string
+		Mounts      []string
+	}{ContainerID: "abc", Mounts: []string{"/data", "/cache"}}
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    []string
+		wantErr bool
+	}{
+		{name: "no env"},
+		{name: "plain value", env: map[string]string{"HOOK": "true"}, want: []string{"HOOK=true"}},
+		{
+			name: "sorted by key",
+			env:  map[string]string{"B_ID": "{{ .ContainerID }}", "A_MOUNTS": "{{ join .Mounts \":\" }}"},
+			want: []string{"A_MOUNTS=/data:/cache", "B_ID=abc"},
+		},
+		{name: "unknown fact", env: map[string]string{"HOOK": "{{ .Devices }}"}, wantErr: true},
+		{name: "invalid template", env: map[string]string{"HOOK": "{{ .ContainerID"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := RenderEnv(tt.env, facts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RenderEnv() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RenderEnv() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddEnvToOciSpec(t *testing.T) {
+	tests := []struct {
+		name    string
+		process *specs.Process
+		env     []string
+		want    []string
+		wantErr bool
+	}{
+		{name: "no process", env: []string{"HOOK=1"}, wantErr: true},
+		{name: "added", process: &specs.Process{Env: []string{"PATH=/bin"}}, env: []string{"HOOK=1"}, want: []string{"PATH=/bin", "HOOK=1"}},
+		{name: "overridden", process: &specs.Process{Env: []string{"HOOK=0", "PATH=/bin"}}, env: []string{"HOOK=1"}, want: []string{"HOOK=1", "PATH=/bin"}},
+		{name: "key prefix is another key", process: &specs.Process{Env: []string{"HOOKS=0"}}, env: []string{"HOOK=1"}, want: []string{"HOOKS=0", "HOOK=1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			containerConfig := &specs.Spec{Process: tt.process}
+			err := AddEnvToOciSpec(containerConfig, tt.env)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("AddEnvToOciSpec() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(containerConfig.Process.Env, tt.want) {
+				t.Errorf("env = %v, want %v", containerConfig.Process.Env, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package hookutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// Method to write the facts of the hook hookName to the facts file in the container rootfs
+// The facts file is shared by the hooks, each hook writes its facts under its own name.
+// factsFile is resolved in rootfsPath, so the symlinks of the rootfs can't escape it, and
+// must not be a symlink itself. The read-modify-write is done under a flock of the file
+func WriteFactsFile(rootfsPath string, factsFile string, hookName string, facts interface{}) error {
+	factsFile = filepath.Clean("/" + factsFile)
+	if factsFile == "/" {
+		return fmt.Errorf("invalid facts file %q", factsFile)
+	}
+
+	rootFd, err := OpenRoot(rootfsPath)
+	if err != nil {
+		log.Printf("unable to open rootfs %s\n", err)
+		return err
+	}
+	defer unix.Close(rootFd)
+
+	dirFd, err := OpenInRoot(rootFd, filepath.Dir(factsFile))
+	if err != nil {
+		log.Printf("unable to create facts file directory %s\n", err)
+		return err
+	}
+	defer unix.Close(dirFd)
+
+	fd, err := unix.Openat(dirFd, filepath.Base(factsFile), unix.O_RDWR|unix.O_CREAT|unix.O_NOFOLLOW|unix.O_NONBLOCK|unix.O_CLOEXEC, 0644)
+	if err != nil {
+		log.Printf("unable to open facts file %s\n", err)
+		return err
+	}
+	file := os.NewFile(uintptr(fd), factsFile)
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("facts file %s is not a regular file", factsFile)
+	}
+
+	// The hooks of a container may run concurrently
+	if err := unix.Flock(fd, unix.LOCK_EX); err != nil {
+		log.Printf("unable to lock facts file %s\n", err)
+		return err
+	}
+	defer unix.Flock(fd, unix.LOCK_UN)
+
+	// Keep the facts of the other hooks
+	allFacts := make(map[string]json.RawMessage)
+	if data, err := io.ReadAll(file); err != nil {
+		return err
+	} else if len(data) != 0 {
+		if err := json.Unmarshal(data, &allFacts); err != nil {
+			log.Printf("ignoring unparsable facts file %s\n", err)
+			allFacts = make(map[string]json.RawMessage)
+		}
+	}
+
+	data, err := json.Marshal(facts)
+	if err != nil {
+		return err
+	}
+	allFacts[hookName] = data
+
+	data, err = json.MarshalIndent(allFacts, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := file.WriteAt(data, 0); err != nil {
+		log.Printf("unable to write facts file %s\n", err)
+		return err
+	}
+
+	log.Printf("facts written to %s in %s\n", factsFile, rootfsPath)
+	return nil
+}
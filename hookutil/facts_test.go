@@ -0,0 +1,144 @@
+package hookutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// Read the facts file in the rootfs
+func readFacts(t *testing.T, path string) map[string]json.RawMessage {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	facts := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &facts); err != nil {
+		t.Fatalf("unparsable facts file %q %s", data, err)
+	}
+	return facts
+}
+
+func TestWriteFactsFile(t *testing.T) {
+	rootfs := t.TempDir()
+
+	if err := WriteFactsFile(rootfs, "/run/kata-hooks/facts.json", "generic-hook", map[string]string{"a": "1"}); err != nil {
+		t.Fatalf("WriteFactsFile() error = %v", err)
+	}
+	if err := WriteFactsFile(rootfs, "run/kata-hooks/facts.json", "vfio-hook", []string{"b"}); err != nil {
+		t.Fatalf("WriteFactsFile() error = %v", err)
+	}
+	// The facts of a hook are replaced
+	if err := WriteFactsFile(rootfs, "/run/kata-hooks/facts.json", "generic-hook", map[string]string{"a": "2"}); err != nil {
+		t.Fatalf("WriteFactsFile() error = %v", err)
+	}
+
+	facts := readFacts(t, filepath.Join(rootfs, "run/kata-hooks/facts.json"))
+	want := map[string]string{"generic-hook": `{"a":"2"}`, "vfio-hook": `["b"]`}
+	if len(facts) != len(want) {
+		t.Errorf("facts = %s, want %v", facts, want)
+	}
+	for hook, value := range want {
+		var got bytes.Buffer
+		if err := json.Compact(&got, facts[hook]); err != nil || got.String() != value {
+			t.Errorf("facts of %s = %s, want %s", hook, facts[hook], value)
+		}
+	}
+}
+
+func TestWriteFactsFileConcurrently(t *testing.T) {
+	rootfs := t.TempDir()
+	hooks := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+
+	var wg sync.WaitGroup
+	for _, hook := range hooks {
+		wg.Add(1)
+		go func(hook string) {
+			defer wg.Done()
+			if err := WriteFactsFile(rootfs, "/facts.json", hook, hook); err != nil {
+				t.Errorf("WriteFactsFile() error = %v", err)
+			}
+		}(hook)
+	}
+	wg.Wait()
+
+	if facts := readFacts(t, filepath.Join(rootfs, "facts.json")); len(facts) != len(hooks) {
+		t.Errorf("facts = %s, want the facts of %d hooks", facts, len(hooks))
+	}
+}
+
+func TestWriteFactsFileInRoot(t *testing.T) {
+	tests := []struct {
+		name      string
+		setup     func(t *testing.T, rootfs string, outside string)
+		factsFile string
+		// Path of the facts file in the rootfs, empty when refused
+		want string
+	}{
+		{
+			name: "absolute symlink directory",
+			setup: func(t *testing.T, rootfs string, outside string) {
+				if err := os.Symlink(outside, filepath.Join(rootfs, "run")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			factsFile: "/run/facts.json",
+			want:      filepath.Join("run-target", "facts.json"),
+		},
+		{
+			name: "relative symlink directory",
+			setup: func(t *testing.T, rootfs string, outside string) {
+				if err := os.Symlink("../../../../../../../..", filepath.Join(rootfs, "run")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			factsFile: "/run/facts.json",
+			want:      "facts.json",
+		},
+		{
+			name:      "dot dot",
+			factsFile: "/../../facts.json",
+			want:      "facts.json",
+		},
+		{
+			name: "symlink facts file",
+			setup: func(t *testing.T, rootfs string, outside string) {
+				if err := os.Symlink(filepath.Join(outside, "facts.json"), filepath.Join(rootfs, "facts.json")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			factsFile: "/facts.json",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rootfs := t.TempDir()
+			outside := t.TempDir()
+			if tt.setup != nil {
+				tt.setup(t, rootfs, outside)
+			}
+			// The target of the absolute symlink in the rootfs
+			if err := os.MkdirAll(filepath.Join(rootfs, outside), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Symlink(filepath.Join(rootfs, outside), filepath.Join(rootfs, "run-target")); err != nil {
+				t.Fatal(err)
+			}
+
+			err := WriteFactsFile(rootfs, tt.factsFile, "hook", "facts")
+			if (err != nil) != (tt.want == "") {
+				t.Fatalf("WriteFactsFile() error = %v", err)
+			}
+			if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+				t.Errorf("facts file written outside the rootfs")
+			}
+			if tt.want != "" {
+				readFacts(t, filepath.Join(rootfs, tt.want))
+			}
+		})
+	}
+}
@@ -0,0 +1,9 @@
+module github.com/kata-hooks/hookutil
+
+go 1.20
+
+require (
+	github.com/opencontainers/runtime-spec v1.0.2
+	github.com/sirupsen/logrus v1.6.0
+	golang.org/x/sys v0.0.0-20220520151302-bc2c85ada10a
+)
@@ -0,0 +1,14 @@
+// Package hookutil holds the helpers shared by the OCI hooks
+package hookutil
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+// Add a logger to the package, set by the hooks with SetLogger
+var log = logrus.New()
+
+// Set the logger
+func SetLogger(logger *logrus.Logger) {
+	log = logger
+}
@@ -0,0 +1,146 @@
+package hookutil
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+// Create a struct to hold how a detached mount is attached in a container, see MoveMountIntoContainer
+type MoveMountOptions struct {
+	// The mountpoint is a file instead of a directory, eg. for bind mounts of files
+	File bool
+
+	// Lazily unmount the mounts stacked on the mountpoint first, eg. of a dead fuse daemon
+	Replace bool
+
+	// Run in the container mount namespace once attached, with the parent directory of
+	// the mountpoint as working directory
+	AfterMove func() error
+}
+
+// Method to attach the detached mount treeFd at containerPath in the mount namespace of pid
+// containerPath is resolved in the container root, so symlinks can't escape it. The missing
+// directories are created, and the mountpoint as a directory or a file. Requires Linux 5.6
+func MoveMountIntoContainer(pid int, treeFd int, containerPath string, options MoveMountOptions) error {
+	containerPath = filepath.Clean("/" + containerPath)
+	if containerPath == "/" {
+		return fmt.Errorf("invalid container mountpoint %q", containerPath)
+	}
+
+	// Open the parent of the mountpoint in the container root
+	rootFd, err := OpenRoot(fmt.Sprintf("/proc/%d/root", pid))
+	if err != nil {
+		log.Printf("unable to open root of pid %d %s\n", pid, err)
+		return err
+	}
+	defer unix.Close(rootFd)
+
+	parentFd, err := OpenInRoot(rootFd, filepath.Dir(containerPath))
+	if err != nil {
+		log.Printf("unable to open %s in the container %s\n", filepath.Dir(containerPath), err)
+		return err
+	}
+	defer unix.Close(parentFd)
+
+	base := filepath.Base(containerPath)
+	if err := createMountPoint(parentFd, base, options.File); err != nil {
+		log.Printf("unable to create %s in the container %s\n", containerPath, err)
+		return err
+	}
+
+	return InMountNamespace(pid, func() error {
+		if err := unix.Fchdir(parentFd); err != nil {
+			return err
+		}
+
+		// Unmount all the stale mounts stacked on the mountpoint
+		for options.Replace {
+			err := unix.Unmount(base, unix.MNT_DETACH|unix.UMOUNT_NOFOLLOW)
+			if err == unix.EINVAL || err == unix.ENOENT {
+				break
+			}
+			if err != nil {
+				log.Printf("unable to unmount %s in the container %s\n", containerPath, err)
+				return err
+			}
+		}
+
+		if err := unix.MoveMount(treeFd, "", parentFd, base, unix.MOVE_MOUNT_F_EMPTY_PATH); err != nil {
+			log.Printf("unable to mount at %s in the container %s\n", containerPath, err)
+			return err
+		}
+		if options.AfterMove != nil {
+			if err := options.AfterMove(); err != nil {
+				log.Printf("unable to remount %s in the container %s\n", containerPath, err)
+				return err
+			}
+		}
+		log.Printf("mounted at %s in the mount namespace of pid %d\n", containerPath, pid)
+		return nil
+	})
+}
+
+// Create the mountpoint name in the directory parentFd, if missing
+func createMountPoint(parentFd int, name string, file bool) error {
+	if !file {
+		err := unix.Mkdirat(parentFd, name, 0755)
+		if err == unix.EEXIST {
+			return nil
+		}
+		return err
+	}
+	fd, err := unix.Openat(parentFd, name, unix.O_CREAT|unix.O_RDONLY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0644)
+	if err == unix.EEXIST || err == unix.ELOOP {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return unix.Close(fd)
+}
+
+// Run fn in the mount namespace of pid, on a dedicated thread
+func InMountNamespace(pid int, fn func() error) error {
+	mntNs, err := os.Open(fmt.Sprintf("/proc/%d/ns/mnt", pid))
+	if err != nil {
+		log.Printf("unable to open mount namespace of pid %d %s\n", pid, err)
+		return err
+	}
+	defer mntNs.Close()
+
+	// Entering a mount namespace changes the root and cwd of the thread, never reuse it
+	return RunOnDedicatedThread(func() error {
+		if err := unix.Unshare(unix.CLONE_FS); err != nil {
+			log.Printf("unable to unshare fs attributes %s\n", err)
+			return err
+		}
+		if err := unix.Setns(int(mntNs.Fd()), unix.CLONE_NEWNS); err != nil {
+			log.Printf("unable to enter mount namespace of pid %d %s\n", pid, err)
+			return err
+		}
+		return fn()
+	})
+}
+
+// Run fn on a new OS thread that is terminated when fn returns
+// Use for per thread state, like namespaces, that must not leak to the other goroutines
+func RunOnDedicatedThread(fn func() error) error {
+	errCh := make(chan error, 1)
+	go func() {
+		// Never unlocked, so the thread exits with the goroutine
+		runtime.LockOSThread()
+		if unix.Gettid() == unix.Getpid() {
+			// The main thread is not terminated but kept, and /proc/self shows it.
+			// Hold it while fn runs on another thread
+			errCh <- RunOnDedicatedThread(fn)
+			runtime.UnlockOSThread()
+			return
+		}
+		errCh <- fn()
+	}()
+	return <-errCh
+}
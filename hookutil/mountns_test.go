@@ -0,0 +1,118 @@
+package hookutil
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestRunOnDedicatedThread(t *testing.T) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+
+	var tid int
+	err = RunOnDedicatedThread(func() error {
+		tid = unix.Gettid()
+		if err := unix.Unshare(unix.CLONE_FS); err != nil {
+			return err
+		}
+		return unix.Chdir(dir)
+	})
+	if err != nil {
+		t.Fatalf("RunOnDedicatedThread() error = %v", err)
+	}
+	if tid == unix.Getpid() {
+		t.Errorf("fn ran on the main thread")
+	}
+	// The thread state doesn't leak to the other goroutines
+	if got, _ := os.Getwd(); got != cwd {
+		t.Errorf("working directory = %s, want %s", got, cwd)
+	}
+}
+
+func TestMoveMountIntoContainer(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("entering a mount namespace requires root")
+	}
+	if _, err := exec.LookPath("unshare"); err != nil {
+		t.Skip("unshare not found")
+	}
+
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source")
+	if err := os.MkdirAll(source, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(source, "blob"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A container in its own mount namespace
+	cmd := exec.Command("unshare", "--mount", "--propagation", "private", "sleep", "30")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+	self, _ := os.Readlink("/proc/self/ns/mnt")
+	for i := 0; i < 100; i++ {
+		if other, _ := os.Readlink(fmt.Sprintf("/proc/%d/ns/mnt", cmd.Process.Pid)); other != self {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	pid := cmd.Process.Pid
+
+	tests := []struct {
+		name          string
+		source        string
+		containerPath string
+		options       MoveMountOptions
+		check         string
+	}{
+		{name: "directory", source: source, containerPath: filepath.Join(dir, "rootfs", "data"), check: "blob"},
+		{name: "file", source: filepath.Join(source, "blob"), containerPath: filepath.Join(dir, "rootfs", "blob"), options: MoveMountOptions{File: true}},
+		{name: "replaced", source: source, containerPath: filepath.Join(dir, "rootfs", "data"), options: MoveMountOptions{Replace: true}, check: "blob"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			treeFd, err := unix.OpenTree(unix.AT_FDCWD, tt.source, unix.OPEN_TREE_CLONE|unix.OPEN_TREE_CLOEXEC)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer unix.Close(treeFd)
+
+			if err := MoveMountIntoContainer(pid, treeFd, tt.containerPath, tt.options); err != nil {
+				t.Fatalf("MoveMountIntoContainer() error = %v", err)
+			}
+			data, err := os.ReadFile(filepath.Join(fmt.Sprintf("/proc/%d/root", pid), tt.containerPath, tt.check))
+			if err != nil || string(data) != "data" {
+				t.Errorf("mount in the container = %q, %v, want data", data, err)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "rootfs", "data", "blob")); !os.IsNotExist(err) {
+				t.Errorf("mounted in the hook mount namespace")
+			}
+		})
+	}
+
+	// The container root is not a mountpoint
+	treeFd, err := unix.OpenTree(unix.AT_FDCWD, source, unix.OPEN_TREE_CLONE|unix.OPEN_TREE_CLOEXEC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(treeFd)
+	if err := MoveMountIntoContainer(pid, treeFd, "/", MoveMountOptions{}); err == nil {
+		t.Errorf("MoveMountIntoContainer() mounted over the container root")
+	}
+}
@@ -0,0 +1,37 @@
+package hookutil
+
+import (
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// Open the directory at path in the root rootFd, creating the missing directories
+// path is resolved as if rootFd was the root directory, so neither ".." nor the
+// symlinks of the root, absolute or relative, can escape it. Requires Linux 5.6
+func OpenInRoot(rootFd int, path string) (int, error) {
+	how := &unix.OpenHow{
+		Flags:   unix.O_PATH | unix.O_DIRECTORY | unix.O_CLOEXEC,
+		Resolve: unix.RESOLVE_IN_ROOT | unix.RESOLVE_NO_MAGICLINKS,
+	}
+	fd, err := unix.Openat2(rootFd, path, how)
+	if err != unix.ENOENT || path == "/" {
+		return fd, err
+	}
+
+	parentFd, err := OpenInRoot(rootFd, filepath.Dir(path))
+	if err != nil {
+		return -1, err
+	}
+	err = unix.Mkdirat(parentFd, filepath.Base(path), 0755)
+	unix.Close(parentFd)
+	if err != nil && err != unix.EEXIST {
+		return -1, err
+	}
+	return unix.Openat2(rootFd, path, how)
+}
+
+// Open the directory rootPath as a root for OpenInRoot
+func OpenRoot(rootPath string) (int, error) {
+	return unix.Open(rootPath, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+}
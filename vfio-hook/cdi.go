@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	//https://github.com/cncf-tags/container-device-interface/blob/main/SPEC.md
+	cdiVersion = "0.5.0"
+	cdiKind    = "kata-hooks.io/vfio"
+	vfioDevDir = "/dev/vfio"
+)
+
+// PCI functions bound to vfio-pci show up as domain:bus:device.function links
+var bdfRegexp = regexp.MustCompile(`^[0-9a-f]{4}:[0-9a-f]{2}:[0-9a-f]{2}\.[0-7]$`)
+
+type cdiSpec struct {
+	Version string      `json:"cdiVersion"`
+	Kind    string      `json:"kind"`
+	Devices []cdiDevice `json:"devices"`
+}
+
+type cdiDevice struct {
+	Name           string            `json:"name"`
+	ContainerEdits cdiContainerEdits `json:"containerEdits"`
+}
+
+type cdiContainerEdits struct {
+	DeviceNodes []cdiDeviceNode `json:"deviceNodes,omitempty"`
+}
+
+type cdiDeviceNode struct {
+	Path        string `json:"path"`
+	Type        string `json:"type,omitempty"`
+	Major       int64  `json:"major"`
+	Minor       int64  `json:"minor"`
+	Permissions string `json:"permissions,omitempty"`
+}
+
+// Handle "cdi generate [-o file]"
+func runCdiCommand(args []string) error {
+	if len(args) == 0 || args[0] != "generate" {
+		return fmt.Errorf("usage: vfio-hook cdi generate [-o file]")
+	}
+
+	fs := flag.NewFlagSet("cdi generate", flag.ContinueOnError)
+	output := fs.String("o", "", "Path to the CDI spec file (default is stdout)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	spec, err := generateCdiSpec()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(spec, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if *output == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	log.Infof("Writing CDI spec to %s", *output)
+	return ioutil.WriteFile(*output, data, 0644)
+}
+
+// Create one CDI device per PCI function bound to vfio-pci
+// The device is named after its pci:bdf and gets the container (/dev/vfio/vfio)
+// and IOMMU group (/dev/vfio/<group>) device nodes. Devices whose IOMMU group or
+// device nodes can't be read are skipped
+func generateCdiSpec() (*cdiSpec, error) {
+
+	spec := &cdiSpec{
+		Version: cdiVersion,
+		Kind:    cdiKind,
+		Devices: []cdiDevice{},
+	}
+
+	entries, err := ioutil.ReadDir(vfioDeviceFile)
+	if err != nil {
+		log.Errorf("Unable to list vfio-pci devices %s", err)
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		bdf := entry.Name()
+		if !bdfRegexp.MatchString(bdf) {
+			continue
+		}
+
+		group, err := iommuGroup(bdf)
+		if err != nil {
+			log.Errorf("Reading iommu group for device(%s) returned error: %s", bdf, err)
+			continue
+		}
+
+		log.Infof("Found vfio device (%s) in iommu group %s", bdf, group)
+
+		nodes, err := vfioDeviceNodes(group)
+		if err != nil {
+			log.Errorf("Reading device nodes for device(%s) returned error: %s", bdf, err)
+			continue
+		}
+
+		spec.Devices = append(spec.Devices, cdiDevice{
+			Name:           bdf,
+			ContainerEdits: cdiContainerEdits{DeviceNodes: nodes},
+		})
+	}
+
+	return spec, nil
+}
+
+// Create the CDI device nodes of the vfio container and of an IOMMU group
+func vfioDeviceNodes(group string) ([]cdiDeviceNode, error) {
+	var nodes []cdiDeviceNode
+	for _, path := range []string{filepath.Join(vfioDevDir, "vfio"), filepath.Join(vfioDevDir, group)} {
+		node, err := charDeviceNode(path)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+// Create the CDI device node of a character device, with the major and minor of the host node
+// The IOMMU group devices have a dynamic major number, so it has to be read from the node
+func charDeviceNode(path string) (cdiDeviceNode, error) {
+	var stat unix.Stat_t
+	if err := unix.Stat(path, &stat); err != nil {
+		return cdiDeviceNode{}, err
+	}
+	if stat.Mode&unix.S_IFMT != unix.S_IFCHR {
+		return cdiDeviceNode{}, fmt.Errorf("%s is not a character device", path)
+	}
+
+	return cdiDeviceNode{
+		Path:        path,
+		Type:        "c",
+		Major:       int64(unix.Major(uint64(stat.Rdev))),
+		Minor:       int64(unix.Minor(uint64(stat.Rdev))),
+		Permissions: "rwm",
+	}, nil
+}
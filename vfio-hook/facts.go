@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kata-hooks/hookutil"
+	spec "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// Name of the hook in the facts file
+const factsHookName = "vfio-hook"
+
+// A PCI function bound to vfio-pci by the hook
+type vfioDevice struct {
+	PCIAddress   string `json:"pci_address"`
+	IOMMUGroup   string `json:"iommu_group"`
+	VendorDevice string `json:"vendor_device"`
+}
+
+// Get the IOMMU group number of a PCI function
+func iommuGroup(bdf string) (string, error) {
+	group, err := os.Readlink(filepath.Join(pciDeviceFile, bdf, "iommu_group"))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Base(group), nil
+}
+
+// Tell the workload which devices were bound to vfio-pci
+// VFIO_PCI_ADDRESSES and VFIO_GROUPS are added to the container env when injectEnv is set
+// and the devices are written to the facts file in the container rootfs when factsFile is set
+// Both come from the OCI spec in the bundle, not the runtime state in /run/libcontainer
+func publishFacts(bundlePath string, devices []vfioDevice, injectEnv bool, factsFile string) error {
+
+	configJsonPath := filepath.Join(bundlePath, "config.json")
+	jsonData, err := ioutil.ReadFile(configJsonPath)
+	if err != nil {
+		return err
+	}
+
+	var containerConfig spec.Spec
+	if err := json.Unmarshal(jsonData, &containerConfig); err != nil {
+		log.Errorf("unable to parse config.json %s", err)
+		return err
+	}
+
+	if factsFile != "" && containerConfig.Root != nil {
+		rootfsPath := containerConfig.Root.Path
+		if !filepath.IsAbs(rootfsPath) {
+			rootfsPath = filepath.Join(bundlePath, rootfsPath)
+		}
+		facts := struct {
+			Devices []vfioDevice `json:"devices"`
+		}{devices}
+		if err := hookutil.WriteFactsFile(rootfsPath, factsFile, factsHookName, facts); err != nil {
+			log.Errorf("unable to write facts file %s", err)
+			return err
+		}
+	}
+
+	if injectEnv && containerConfig.Process != nil {
+		var addresses, groups []string
+		for _, device := range devices {
+			addresses = append(addresses, device.PCIAddress)
+			groups = append(groups, device.IOMMUGroup)
+		}
+		env := []string{"VFIO_PCI_ADDRESSES=" + strings.Join(addresses, ","), "VFIO_GROUPS=" + strings.Join(groups, ",")}
+		if err := hookutil.AddEnvToOciSpec(&containerConfig, env); err != nil {
+			return err
+		}
+
+		jsonData, err = json.Marshal(containerConfig)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(configJsonPath, jsonData, 0644); err != nil {
+			log.Errorf("unable to write config.json %s", err)
+			return err
+		}
+		log.Infof("Added vfio env to %s", configJsonPath)
+	}
+
+	return nil
+}
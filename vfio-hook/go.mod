@@ -1,8 +1,12 @@
 module github.com/kata-hooks/vfio-hook
 
-go 1.13
+go 1.20
 
 require (
+	github.com/kata-hooks/hookutil v0.0.0
 	github.com/opencontainers/runtime-spec v1.0.2
 	github.com/sirupsen/logrus v1.6.0
+	golang.org/x/sys v0.0.0-20220520151302-bc2c85ada10a
 )
+
+replace github.com/kata-hooks/hookutil => ../hookutil
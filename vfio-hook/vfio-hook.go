@@ -10,6 +10,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/kata-hooks/hookutil"
 	spec "github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/sirupsen/logrus"
 )
@@ -42,9 +43,12 @@ func main() {
 	}
 	//logrus.SetLevel(logrus.DebugLevel)
 	log.Infof("Started VFIO OCI hook version %s", version)
+	hookutil.SetLogger(log)
 
 	start := flag.Bool("s", true, "Start the VFIO hook")
 	printVersion := flag.Bool("version", false, "Print the hook's version")
+	injectEnv := flag.Bool("env", false, "Add VFIO_PCI_ADDRESSES and VFIO_GROUPS to the container env")
+	factsFile := flag.String("facts-file", "", "Path of the facts file in the container rootfs, eg. /run/kata-hooks/facts.json")
 	flag.Parse()
 
 	if *printVersion {
@@ -52,9 +56,17 @@ func main() {
 		os.Exit(0)
 	}
 
+	if flag.Arg(0) == "cdi" {
+		if err := runCdiCommand(flag.Args()[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	if *start {
 		log.Info("Starting VFIO hook")
-		if err := startVfioOciHook(); err != nil {
+		if err := startVfioOciHook(*injectEnv, *factsFile); err != nil {
 			//Hook should not fail
 			//log.Fatal(err)
 			log.Info(err)
@@ -63,7 +75,7 @@ func main() {
 	}
 }
 
-func startVfioOciHook() error {
+func startVfioOciHook(injectEnv bool, factsFile string) error {
 	//Hook receives container State in Stdin
 	//https://github.com/opencontainers/runtime-spec/blob/master/config.md#posix-platform-hooks
 	//https://github.com/opencontainers/runtime-spec/blob/master/runtime.md#state
@@ -97,17 +109,26 @@ func startVfioOciHook() error {
 
 	log.Debugf("Config.json contents: %s", jsonData)
 
-	err = bindVFIO()
+	devices, err := bindVFIO()
 	if err != nil {
-		log.Infof("Error in binding device to vfio driver", err)
+		log.Infof("Error in binding device to vfio driver %s", err)
 		return err
 	}
 
+	if injectEnv || factsFile != "" {
+		err = publishFacts(bundlePath, devices, injectEnv, factsFile)
+		if err != nil {
+			log.Infof("Error in publishing vfio devices %s", err)
+			return err
+		}
+	}
+
 	return nil
 }
 
 //Bind each supported vendor:device to vfio-pci
-func bindVFIO() error {
+//Returns the devices bound to vfio-pci
+func bindVFIO() ([]vfioDevice, error) {
 
 	log.Infof("bindVFIO: Start")
 
@@ -118,10 +139,10 @@ func bindVFIO() error {
 
 	//For each matching key:"vendor:device", rebind driver
 	if len(devMap) != 0 {
-		doRebind(devMap)
+		return doRebind(devMap), nil
 	}
 
-	return nil
+	return nil, nil
 }
 
 //Create a Map of vendor:device and corresponding pci:bdf
@@ -160,7 +181,8 @@ func createDeviceMap() map[string]string {
 }
 
 //Rebind the devices to vfio-pci driver
-func doRebind(deviceMap map[string]string) error {
+func doRebind(deviceMap map[string]string) []vfioDevice {
+	var devices []vfioDevice
 
 	log.Infof("Rebinding driver for the devices")
 	//Find if supported vendor:device is there in the device map
@@ -170,7 +192,7 @@ func doRebind(deviceMap map[string]string) error {
 
 	for _, vd := range pciSupportedVendorDeviceList {
 		if bdf, found := deviceMap[vd]; found {
-			log.Infof("Found device ", bdf)
+			log.Infof("Found device %s", bdf)
 			//check if the device is already bound to VFIO
 			driverPath := filepath.Join(pciDeviceFile, bdf, "driver")
 			if _, err := os.Stat(driverPath); err == nil {
@@ -179,8 +201,10 @@ func doRebind(deviceMap map[string]string) error {
 					log.Errorf("Reading driver details for device(%s) returned error: %s", bdf, err)
 					continue
 				}
-				if string(driver) == "vfio-pci" {
+				// The link points to ../../../bus/pci/drivers/<driver>
+				if filepath.Base(driver) == "vfio-pci" {
 					log.Infof("Device (%s) is already bound to vfio", bdf)
+					devices = appendVfioDevice(devices, bdf, vd)
 					continue
 				} else {
 					log.Infof("Unbinding device (%s) from current driver", bdf)
@@ -202,7 +226,17 @@ func doRebind(deviceMap map[string]string) error {
 				continue
 			}
 			log.Infof("Successfully bound device(%s) to vfio", bdf)
+			devices = appendVfioDevice(devices, bdf, vd)
 		}
 	}
-	return nil
+	return devices
+}
+
+//Record a device bound to vfio-pci along with its IOMMU group
+func appendVfioDevice(devices []vfioDevice, bdf string, vd string) []vfioDevice {
+	group, err := iommuGroup(bdf)
+	if err != nil {
+		log.Errorf("Reading iommu group for device(%s) returned error: %s", bdf, err)
+	}
+	return append(devices, vfioDevice{PCIAddress: bdf, IOMMUGroup: group, VendorDevice: vd})
 }